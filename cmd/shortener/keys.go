@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/mikesvis/short/internal/config"
+	"github.com/mikesvis/short/internal/jwt"
+)
+
+const keysUsage = "usage: shortener [flags] keys [rotate | list]"
+
+// Подкоманда keys: ротация и просмотр ключей подписи токенов в файле ключей. При ротации новый ключ
+// сразу принимается для проверки токенов, а подписывать ими начинает через jwt.KeyActivationDelay -
+// к этому времени все запущенные экземпляры сервиса успевают перечитать файл ключей. Прежние ключи
+// остаются для проверки выданных токенов.
+func keys(c *config.Config, args []string, out io.Writer) error {
+	if len(c.JWTKeysFile) == 0 {
+		return fmt.Errorf("jwt keys file is not set")
+	}
+
+	command, err := parseKeysArgs(args)
+	if err != nil {
+		return err
+	}
+
+	set, err := jwt.LoadKeySet(c.JWTKeysFile)
+	// файла нет: ротация создает его с первым ключом
+	if errors.Is(err, fs.ErrNotExist) && command == "rotate" {
+		err = nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if command == "rotate" {
+		key, err := set.Rotate(time.Now())
+		if err != nil {
+			return err
+		}
+
+		if err := set.Save(c.JWTKeysFile); err != nil {
+			return err
+		}
+
+		if key.ActivatesAt.IsZero() {
+			fmt.Fprintf(out, "active key: %s\n", key.ID)
+			return nil
+		}
+
+		fmt.Fprintf(out, "new key: %s, signs tokens from %s\n", key.ID, key.ActivatesAt.Format("2006-01-02 15:04:05"))
+		return nil
+	}
+
+	now := time.Now()
+	signing := set.SigningKey(now)
+	for _, key := range set.Keys {
+		state := "valid"
+		switch {
+		case key.ID == signing:
+			state = "active"
+		case key.ActivatesAt.After(now):
+			state = "pending " + key.ActivatesAt.Format("2006-01-02 15:04:05")
+		case key.RetiredAt != nil:
+			state = "retired " + key.RetiredAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(out, "%-16s %s %s\n", key.ID, key.CreatedAt.Format("2006-01-02 15:04:05"), state)
+	}
+
+	return nil
+}
+
+// parseKeysArgs разбирает аргументы подкоманды keys. По-умолчанию выполняется list.
+func parseKeysArgs(args []string) (string, error) {
+	if len(args) == 0 {
+		return "list", nil
+	}
+
+	if len(args) > 1 {
+		return "", errors.New(keysUsage)
+	}
+
+	switch args[0] {
+	case "rotate", "list":
+		return args[0], nil
+	}
+
+	return "", errors.New(keysUsage)
+}
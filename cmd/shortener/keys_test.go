@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/mikesvis/short/internal/config"
+	"github.com/mikesvis/short/internal/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseKeysArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantCommand string
+		wantErr     bool
+	}{
+		{name: "No args means list", args: nil, wantCommand: "list"},
+		{name: "List", args: []string{"list"}, wantCommand: "list"},
+		{name: "Rotate", args: []string{"rotate"}, wantCommand: "rotate"},
+		{name: "Unknown command", args: []string{"revoke"}, wantErr: true},
+		{name: "Extra args", args: []string{"rotate", "now"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, err := parseKeysArgs(tt.args)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCommand, command)
+		})
+	}
+}
+
+func Test_keys(t *testing.T) {
+	c := &config.Config{JWTKeysFile: filepath.Join(t.TempDir(), "keys.json")}
+
+	// первая ротация создает файл ключей
+	require.NoError(t, keys(c, []string{"rotate"}, &bytes.Buffer{}))
+	first, err := jwt.LoadKeySet(c.JWTKeysFile)
+	require.NoError(t, err)
+	require.Len(t, first.Keys, 1)
+
+	require.NoError(t, keys(c, []string{"rotate"}, &bytes.Buffer{}))
+	second, err := jwt.LoadKeySet(c.JWTKeysFile)
+	require.NoError(t, err)
+	require.Len(t, second.Keys, 2)
+	assert.NotEqual(t, first.Active, second.Active)
+
+	var out bytes.Buffer
+	require.NoError(t, keys(c, nil, &out))
+	assert.Contains(t, out.String(), first.Active)
+	// новый ключ подписывает токены только после перечитывания файла ключей сервисом
+	assert.Contains(t, out.String(), "pending")
+	assert.Contains(t, out.String(), "active")
+
+	assert.Error(t, keys(&config.Config{}, []string{"rotate"}, &bytes.Buffer{}))
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"fmt"
+	"log"
 	_ "net/http/pprof"
+	"os"
 
 	"github.com/mikesvis/short/internal/app"
 	"github.com/mikesvis/short/internal/config"
+	flag "github.com/spf13/pflag"
 )
 
 var (
@@ -32,6 +35,20 @@ func main() {
 	fmt.Println()
 
 	config := config.NewConfig()
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(config, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "keys" {
+		if err := keys(config, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app := app.New(config)
 
 	app.Run()
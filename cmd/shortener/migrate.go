@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/mikesvis/short/internal/config"
+	"github.com/mikesvis/short/internal/drivers/postgres/migrations"
+	"github.com/mikesvis/short/internal/logger"
+	"github.com/mikesvis/short/internal/storage"
+)
+
+const migrateUsage = "usage: shortener [flags] migrate [up | down [N] | status]"
+
+// Подкоманда migrate: применение, откат и просмотр состояния миграций базы postgres.
+func migrate(c *config.Config, args []string, out io.Writer) error {
+	if len(c.DatabaseDSN) == 0 {
+		return fmt.Errorf("database dsn is not set")
+	}
+
+	command, steps, err := parseMigrateArgs(args)
+	if err != nil {
+		return err
+	}
+
+	logger, err := logger.NewLogger()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := storage.NewPostgresPool(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db, logger)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		return migrator.Down(ctx, steps)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(out, "%04d %-30s %s\n", s.Version, s.Name, applied)
+	}
+
+	return nil
+}
+
+// parseMigrateArgs разбирает аргументы подкоманды migrate. По-умолчанию выполняется up,
+// down без количества откатывает одну миграцию.
+func parseMigrateArgs(args []string) (string, int, error) {
+	if len(args) == 0 {
+		return "up", 0, nil
+	}
+
+	switch args[0] {
+	case "up", "status":
+		if len(args) > 1 {
+			return "", 0, errors.New(migrateUsage)
+		}
+		return args[0], 0, nil
+	case "down":
+		if len(args) == 1 {
+			return "down", 1, nil
+		}
+		steps, err := strconv.Atoi(args[1])
+		if err != nil || steps < 1 || len(args) > 2 {
+			return "", 0, errors.New(migrateUsage)
+		}
+		return "down", steps, nil
+	}
+
+	return "", 0, errors.New(migrateUsage)
+}
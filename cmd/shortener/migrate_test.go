@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseMigrateArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantCommand string
+		wantSteps   int
+		wantErr     bool
+	}{
+		{name: "No args means up", args: nil, wantCommand: "up"},
+		{name: "Up", args: []string{"up"}, wantCommand: "up"},
+		{name: "Status", args: []string{"status"}, wantCommand: "status"},
+		{name: "Down without steps", args: []string{"down"}, wantCommand: "down", wantSteps: 1},
+		{name: "Down with steps", args: []string{"down", "3"}, wantCommand: "down", wantSteps: 3},
+		{name: "Down with bad steps", args: []string{"down", "zero"}, wantErr: true},
+		{name: "Down with negative steps", args: []string{"down", "-1"}, wantErr: true},
+		{name: "Unknown command", args: []string{"redo"}, wantErr: true},
+		{name: "Extra args", args: []string{"up", "1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, steps, err := parseMigrateArgs(tt.args)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCommand, command)
+			assert.Equal(t, tt.wantSteps, steps)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Модуль регистрации и проверки аккаунтов пользователей.
+package account
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikesvis/short/internal/domain"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Минимальная длина логина.
+const LoginMinLength = 3
+
+// Максимальная длина логина, совпадает с ограничением колонки login.
+const LoginMaxLength = 64
+
+// Минимальная длина пароля.
+const PasswordMinLength = 8
+
+// Максимальная длина пароля, которую обрабатывает bcrypt.
+const PasswordMaxLength = 72
+
+var loginRegexp = regexp.MustCompile(`^[a-z0-9_.@-]+$`)
+
+// Хеш для проверки пароля несуществующего аккаунта: bcrypt выполняется всегда, и по времени ответа
+// нельзя узнать, зарегистрирован ли логин.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// Приведение логина к виду, в котором он хранится: логины не зависят от регистра.
+func NormalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// Создание аккаунта userID с логином login и паролем password. Пароль хранится только в виде bcrypt хеша.
+func New(userID string, login string, password string, now time.Time) (domain.Account, error) {
+	login = NormalizeLogin(login)
+	if len(login) < LoginMinLength || len(login) > LoginMaxLength {
+		return domain.Account{}, fmt.Errorf("login must be from %d to %d characters long", LoginMinLength, LoginMaxLength)
+	}
+
+	if !loginRegexp.MatchString(login) {
+		return domain.Account{}, fmt.Errorf("login may contain only latin letters, digits and _.@- characters")
+	}
+
+	if len(password) < PasswordMinLength || len(password) > PasswordMaxLength {
+		return domain.Account{}, fmt.Errorf("password must be from %d to %d bytes long", PasswordMinLength, PasswordMaxLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return domain.Account{}, err
+	}
+
+	return domain.Account{
+		ID:           userID,
+		Login:        login,
+		PasswordHash: string(hash),
+		CreatedAt:    now.UTC(),
+	}, nil
+}
+
+// Проверка пароля аккаунта. Для пустого (ненайденного) аккаунта пароль сверяется с фиктивным хешем
+// за то же время и не подходит.
+func CheckPassword(a domain.Account, password string) bool {
+	if len(a.ID) == 0 || len(a.PasswordHash) == 0 {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(a.PasswordHash), []byte(password)) == nil
+}
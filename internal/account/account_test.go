@@ -0,0 +1,53 @@
+package account
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		login     string
+		password  string
+		wantLogin string
+		wantErr   bool
+	}{
+		{name: "Valid account", login: "doomguy", password: "iddqd-idkfa", wantLogin: "doomguy"},
+		{name: "Login is normalized", login: " DoomGuy@UAC.mars ", password: "iddqd-idkfa", wantLogin: "doomguy@uac.mars"},
+		{name: "Too short login", login: "dg", password: "iddqd-idkfa", wantErr: true},
+		{name: "Too long login", login: strings.Repeat("d", LoginMaxLength+1), password: "iddqd-idkfa", wantErr: true},
+		{name: "Bad login charset", login: "doom guy", password: "iddqd-idkfa", wantErr: true},
+		{name: "Too short password", login: "doomguy", password: "iddqd", wantErr: true},
+		{name: "Too long password", login: "doomguy", password: strings.Repeat("p", PasswordMaxLength+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New("user", tt.login, tt.password, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "user", a.ID)
+			assert.Equal(t, tt.wantLogin, a.Login)
+			assert.NotContains(t, a.PasswordHash, tt.password)
+			assert.Equal(t, now, a.CreatedAt)
+			assert.True(t, CheckPassword(a, tt.password))
+			assert.False(t, CheckPassword(a, tt.password+"!"))
+		})
+	}
+}
+
+func TestCheckPassword_UnknownAccount(t *testing.T) {
+	// ненайденный аккаунт проверяется так же долго, как существующий, и не проходит проверку
+	assert.False(t, CheckPassword(domain.Account{}, "dummy password"))
+	assert.False(t, CheckPassword(domain.Account{}, ""))
+}
@@ -0,0 +1,56 @@
+// Модуль проверки пользовательских коротких ключей (алиасов).
+package alias
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Минимальная длина алиаса.
+const MinLength = 3
+
+// Максимальная длина алиаса, короче ограничения колонки short_key (varchar(255)): алиас должен оставаться коротким.
+const MaxLength = 64
+
+var charsetRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Зарезервированные слова: пути роутера и служебные имена, которые нельзя занять алиасом.
+var reserved = map[string]struct{}{
+	"admin":    {},
+	"api":      {},
+	"debug":    {},
+	"health":   {},
+	"internal": {},
+	"login":    {},
+	"logout":   {},
+	"metrics":  {},
+	"ping":     {},
+	"register": {},
+	"static":   {},
+	"user":     {},
+}
+
+// Проверка алиаса: длина от MinLength до MaxLength, латинские буквы, цифры, "-" и "_",
+// слово не зарезервировано (без учета регистра).
+func Validate(alias string) error {
+	if len(alias) < MinLength || len(alias) > MaxLength {
+		return fmt.Errorf("alias length must be from %d to %d characters", MinLength, MaxLength)
+	}
+
+	if !charsetRegexp.MatchString(alias) {
+		return fmt.Errorf("alias may contain only latin letters, digits, \"-\" and \"_\"")
+	}
+
+	if IsReserved(alias) {
+		return fmt.Errorf("alias %s is reserved", alias)
+	}
+
+	return nil
+}
+
+// Проверка на зарезервированное слово.
+func IsReserved(alias string) bool {
+	_, exists := reserved[strings.ToLower(alias)]
+	return exists
+}
@@ -0,0 +1,36 @@
+package alias
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		alias   string
+		wantErr bool
+	}{
+		{name: "Valid alias", alias: "spring-sale", wantErr: false},
+		{name: "Valid alias with digits and underscore", alias: "Sale_2024", wantErr: false},
+		{name: "Too short", alias: "ab", wantErr: true},
+		{name: "Too long", alias: strings.Repeat("a", MaxLength+1), wantErr: true},
+		{name: "Bad charset", alias: "spring sale", wantErr: true},
+		{name: "Slash is not allowed", alias: "api/shorten", wantErr: true},
+		{name: "Cyrillic is not allowed", alias: "распродажа", wantErr: true},
+		{name: "Reserved word", alias: "ping", wantErr: true},
+		{name: "Reserved word in other case", alias: "DeBuG", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.alias)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Модуль аналитики переходов по коротким ссылкам.
+package analytics
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Формат даты в дневной статистике.
+const DateLayout = "2006-01-02"
+
+// Click - событие перехода по короткой ссылке.
+type Click struct {
+	// Короткий ключ ссылки.
+	Short string
+
+	// Время перехода.
+	Time time.Time
+
+	// Заголовок Referer запроса.
+	Referrer string
+
+	// Заголовок User-Agent запроса.
+	UserAgent string
+
+	// IP адрес клиента, возможно анонимизированный.
+	IP string
+}
+
+// DailyClicks - количество переходов за день (UTC).
+type DailyClicks struct {
+	Date   string
+	Clicks int
+}
+
+// Stats - статистика переходов по ссылке: всего переходов, уникальных посетителей (пара IP и User-Agent)
+// и переходы по дням.
+type Stats struct {
+	Total  int
+	Unique int
+	Daily  []DailyClicks
+}
+
+// Store - хранилка событий переходов.
+type Store interface {
+	// Сохранение пачки событий.
+	Save(ctx context.Context, clicks []Click) error
+
+	// Статистика по ссылке: Total и Unique за все время, Daily - только дни с переходами начиная с since.
+	Stats(ctx context.Context, short string, since time.Time) (Stats, error)
+}
+
+// Анонимизация IP адреса: у IPv4 обнуляется последний октет, у IPv6 остаются только первые 48 бит.
+// Строка, не являющаяся IP адресом, возвращается пустой.
+func AnonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// fillDays дополняет дневную статистику нулевыми днями с since по now включительно.
+func fillDays(daily []DailyClicks, since, now time.Time) []DailyClicks {
+	clicks := make(map[string]int, len(daily))
+	for _, d := range daily {
+		clicks[d.Date] = d.Clicks
+	}
+
+	result := []DailyClicks{}
+	last := now.UTC().Format(DateLayout)
+	for day := since.UTC(); ; day = day.AddDate(0, 0, 1) {
+		date := day.Format(DateLayout)
+		result = append(result, DailyClicks{Date: date, Clicks: clicks[date]})
+		if date == last {
+			break
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,128 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mikesvis/short/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{
+			name: "IPv4",
+			ip:   "192.168.10.42",
+			want: "192.168.10.0",
+		},
+		{
+			name: "IPv6",
+			ip:   "2001:db8:85a3:8d3:1319:8a2e:370:7348",
+			want: "2001:db8:85a3::",
+		},
+		{
+			name: "Not an IP",
+			ip:   "localhost",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, AnonymizeIP(tt.ip))
+		})
+	}
+}
+
+func Test_fillDays(t *testing.T) {
+	since := time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 2, 2, 15, 0, 0, 0, time.UTC)
+	got := fillDays([]DailyClicks{{Date: "2024-01-31", Clicks: 3}, {Date: "2024-02-02", Clicks: 1}}, since, now)
+
+	assert.Equal(t, []DailyClicks{
+		{Date: "2024-01-30", Clicks: 0},
+		{Date: "2024-01-31", Clicks: 3},
+		{Date: "2024-02-01", Clicks: 0},
+		{Date: "2024-02-02", Clicks: 1},
+	}, got)
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	err := s.Save(ctx, []Click{
+		{Short: "idkfa", Time: day.AddDate(0, 0, -10), IP: "10.0.0.1", UserAgent: "Doom"},
+		{Short: "idkfa", Time: day, IP: "10.0.0.1", UserAgent: "Doom"},
+		{Short: "idkfa", Time: day, IP: "10.0.0.1", UserAgent: "Quake"},
+		{Short: "idkfa", Time: day.AddDate(0, 0, 1), IP: "10.0.0.2", UserAgent: "Doom"},
+		{Short: "iddqd", Time: day, IP: "10.0.0.3", UserAgent: "Doom"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		short string
+		want  Stats
+	}{
+		{
+			name:  "Stats of link with clicks",
+			short: "idkfa",
+			want: Stats{
+				Total:  4,
+				Unique: 3,
+				Daily:  []DailyClicks{{Date: "2024-01-31", Clicks: 2}, {Date: "2024-02-01", Clicks: 1}},
+			},
+		},
+		{
+			name:  "Stats of link without clicks",
+			short: "idclip",
+			want:  Stats{Daily: []DailyClicks{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Stats(ctx, tt.short, day.AddDate(0, 0, -1))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	l, _ := logger.NewLogger()
+	store := NewMemoryStore()
+	r := NewRecorder(store, 2, true, l)
+
+	r.Record(Click{Short: "idkfa", Time: time.Now(), IP: "10.0.0.1"})
+	r.Record(Click{Short: "idkfa", Time: time.Now(), IP: "10.0.0.2"})
+	// буфер заполнен, событие отбрасывается без блокировки
+	r.Record(Click{Short: "idkfa", Time: time.Now(), IP: "10.0.0.3"})
+	assert.Equal(t, int64(1), r.Dropped())
+
+	// после остановки оставшиеся в буфере события сохраняются
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx)
+	<-r.Done()
+
+	stats, err := r.Stats(context.Background(), "idkfa", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Total)
+	// анонимизированные адреса из одной подсети неразличимы
+	assert.Equal(t, 1, stats.Unique)
+	assert.Len(t, stats.Daily, 7)
+	assert.Equal(t, 2, stats.Daily[6].Clicks)
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	var r *Recorder
+	assert.NotPanics(t, func() {
+		r.Record(Click{Short: "idkfa"})
+	})
+}
@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryStats struct {
+	total    int
+	visitors map[string]struct{}
+	daily    map[string]int
+}
+
+// MemoryStore - хранилка событий в памяти. События не хранятся целиком, по каждой ссылке
+// ведутся только агрегаты: счетчик, множество посетителей и счетчики по дням.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	stats map[string]*memoryStats
+}
+
+// Конструктор хранилки событий в памяти.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{stats: make(map[string]*memoryStats)}
+}
+
+// Сохранение пачки событий.
+func (s *MemoryStore) Save(ctx context.Context, clicks []Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range clicks {
+		st, exists := s.stats[c.Short]
+		if !exists {
+			st = &memoryStats{
+				visitors: make(map[string]struct{}),
+				daily:    make(map[string]int),
+			}
+			s.stats[c.Short] = st
+		}
+
+		st.total++
+		st.visitors[c.IP+"\x00"+c.UserAgent] = struct{}{}
+		st.daily[c.Time.UTC().Format(DateLayout)]++
+	}
+
+	return nil
+}
+
+// Статистика по ссылке.
+func (s *MemoryStore) Stats(ctx context.Context, short string, since time.Time) (Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := Stats{Daily: []DailyClicks{}}
+	st, exists := s.stats[short]
+	if !exists {
+		return result, nil
+	}
+
+	result.Total = st.total
+	result.Unique = len(st.visitors)
+
+	from := since.UTC().Format(DateLayout)
+	for date, clicks := range st.daily {
+		if date >= from {
+			result.Daily = append(result.Daily, DailyClicks{Date: date, Clicks: clicks})
+		}
+	}
+	sort.Slice(result.Daily, func(i, j int) bool {
+		return result.Daily[i].Date < result.Daily[j].Date
+	})
+
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore - хранилка событий в таблице clicks базы postgres. Таблица создается миграциями
+// драйвера postgres.
+type PostgresStore struct {
+	db *pgxpool.Pool
+}
+
+// Конструктор хранилки событий в базе.
+func NewPostgresStore(db *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{db}
+}
+
+// Сохранение пачки событий через COPY.
+func (s *PostgresStore) Save(ctx context.Context, clicks []Click) error {
+	_, err := s.db.CopyFrom(
+		ctx,
+		pgx.Identifier{"clicks"},
+		[]string{"short_key", "clicked_at", "referrer", "user_agent", "ip"},
+		pgx.CopyFromSlice(len(clicks), func(i int) ([]any, error) {
+			c := clicks[i]
+			return []any{c.Short, c.Time, c.Referrer, c.UserAgent, c.IP}, nil
+		}),
+	)
+
+	return err
+}
+
+// Статистика по ссылке.
+func (s *PostgresStore) Stats(ctx context.Context, short string, since time.Time) (Stats, error) {
+	result := Stats{Daily: []DailyClicks{}}
+
+	row := s.db.QueryRow(ctx, `SELECT COUNT(*), COUNT(DISTINCT (ip, user_agent)) FROM clicks WHERE short_key = $1`, short)
+	if err := row.Scan(&result.Total, &result.Unique); err != nil {
+		return result, err
+	}
+
+	rows, err := s.db.Query(ctx, `SELECT (clicked_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) FROM clicks WHERE short_key = $1 AND clicked_at >= $2 GROUP BY day ORDER BY day`, short, since)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day time.Time
+		var clicks int
+		if err = rows.Scan(&day, &clicks); err != nil {
+			return result, err
+		}
+		result.Daily = append(result.Daily, DailyClicks{Date: day.Format(DateLayout), Clicks: clicks})
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,141 @@
+package analytics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Максимальное количество событий в одной пачке сохранения.
+	batchSize = 100
+
+	// Период сохранения неполной пачки событий.
+	flushInterval = time.Second
+
+	// Таймаут сохранения пачки событий.
+	saveTimeout = 5 * time.Second
+)
+
+// Recorder асинхронно записывает события переходов: Record кладет событие в буферизированный канал
+// и не блокируется, фоновый Run сохраняет события пачками. Если буфер заполнен, событие отбрасывается.
+type Recorder struct {
+	store       Store
+	events      chan Click
+	anonymizeIP bool
+	dropped     atomic.Int64
+	done        chan struct{}
+	logger      *zap.SugaredLogger
+}
+
+// Конструктор рекордера. bufferSize - размер буфера событий, anonymizeIP - анонимизировать IP клиентов.
+func NewRecorder(store Store, bufferSize int, anonymizeIP bool, logger *zap.SugaredLogger) *Recorder {
+	return &Recorder{
+		store:       store,
+		events:      make(chan Click, bufferSize),
+		anonymizeIP: anonymizeIP,
+		done:        make(chan struct{}),
+		logger:      logger,
+	}
+}
+
+// Запись события перехода. Вызов не блокируется, при заполненном буфере событие отбрасывается.
+// Для nil рекордера ничего не делает.
+func (r *Recorder) Record(c Click) {
+	if r == nil {
+		return
+	}
+
+	if r.anonymizeIP {
+		c.IP = AnonymizeIP(c.IP)
+	}
+
+	select {
+	case r.events <- c:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Количество отброшенных из-за заполненного буфера событий.
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Фоновое сохранение событий пачками, работает до отмены контекста. После отмены оставшиеся
+// в буфере события сохраняются и канал Done закрывается.
+func (r *Recorder) Run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Click, 0, batchSize)
+	var reported int64
+	for {
+		select {
+		case c := <-r.events:
+			batch = append(batch, c)
+			if len(batch) >= batchSize {
+				batch = r.flush(batch)
+			}
+		case <-ticker.C:
+			batch = r.flush(batch)
+			if dropped := r.Dropped(); dropped > reported {
+				r.logger.Warnw(`Clicks dropped because of full buffer`, `count`, dropped-reported)
+				reported = dropped
+			}
+		case <-ctx.Done():
+			for {
+				select {
+				case c := <-r.events:
+					batch = append(batch, c)
+					if len(batch) >= batchSize {
+						batch = r.flush(batch)
+					}
+				default:
+					r.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Канал, закрывающийся после завершения Run.
+func (r *Recorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// Статистика по ссылке за все время с дневной статистикой за последние days дней, включая текущий.
+func (r *Recorder) Stats(ctx context.Context, short string, days int) (Stats, error) {
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1-days)
+
+	stats, err := r.store.Stats(ctx, short, since)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Daily = fillDays(stats.Daily, since, now)
+
+	return stats, nil
+}
+
+// flush сохраняет пачку событий и возвращает пустую пачку для переиспользования.
+func (r *Recorder) flush(batch []Click) []Click {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+
+	if err := r.store.Save(ctx, batch); err != nil {
+		r.logger.Errorw(`Error occured while saving clicks`, err, `count`, len(batch))
+	}
+
+	return batch[:0]
+}
@@ -1,12 +1,23 @@
 // Модуль api служит для описания структур запросов/ответов
 package api
 
+import "time"
+
 // URL - полный адрес URL в виде строки
 type URL string
 
-// Request - запрос с полем URL, которое требуется сократить в JSON формате
+// Request - запрос с полем URL, которое требуется сократить в JSON формате,
+// необязательным алиасом - желаемым коротким ключом, и необязательным сроком жизни ссылки:
+// ttl в секундах либо абсолютным временем истечения expires_at, необязательным лимитом переходов max_clicks
+// необязательным паролем ссылки и необязательным HTTP статусом редиректа redirect_type
 type Request struct {
-	URL URL `json:"url"`
+	URL          URL        `json:"url"`
+	Alias        string     `json:"alias,omitempty"`
+	TTL          int64      `json:"ttl,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxClicks    int        `json:"max_clicks,omitempty"`
+	Password     string     `json:"password,omitempty"`
+	RedirectType int        `json:"redirect_type,omitempty"`
 }
 
 // Resonse - ответ в JSON формате с коротким URL
@@ -16,8 +27,14 @@ type Response struct {
 
 // BatchRequest - запрос с пакетным сокращением URL
 type BatchRequest []struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	TTL           int64      `json:"ttl,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxClicks     int        `json:"max_clicks,omitempty"`
+	Password      string     `json:"password,omitempty"`
+	RedirectType  int        `json:"redirect_type,omitempty"`
 }
 
 // BatchResponse - ответ с пакетным сокращением URL
@@ -26,11 +43,86 @@ type BatchResponse []struct {
 	ShortURL      string `json:"short_url"`
 }
 
-// UserResponse - ответ с сокращенными и изначальными URL пользователя
+// UserResponse - ответ с сокращенными и изначальными URL пользователя и HTTP статусом их редиректа
 type UserResponse []struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL     string `json:"short_url"`
+	OriginalURL  string `json:"original_url"`
+	RedirectType int    `json:"redirect_type"`
 }
 
 // BatchDeleteRequest - запрос на пакетное удаление скоращенных URL
 type BatchDeleteRequest []string
+
+// BatchDeleteResponse - ответ на пакетное удаление с ID задания на удаление
+type BatchDeleteResponse struct {
+	ID string `json:"id"`
+}
+
+// DeletionResponse - статус задания на удаление: pending, running, done или failed,
+// и результат удаления по каждому ключу: deleted, not_found, not_owned или already_deleted
+type DeletionResponse struct {
+	ID      string            `json:"id"`
+	Status  string            `json:"status"`
+	Results map[string]string `json:"results,omitempty"`
+}
+
+// APIKeyRequest - запрос на создание API ключа с названием и необязательными правами: create, read, delete.
+// Без прав ключу разрешено все
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// APIKeyResponse - API ключ пользователя. Сам ключ key отдается только при создании
+type APIKeyResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Key       string    `json:"key,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuthRequest - запрос на регистрацию или вход с логином и паролем
+type AuthRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// AuthResponse - ответ на регистрацию или вход с ID пользователя аккаунта, логином и количеством
+// перенесенных в аккаунт ссылок анонимного пользователя. Токен возвращается в куке и заголовке Authorization
+type AuthResponse struct {
+	UserID string `json:"user_id"`
+	Login  string `json:"login"`
+	Merged int    `json:"merged"`
+}
+
+// TokensResponse - новая пара токенов пользователя: короткоживущий токен доступа и refresh токен.
+// Токены также возвращаются в куках и заголовках Authorization и X-Refresh-Token
+type TokensResponse struct {
+	UserID           string    `json:"user_id"`
+	AccessToken      string    `json:"access_token"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// RevokeTokenRequest - запрос на отзыв токена доступа или refresh токена
+type RevokeTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// StatsResponse - статистика переходов по ссылке: всего переходов, уникальных посетителей и переходы по дням
+type StatsResponse struct {
+	Total  int `json:"total"`
+	Unique int `json:"unique"`
+	Daily  []struct {
+		Date   string `json:"date"`
+		Clicks int    `json:"clicks"`
+	} `json:"daily"`
+}
+
+// InternalStatsResponse - внутренняя статистика сервиса: количество сокращенных URL и пользователей
+type InternalStatsResponse struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
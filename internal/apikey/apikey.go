@@ -0,0 +1,72 @@
+// Модуль выпуска и проверки долгоживущих API ключей.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mikesvis/short/internal/domain"
+)
+
+// Имя заголовка запроса с API ключом.
+const HeaderName = "X-API-Key"
+
+// Префикс ключа, по нему ключ легко узнать в логах и конфигах CI.
+const Prefix = "sk_"
+
+// Максимальная длина названия ключа.
+const MaxNameLength = 100
+
+// Длина случайной части ключа в байтах.
+const secretLength = 32
+
+// Выпуск ключа name пользователя userID с правами scopes. Возвращается запись ключа для storage
+// и сам ключ, который показывается пользователю один раз и нигде не хранится.
+func New(userID string, name string, scopes []domain.APIKeyScope, now time.Time) (domain.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 || len(name) > MaxNameLength {
+		return domain.APIKey{}, "", fmt.Errorf("key name must be from 1 to %d characters long", MaxNameLength)
+	}
+
+	unique := make(map[domain.APIKeyScope]struct{}, len(scopes))
+	keyScopes := make([]domain.APIKeyScope, 0, len(scopes))
+	for _, scope := range scopes {
+		if !scope.Valid() {
+			return domain.APIKey{}, "", fmt.Errorf("unknown key scope %q", scope)
+		}
+
+		if _, exists := unique[scope]; exists {
+			continue
+		}
+		unique[scope] = struct{}{}
+		keyScopes = append(keyScopes, scope)
+	}
+
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return domain.APIKey{}, "", err
+	}
+
+	plain := Prefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	return domain.APIKey{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Name:      name,
+		Hash:      Hash(plain),
+		Scopes:    keyScopes,
+		CreatedAt: now.UTC(),
+	}, plain, nil
+}
+
+// Хеш ключа для хранения и поиска в storage. Ключ случайный и длинный, поэтому соль не нужна.
+func Hash(plain string) string {
+	hash := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(hash[:])
+}
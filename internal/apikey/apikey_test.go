@@ -0,0 +1,93 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		keyName    string
+		scopes     []domain.APIKeyScope
+		wantScopes []domain.APIKeyScope
+		wantErr    bool
+	}{
+		{
+			name:       "Key without scopes",
+			keyName:    "ci",
+			wantScopes: []domain.APIKeyScope{},
+		},
+		{
+			name:       "Duplicated scopes are merged",
+			keyName:    " release notes ",
+			scopes:     []domain.APIKeyScope{domain.APIKeyScopeCreate, domain.APIKeyScopeRead, domain.APIKeyScopeCreate},
+			wantScopes: []domain.APIKeyScope{domain.APIKeyScopeCreate, domain.APIKeyScopeRead},
+		},
+		{
+			name:    "Unknown scope",
+			keyName: "ci",
+			scopes:  []domain.APIKeyScope{"admin"},
+			wantErr: true,
+		},
+		{
+			name:    "Empty name",
+			keyName: "  ",
+			wantErr: true,
+		},
+		{
+			name:    "Too long name",
+			keyName: strings.Repeat("a", MaxNameLength+1),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, plain, err := New("user", tt.keyName, tt.scopes, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(plain, Prefix))
+			assert.Equal(t, Hash(plain), key.Hash)
+			assert.NotContains(t, key.Hash, plain)
+			assert.NotEmpty(t, key.ID)
+			assert.Equal(t, "user", key.UserID)
+			assert.Equal(t, strings.TrimSpace(tt.keyName), key.Name)
+			assert.Equal(t, tt.wantScopes, key.Scopes)
+			assert.Equal(t, now, key.CreatedAt)
+		})
+	}
+
+	_, first, err := New("user", "ci", nil, now)
+	require.NoError(t, err)
+	_, second, err := New("user", "ci", nil, now)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+func TestAPIKey_Allows(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []domain.APIKeyScope
+		scope  domain.APIKeyScope
+		want   bool
+	}{
+		{name: "Key without scopes allows everything", scope: domain.APIKeyScopeDelete, want: true},
+		{name: "Scope is granted", scopes: []domain.APIKeyScope{domain.APIKeyScopeCreate, domain.APIKeyScopeRead}, scope: domain.APIKeyScopeRead, want: true},
+		{name: "Scope is not granted", scopes: []domain.APIKeyScope{domain.APIKeyScopeCreate}, scope: domain.APIKeyScopeDelete, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, domain.APIKey{Scopes: tt.scopes}.Allows(tt.scope))
+		})
+	}
+}
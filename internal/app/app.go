@@ -3,46 +3,91 @@ package app
 
 import (
 	"context"
+	_errors "errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mikesvis/short/internal/analytics"
 	"github.com/mikesvis/short/internal/config"
+	"github.com/mikesvis/short/internal/deleter"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/mikesvis/short/internal/interceptor"
+	"github.com/mikesvis/short/internal/jwt"
+	"github.com/mikesvis/short/internal/keygen"
 	"github.com/mikesvis/short/internal/logger"
+	"github.com/mikesvis/short/internal/metrics"
 	"github.com/mikesvis/short/internal/middleware"
 	"github.com/mikesvis/short/internal/server"
 	"github.com/mikesvis/short/internal/storage"
+	"github.com/mikesvis/short/internal/sweeper"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-// App - стуктура приложения с конфигом, логгером, storage и роутером.
+// App - стуктура приложения с конфигом, логгером, storage, рекордером переходов, очередью удаления
+// (nil - storage не поддерживает удаление), роутером, gRPC сервером и отдельным сервером метрик
+// (nil - метрики отдаются сервером приложения).
 type App struct {
-	config  *config.Config
-	logger  *zap.SugaredLogger
-	storage storage.Storage
-	router  *chi.Mux
-	server  *http.Server
+	config        *config.Config
+	logger        *zap.SugaredLogger
+	storage       storage.Storage
+	recorder      *analytics.Recorder
+	deletions     *deleter.Deleter
+	router        *chi.Mux
+	server        *http.Server
+	grpcServer    *grpc.Server
+	metricsServer *http.Server
 }
 
 // Конструктор приложения, здесь инициализируются все зависимости:
-// конфиг приложения, логгер, storage, роутер. Также здесь регистрируются middleware приложения.
+// конфиг приложения, логгер, storage, рекордер переходов, очередь удаления, роутер, gRPC сервер. Также здесь регистрируются
+// middleware и интерсепторы приложения.
 func New(config *config.Config) *App {
 	logger, err := logger.NewLogger()
 	if err != nil {
 		panic(err)
 	}
 
-	storage, err := storage.NewStorage(config, logger)
+	storageDriver, err := storage.NewStorage(config, logger)
 	if err != nil {
 		panic(err)
 	}
 
-	handler := server.NewHandler(config, storage)
+	if err = setSigningKeys(config); err != nil {
+		panic(err)
+	}
+
+	generator, err := keygen.NewGenerator(config.KeyStrategy, config.KeySalt)
+	if err != nil {
+		panic(err)
+	}
+
+	setKeySequence(generator, storageDriver)
+
+	recorder := analytics.NewRecorder(
+		storage.NewAnalyticsStore(storageDriver),
+		config.AnalyticsBufferSize,
+		config.AnalyticsAnonymizeIP,
+		logger,
+	)
+
+	var deletions *deleter.Deleter
+	if deleterStorage, isDeleter := storageDriver.(storage.StorageDeleter); isDeleter {
+		deletions = deleter.New(deleterStorage, config.DeleteQueueSize, logger)
+	}
+
+	handler := server.NewHandler(config, storageDriver, generator, recorder, deletions)
 	router := server.NewRouter(
 		handler,
+		middleware.Metrics,
 		middleware.RequestResponseLogger(logger),
 		middleware.GZip(
 			[]string{
@@ -53,23 +98,90 @@ func New(config *config.Config) *App {
 		),
 	)
 
+	grpcOptions := []grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptor.Logger(logger))}
+	if config.EnableHTTPS {
+		creds, err := credentials.NewServerTLSFromFile(config.ServerCertPath, config.ServerKeyPath)
+		if err != nil {
+			panic(err)
+		}
+		grpcOptions = append(grpcOptions, grpc.Creds(creds))
+	}
+	grpcServer := server.NewGRPCServer(handler, grpcOptions...)
+
 	server := &http.Server{
 		Addr:    config.ServerAddress,
 		Handler: router,
 	}
 
+	var metricsServer *http.Server
+	if config.MetricsAddress == "" {
+		router.Handle("/metrics", metrics.Handler())
+	} else {
+		metricsRouter := chi.NewMux()
+		metricsRouter.Handle("/metrics", metrics.Handler())
+		metricsServer = &http.Server{
+			Addr:    config.MetricsAddress,
+			Handler: metricsRouter,
+		}
+	}
+
 	return &App{
 		config,
 		logger,
-		storage,
+		storageDriver,
+		recorder,
+		deletions,
 		router,
 		server,
+		grpcServer,
+		metricsServer,
+	}
+}
+
+// Последовательные ключи резервируются блоками в постоянном счетчике storage, чтобы не повторяться
+// после перезапуска, даже если выданные ссылки удалены.
+func setKeySequence(generator keygen.KeyGenerator, s storage.Storage) {
+	sequential, isSequential := generator.(*keygen.SequentialGenerator)
+	sequencer, isSequencer := s.(storage.StorageSequencer)
+	if !isSequential || !isSequencer {
+		return
+	}
+
+	sequential.SetReserver(func(size uint64) (uint64, error) {
+		return sequencer.ReserveSequence(context.Background(), size)
+	})
+}
+
+// Установка ключей подписи токенов из файла ключей или секрета. Если файла ключей еще нет, он создается
+// с первым ключом, чтобы токены переживали перезапуск. Без ключей (конфиг без файла ключей по-умолчанию)
+// сервис не запускается: токены, подписанные ключом только из памяти, после перезапуска стали бы невалидны
+// и анонимные пользователи потеряли бы ссылки.
+func setSigningKeys(c *config.Config) error {
+	if len(c.JWTKeysFile) > 0 {
+		keySet, err := jwt.LoadKeySet(c.JWTKeysFile)
+		if _errors.Is(err, fs.ErrNotExist) {
+			if _, err = keySet.Rotate(time.Now()); err == nil {
+				err = keySet.Save(c.JWTKeysFile)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return jwt.SetKeys(keySet)
+	}
+
+	if len(c.JWTSecret) > 0 {
+		return jwt.SetKeys(jwt.KeySetFromSecret(c.JWTSecret))
 	}
+
+	return fmt.Errorf("%w: set jwt_keys_file or jwt_secret", errors.ErrNoSigningKey)
 }
 
 // Запуск приложения.
 func (a *App) Run() error {
-	a.logger.Infow("Config initialized", "config", a.config)
+	a.logger.Infow("Config initialized", "config", a.config.Redacted())
 	if _, isCloser := a.storage.(storage.StorageCloser); isCloser {
 		defer a.storage.(storage.StorageCloser).Close()
 	}
@@ -77,6 +189,32 @@ func (a *App) Run() error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer stop()
 
+	// ключи перечитываются после ротации, выданные токены остаются валидными
+	if len(a.config.JWTKeysFile) > 0 {
+		go jwt.WatchKeysFile(ctx, a.config.JWTKeysFile, jwt.KeysReloadInterval, a.logger)
+	}
+
+	if sweeperStorage, isSweeper := a.storage.(storage.StorageSweeper); isSweeper {
+		go sweeper.New(
+			sweeperStorage,
+			time.Duration(a.config.ExpiredSweepInterval),
+			time.Duration(a.config.ExpiredRetention),
+			a.logger,
+		).Run(ctx)
+	}
+
+	// рекордер останавливается после сервера, чтобы сохранить переходы из последних запросов
+	recorderCtx, stopRecorder := context.WithCancel(context.Background())
+	defer stopRecorder()
+	go a.recorder.Run(recorderCtx)
+
+	// очередь удаления тоже останавливается после сервера и дорабатывает принятые запросы
+	deletionsCtx, stopDeletions := context.WithCancel(context.Background())
+	defer stopDeletions()
+	if a.deletions != nil {
+		go a.deletions.Run(deletionsCtx)
+	}
+
 	go func() {
 		if a.config.EnableHTTPS {
 			if err := a.server.ListenAndServeTLS(a.config.ServerCertPath, a.config.ServerKeyPath); err != http.ErrServerClosed {
@@ -89,6 +227,25 @@ func (a *App) Run() error {
 		}
 	}()
 
+	go func() {
+		listen, err := net.Listen("tcp", a.config.GRPCAddress)
+		if err != nil {
+			a.logger.Fatalf("Failed to listen gRPC address: %v", err)
+		}
+
+		if err := a.grpcServer.Serve(listen); err != nil {
+			a.logger.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}()
+
+	if a.metricsServer != nil {
+		go func() {
+			if err := a.metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				a.logger.Fatalf("Failed to start metrics server: %v", err)
+			}
+		}()
+	}
+
 	<-ctx.Done()
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -96,6 +253,24 @@ func (a *App) Run() error {
 		log.Fatalf("Server Shutdown Failed:%+v", err)
 		return err
 	}
+
+	a.grpcServer.GracefulStop()
+
+	if a.metricsServer != nil {
+		if err := a.metricsServer.Shutdown(shutdownCtx); err != nil {
+			log.Fatalf("Metrics Server Shutdown Failed:%+v", err)
+			return err
+		}
+	}
+
+	stopRecorder()
+	<-a.recorder.Done()
+
+	if a.deletions != nil {
+		stopDeletions()
+		<-a.deletions.Done()
+	}
+
 	log.Println("Server exited properly")
 	return nil
 }
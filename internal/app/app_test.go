@@ -2,10 +2,20 @@
 package app
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/mikesvis/short/internal/config"
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/drivers/filedb"
+	"github.com/mikesvis/short/internal/keygen"
+	"github.com/mikesvis/short/internal/logger"
+	"github.com/mikesvis/short/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNew(t *testing.T) {
@@ -14,6 +24,7 @@ func TestNew(t *testing.T) {
 		BaseURL:         "http://short.go",
 		FileStoragePath: "",
 		DatabaseDSN:     "",
+		JWTSecret:       "iddqd",
 	}
 	app := New(config)
 	tests := []struct {
@@ -31,4 +42,64 @@ func TestNew(t *testing.T) {
 			assert.ObjectsAreEqual(tt.want, result)
 		})
 	}
+
+	// без ключа подписи токенов сервис не стартует
+	noKeys := *config
+	noKeys.JWTSecret = ""
+	assert.Panics(t, func() { New(&noKeys) })
+}
+
+func Test_setKeySequence(t *testing.T) {
+	ctx := context.Background()
+	l, _ := logger.NewLogger()
+	fileName := filepath.Join(t.TempDir(), "short.json")
+
+	// shorten сохраняет count ссылок через аллокатор и возвращает количество попыток сохранения
+	shorten := func(s storage.Storage, a *keygen.Allocator, prefix string, count int, expiresAt time.Time) (keys []string, attempts int) {
+		for i := 0; i < count; i++ {
+			require.NoError(t, a.Allocate(func(next func(full string) string) error {
+				attempts++
+				full := fmt.Sprintf("http://www.yandex.ru/%s/%d", prefix, i)
+				u, err := s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: full, Short: next(full), ExpiresAt: expiresAt})
+				if err == nil {
+					keys = append(keys, u.Short)
+				}
+				return err
+			}))
+		}
+
+		return keys, attempts
+	}
+
+	s, err := filedb.NewFileDB(fileName, filedb.SyncAlways, 0, l)
+	require.NoError(t, err)
+	generator := keygen.NewSequentialGenerator("salt")
+	setKeySequence(generator, s)
+	allocator := keygen.NewAllocator(generator, keygen.KeyLength)
+
+	// ссылки удаляются пользователем и вычищаются после истечения
+	deleted, _ := shorten(s, allocator, "deleted", 5, time.Time{})
+	past := time.Now().Add(-time.Hour)
+	shorten(s, allocator, "expired", 5, past)
+	_, err = s.DeleteBatch(ctx, "DoomGuy", deleted)
+	require.NoError(t, err)
+	swept, err := s.DeleteExpired(ctx, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 5, swept)
+	urls, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	require.Zero(t, urls)
+	require.NoError(t, s.Close())
+
+	// после перезапуска счетчик не возвращается к выданным номерам: ни одной коллизии
+	s, err = filedb.NewFileDB(fileName, filedb.SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+	generator = keygen.NewSequentialGenerator("salt")
+	setKeySequence(generator, s)
+	allocator = keygen.NewAllocator(generator, keygen.KeyLength)
+
+	_, attempts := shorten(s, allocator, "new", 20, time.Time{})
+	assert.Equal(t, 20, attempts)
+	assert.Equal(t, uint(keygen.KeyLength), allocator.Length())
 }
@@ -4,9 +4,14 @@ package config
 import (
 	"encoding/json"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"reflect"
+	"time"
 
 	"github.com/caarlos0/env"
+	"github.com/mikesvis/short/internal/domain"
 	flag "github.com/spf13/pflag"
 )
 
@@ -15,15 +20,77 @@ type Config struct {
 	// ServerAddress - адрес сервера приложения. По-умолчанию localhost:8080.
 	ServerAddress string `env:"SERVER_ADDRESS" json:"server_address"`
 
+	// GRPCAddress - адрес gRPC сервера приложения. По-умолчанию localhost:3200.
+	GRPCAddress string `env:"GRPC_ADDRESS" json:"grpc_address"`
+
 	// BaseURL - адрес сервера для коротких URL. По-умолчанию http://localhost:8080.
 	BaseURL string `env:"BASE_URL" json:"base_url"`
 
 	// FileStoragePath - путь для файла storage, нужен при выборе движка хранения коротких ссылок в файле.
 	FileStoragePath string `env:"FILE_STORAGE_PATH" envDefault:"/tmp/short-url-db.json" json:"file_storage_path"`
 
+	// FileSyncPolicy - политика fsync для файла storage: always (после каждой записи), interval (раз в FileSyncInterval)
+	// или never (на усмотрение ОС). По-умолчанию always.
+	FileSyncPolicy string `env:"FILE_SYNC_POLICY" json:"file_sync_policy"`
+
+	// FileSyncInterval - период fsync для политики interval. По-умолчанию 1s.
+	FileSyncInterval Duration `env:"FILE_SYNC_INTERVAL" json:"file_sync_interval"`
+
 	// DatabaseDSN - адрес подключения к базе postgres, нужен при выборе движка хранения коротких ссылок в базе.
 	DatabaseDSN string `env:"DATABASE_DSN" json:"database_dsn"`
 
+	// DatabaseMaxConns - максимальный размер пула соединений к базе. По-умолчанию значение драйвера pgxpool.
+	DatabaseMaxConns int `env:"DATABASE_MAX_CONNS" json:"database_max_conns"`
+
+	// DatabaseMinConns - минимальное количество открытых соединений в пуле. По-умолчанию 0.
+	DatabaseMinConns int `env:"DATABASE_MIN_CONNS" json:"database_min_conns"`
+
+	// DatabaseMaxConnLifetime - время жизни соединения в пуле. По-умолчанию значение драйвера pgxpool (1h).
+	DatabaseMaxConnLifetime Duration `env:"DATABASE_MAX_CONN_LIFETIME" json:"database_max_conn_lifetime"`
+
+	// DatabaseMaxConnIdleTime - время простоя, после которого соединение закрывается. По-умолчанию значение драйвера pgxpool (30m).
+	DatabaseMaxConnIdleTime Duration `env:"DATABASE_MAX_CONN_IDLE_TIME" json:"database_max_conn_idle_time"`
+
+	// KeyStrategy - стратегия генерации коротких ключей: random, sequential, hash или words. По-умолчанию random.
+	KeyStrategy string `env:"KEY_STRATEGY" json:"key_strategy"`
+
+	// KeySalt - соль для перемешивания алфавита в стратегии sequential.
+	KeySalt string `env:"KEY_SALT" json:"key_salt"`
+
+	// RedirectType - HTTP статус редиректа по-умолчанию: 301, 302, 307 или 308. По-умолчанию 307.
+	RedirectType int `env:"REDIRECT_TYPE" json:"redirect_type"`
+
+	// ExpiredSweepInterval - период запуска удаления истекших ссылок. По-умолчанию 1h.
+	ExpiredSweepInterval Duration `env:"EXPIRED_SWEEP_INTERVAL" json:"expired_sweep_interval"`
+
+	// ExpiredRetention - сколько хранить истекшие ссылки перед удалением. По-умолчанию 168h.
+	ExpiredRetention Duration `env:"EXPIRED_RETENTION" json:"expired_retention"`
+
+	// AnalyticsBufferSize - размер буфера событий переходов, при заполнении события отбрасываются. По-умолчанию 10000.
+	AnalyticsBufferSize int `env:"ANALYTICS_BUFFER_SIZE" json:"analytics_buffer_size"`
+
+	// AnalyticsAnonymizeIP - анонимизировать IP клиентов в событиях переходов.
+	AnalyticsAnonymizeIP bool `env:"ANALYTICS_ANONYMIZE_IP" json:"analytics_anonymize_ip"`
+
+	// DeleteQueueSize - размер очереди запросов на удаление ссылок, при заполнении запросы ждут места в очереди.
+	// По-умолчанию 1000.
+	DeleteQueueSize int `env:"DELETE_QUEUE_SIZE" json:"delete_queue_size"`
+
+	// MetricsAddress - отдельный адрес для эндпоинта /metrics. Если не задан, /metrics доступен на адресе приложения.
+	MetricsAddress string `env:"METRICS_ADDRESS" json:"metrics_address"`
+
+	// TrustedSubnet - доверенная подсеть в CIDR нотации для доступа к внутренней статистике.
+	// Если не задана, доступ к внутренней статистике запрещен.
+	TrustedSubnet string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+
+	// JWTSecret - секрет подписи токенов авторизации. Игнорируется, если задан JWTKeysFile.
+	JWTSecret string `env:"JWT_SECRET" json:"jwt_secret"`
+
+	// JWTKeysFile - файл набора ключей подписи токенов авторизации с поддержкой ротации.
+	// Если не заданы ни файл, ни секрет, используется /tmp/short-jwt-keys.json. Отсутствующий файл
+	// создается при старте с первым ключом.
+	JWTKeysFile string `env:"JWT_KEYS_FILE" json:"jwt_keys_file"`
+
 	// EnableHTTPS - использовать HTTPS на сервере
 	EnableHTTPS bool `env:"ENABLE_HTTPS" json:"enable_https"`
 
@@ -37,13 +104,24 @@ type Config struct {
 	ConfigFilePath string `env:"CONFIG"`
 }
 
+// Копия конфига для вывода в лог: секреты заменены на "***".
+func (c Config) Redacted() Config {
+	if len(c.JWTSecret) > 0 {
+		c.JWTSecret = "***"
+	}
+
+	return c
+}
+
 // Конструктор конфигурации приложения.
 func NewConfig() *Config {
 	var config Config
 	var configFile Config
 
 	parseFlags(&config)
-	env.Parse(&config)
+	env.ParseWithFuncs(&config, env.CustomParsers{
+		reflect.TypeOf(Duration(0)): parseDurationEnv,
+	})
 
 	if len(config.ConfigFilePath) > 0 {
 		parseFile(&configFile, config.ConfigFilePath)
@@ -67,14 +145,150 @@ func NewConfig() *Config {
 		config.BaseURL = "http://localhost:8080"
 	}
 
+	if config.GRPCAddress == "" && len(configFile.GRPCAddress) > 0 {
+		config.GRPCAddress = configFile.GRPCAddress
+	}
+
+	// setting default value if still empty
+	if config.GRPCAddress == "" {
+		config.GRPCAddress = "localhost:3200"
+	}
+
 	if config.FileStoragePath == "" && len(configFile.FileStoragePath) > 0 {
 		config.FileStoragePath = configFile.FileStoragePath
 	}
 
+	if config.FileSyncPolicy == "" && len(configFile.FileSyncPolicy) > 0 {
+		config.FileSyncPolicy = configFile.FileSyncPolicy
+	}
+
+	// setting default value if still empty
+	if config.FileSyncPolicy == "" {
+		config.FileSyncPolicy = "always"
+	}
+
+	if config.FileSyncInterval == 0 && configFile.FileSyncInterval > 0 {
+		config.FileSyncInterval = configFile.FileSyncInterval
+	}
+
+	// setting default value if still empty
+	if config.FileSyncInterval == 0 {
+		config.FileSyncInterval = Duration(time.Second)
+	}
+
 	if config.DatabaseDSN == "" && len(configFile.DatabaseDSN) > 0 {
 		config.DatabaseDSN = configFile.DatabaseDSN
 	}
 
+	if config.DatabaseMaxConns == 0 && configFile.DatabaseMaxConns > 0 {
+		config.DatabaseMaxConns = configFile.DatabaseMaxConns
+	}
+
+	if config.DatabaseMinConns == 0 && configFile.DatabaseMinConns > 0 {
+		config.DatabaseMinConns = configFile.DatabaseMinConns
+	}
+
+	if config.DatabaseMaxConnLifetime == 0 && configFile.DatabaseMaxConnLifetime > 0 {
+		config.DatabaseMaxConnLifetime = configFile.DatabaseMaxConnLifetime
+	}
+
+	if config.DatabaseMaxConnIdleTime == 0 && configFile.DatabaseMaxConnIdleTime > 0 {
+		config.DatabaseMaxConnIdleTime = configFile.DatabaseMaxConnIdleTime
+	}
+
+	if config.KeyStrategy == "" && len(configFile.KeyStrategy) > 0 {
+		config.KeyStrategy = configFile.KeyStrategy
+	}
+
+	// setting default value if still empty
+	if config.KeyStrategy == "" {
+		config.KeyStrategy = "random"
+	}
+
+	if config.KeySalt == "" && len(configFile.KeySalt) > 0 {
+		config.KeySalt = configFile.KeySalt
+	}
+
+	if config.RedirectType == 0 && configFile.RedirectType > 0 {
+		config.RedirectType = configFile.RedirectType
+	}
+
+	// setting default value if still empty
+	if config.RedirectType == 0 {
+		config.RedirectType = http.StatusTemporaryRedirect
+	}
+
+	if !domain.ValidRedirectType(config.RedirectType) {
+		log.Fatalf("Unsupported redirect type %d, use one of 301, 302, 307 or 308", config.RedirectType)
+	}
+
+	if config.ExpiredSweepInterval == 0 && configFile.ExpiredSweepInterval > 0 {
+		config.ExpiredSweepInterval = configFile.ExpiredSweepInterval
+	}
+
+	// setting default value if still empty
+	if config.ExpiredSweepInterval == 0 {
+		config.ExpiredSweepInterval = Duration(time.Hour)
+	}
+
+	if config.ExpiredRetention == 0 && configFile.ExpiredRetention > 0 {
+		config.ExpiredRetention = configFile.ExpiredRetention
+	}
+
+	// setting default value if still empty
+	if config.ExpiredRetention == 0 {
+		config.ExpiredRetention = Duration(7 * 24 * time.Hour)
+	}
+
+	if config.AnalyticsBufferSize == 0 && configFile.AnalyticsBufferSize > 0 {
+		config.AnalyticsBufferSize = configFile.AnalyticsBufferSize
+	}
+
+	// setting default value if still empty
+	if config.AnalyticsBufferSize == 0 {
+		config.AnalyticsBufferSize = 10000
+	}
+
+	if !config.AnalyticsAnonymizeIP && configFile.AnalyticsAnonymizeIP {
+		config.AnalyticsAnonymizeIP = true
+	}
+
+	if config.DeleteQueueSize == 0 && configFile.DeleteQueueSize > 0 {
+		config.DeleteQueueSize = configFile.DeleteQueueSize
+	}
+
+	// setting default value if still empty
+	if config.DeleteQueueSize == 0 {
+		config.DeleteQueueSize = 1000
+	}
+
+	if config.MetricsAddress == "" && len(configFile.MetricsAddress) > 0 {
+		config.MetricsAddress = configFile.MetricsAddress
+	}
+
+	if config.TrustedSubnet == "" && len(configFile.TrustedSubnet) > 0 {
+		config.TrustedSubnet = configFile.TrustedSubnet
+	}
+
+	if len(config.TrustedSubnet) > 0 {
+		if _, _, err := net.ParseCIDR(config.TrustedSubnet); err != nil {
+			log.Fatalf("Invalid trusted subnet %s: %v", config.TrustedSubnet, err)
+		}
+	}
+
+	if config.JWTSecret == "" && len(configFile.JWTSecret) > 0 {
+		config.JWTSecret = configFile.JWTSecret
+	}
+
+	if config.JWTKeysFile == "" && len(configFile.JWTKeysFile) > 0 {
+		config.JWTKeysFile = configFile.JWTKeysFile
+	}
+
+	// setting default value if still empty
+	if config.JWTKeysFile == "" && config.JWTSecret == "" {
+		config.JWTKeysFile = "/tmp/short-jwt-keys.json"
+	}
+
 	if !config.EnableHTTPS && configFile.EnableHTTPS {
 		config.EnableHTTPS = true
 	}
@@ -100,9 +314,28 @@ func NewConfig() *Config {
 
 func parseFlags(c *Config) {
 	flag.StringVarP(&c.ServerAddress, "address", "a", "", "address of shortener service server (default: localhost:8080)")
+	flag.StringVarP(&c.GRPCAddress, "grpc_address", "g", "", "address of shortener service gRPC server (default: localhost:3200)")
 	flag.StringVarP(&c.BaseURL, "basepath", "b", "", "address of short link basepath (default: http://localhost:8080)")
 	flag.StringVarP(&c.FileStoragePath, "file_storage_path", "f", "", "path to file storage of URLs")
+	flag.StringVar(&c.FileSyncPolicy, "file_sync_policy", "", "fsync policy of file storage: always, interval or never (default: always)")
+	flag.DurationVar((*time.Duration)(&c.FileSyncInterval), "file_sync_interval", 0, "fsync period of file storage for interval policy (default: 1s)")
 	flag.StringVarP(&c.DatabaseDSN, "database_dsn", "d", "", "db connection string")
+	flag.IntVar(&c.DatabaseMaxConns, "database_max_conns", 0, "max size of db connection pool (default: pgxpool default)")
+	flag.IntVar(&c.DatabaseMinConns, "database_min_conns", 0, "min number of open db connections in pool")
+	flag.DurationVar((*time.Duration)(&c.DatabaseMaxConnLifetime), "database_max_conn_lifetime", 0, "max lifetime of db connection (default: 1h)")
+	flag.DurationVar((*time.Duration)(&c.DatabaseMaxConnIdleTime), "database_max_conn_idle_time", 0, "max idle time of db connection (default: 30m)")
+	flag.StringVar(&c.KeyStrategy, "key_strategy", "", "short key generation strategy: random, sequential, hash or words (default: random)")
+	flag.StringVar(&c.KeySalt, "key_salt", "", "salt for sequential short key strategy")
+	flag.IntVar(&c.RedirectType, "redirect_type", 0, "default redirect status: 301, 302, 307 or 308 (default: 307)")
+	flag.DurationVar((*time.Duration)(&c.ExpiredSweepInterval), "expired_sweep_interval", 0, "period of expired links removal (default: 1h)")
+	flag.DurationVar((*time.Duration)(&c.ExpiredRetention), "expired_retention", 0, "how long expired links are kept before removal (default: 168h)")
+	flag.IntVar(&c.AnalyticsBufferSize, "analytics_buffer_size", 0, "size of click events buffer (default: 10000)")
+	flag.BoolVar(&c.AnalyticsAnonymizeIP, "analytics_anonymize_ip", false, "anonymize client IP in click events")
+	flag.IntVar(&c.DeleteQueueSize, "delete_queue_size", 0, "size of url deletion requests queue (default: 1000)")
+	flag.StringVar(&c.MetricsAddress, "metrics_address", "", "separate address of /metrics endpoint (default: served on the application address)")
+	flag.StringVarP(&c.TrustedSubnet, "trusted_subnet", "t", "", "trusted subnet in CIDR notation for internal stats access")
+	flag.StringVar(&c.JWTSecret, "jwt_secret", "", "secret for signing auth tokens")
+	flag.StringVar(&c.JWTKeysFile, "jwt_keys_file", "", "path to auth token signing keys file, supports rotation (default: /tmp/short-jwt-keys.json without jwt_secret)")
 	flag.BoolVarP(&c.EnableHTTPS, "enable_https", "s", false, "use HTTPS connection")
 	flag.StringVarP(&c.ServerKeyPath, "server_key_path", "k", "", "path to server key file")
 	flag.StringVarP(&c.ServerCertPath, "server_cert_path", "e", "", "path to server certificate file")
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"encoding/json"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,13 +18,23 @@ func TestNew(t *testing.T) {
 		{
 			name: "Default config with empty FILE_STORAGE_PATH env variable",
 			want: &Config{
-				ServerAddress:   "localhost:8080",
-				BaseURL:         "http://localhost:8080",
-				FileStoragePath: "",
-				DatabaseDSN:     "",
-				EnableHTTPS:     false,
-				ServerKeyPath:   "",
-				ServerCertPath:  "",
+				ServerAddress:        "localhost:8080",
+				GRPCAddress:          "localhost:3200",
+				BaseURL:              "http://localhost:8080",
+				FileStoragePath:      "",
+				FileSyncPolicy:       "always",
+				FileSyncInterval:     Duration(time.Second),
+				DatabaseDSN:          "",
+				KeyStrategy:          "random",
+				RedirectType:         http.StatusTemporaryRedirect,
+				ExpiredSweepInterval: Duration(time.Hour),
+				ExpiredRetention:     Duration(7 * 24 * time.Hour),
+				AnalyticsBufferSize:  10000,
+				DeleteQueueSize:      1000,
+				JWTKeysFile:          "/tmp/short-jwt-keys.json",
+				EnableHTTPS:          false,
+				ServerKeyPath:        "",
+				ServerCertPath:       "",
 			},
 		},
 	}
@@ -75,3 +88,40 @@ func Test_parseFile(t *testing.T) {
 		}
 	}
 }
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Duration
+		wantErr bool
+	}{
+		{
+			name: "Duration string",
+			json: `{"file_sync_interval":"1m30s"}`,
+			want: Duration(90 * time.Second),
+		},
+		{
+			name:    "Bad duration string",
+			json:    `{"file_sync_interval":"often"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Duration number",
+			json:    `{"file_sync_interval":100}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			err := json.Unmarshal([]byte(tt.json), &c)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, c.FileSyncInterval)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration - промежуток времени в конфиге. В отличие от time.Duration в json файле конфига
+// задается строкой в формате time.ParseDuration, например "1s" или "24h".
+type Duration time.Duration
+
+// Разбор Duration из json строки.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// Представление Duration в виде json строки.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Разбор Duration из переменной окружения.
+func parseDurationEnv(v string) (interface{}, error) {
+	d, err := time.ParseDuration(v)
+	return Duration(d), err
+}
@@ -0,0 +1,413 @@
+// Модуль фонового пакетного удаления ссылок пользователей.
+package deleter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/mikesvis/short/internal/metrics"
+	"github.com/mikesvis/short/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// Количество ожидающих удаления ключей, при котором удаление запускается не дожидаясь периода.
+	batchSize = 1000
+
+	// Период удаления накопленных ключей.
+	flushInterval = time.Second
+
+	// Таймаут удаления пачки ключей одного пользователя.
+	deleteTimeout = 5 * time.Second
+
+	// Количество попыток удаления пачки ключей, после которого пачка отбрасывается.
+	maxAttempts = 5
+
+	// Задержка перед повторной попыткой, удваивается с каждой неудачной попыткой.
+	retryDelay = time.Second
+
+	// Сколько хранить завершенные задания для запроса их статуса.
+	jobRetention = time.Hour
+
+	// Таймаут сохранения и удаления задания в storage.
+	jobStorageTimeout = 5 * time.Second
+)
+
+// Статус задания на удаление.
+type JobStatus string
+
+const (
+	// Задание ждет удаления в очереди.
+	JobStatusPending JobStatus = "pending"
+
+	// Ключи задания удаляются.
+	JobStatusRunning JobStatus = "running"
+
+	// Удаление завершено, результат известен по каждому ключу.
+	JobStatusDone JobStatus = "done"
+
+	// Удаление не удалось после всех попыток либо не завершилось до остановки.
+	JobStatusFailed JobStatus = "failed"
+)
+
+// Задание на удаление ключей одного запроса пользователя.
+type Job struct {
+	// ID задания.
+	ID string
+
+	// ID пользователя.
+	UserID string
+
+	// Статус задания.
+	Status JobStatus
+
+	// Результат удаления по каждому ключу, заполняется при статусе JobStatusDone.
+	Results map[string]domain.DeleteStatus
+
+	shorts     []string
+	finishedAt time.Time
+}
+
+// Ожидающие удаления ключи пользователя с их заданиями, количеством неудачных попыток и временем следующей попытки.
+type pending struct {
+	shorts   map[string]struct{}
+	jobs     []*Job
+	attempts int
+	retryAt  time.Time
+}
+
+// Deleter удаляет ссылки в фоне: Enqueue кладет задание в буферизированную очередь, фоновый Run
+// объединяет ключи из разных заданий по пользователям и периодически удаляет их пачками.
+// Неудачное удаление повторяется с нарастающей задержкой до maxAttempts раз. Статус задания
+// доступен через Job в течение jobRetention после завершения.
+// Если storage хранит задания (StorageDeleteJobber), принятое задание сохраняется до выполнения,
+// и задания, не выполненные к остановке или падению сервиса, продолжаются при следующем запуске Run.
+// Без него очередь живет только в памяти.
+type Deleter struct {
+	storage  storage.StorageDeleter
+	jobber   storage.StorageDeleteJobber
+	requests chan *Job
+	pending  map[string]*pending
+	mu       sync.RWMutex
+	jobs     map[string]*Job
+	stopMu   sync.RWMutex
+	stopped  bool
+	stopping chan struct{}
+	now      func() time.Time
+	done     chan struct{}
+	logger   *zap.SugaredLogger
+}
+
+// Конструктор deleter'а. queueSize - размер очереди заданий на удаление.
+func New(s storage.StorageDeleter, queueSize int, logger *zap.SugaredLogger) *Deleter {
+	jobber, _ := s.(storage.StorageDeleteJobber)
+
+	return &Deleter{
+		storage:  s,
+		jobber:   jobber,
+		requests: make(chan *Job, queueSize),
+		pending:  make(map[string]*pending),
+		jobs:     make(map[string]*Job),
+		stopping: make(chan struct{}),
+		now:      time.Now,
+		done:     make(chan struct{}),
+		logger:   logger,
+	}
+}
+
+// Постановка ключей пользователя userID в очередь на удаление, возвращает ID задания. При заполненной
+// очереди вызов блокируется до освобождения места либо отмены контекста, в этом случае возвращается ErrDeleteQueueFull.
+// После остановки Run задания не принимаются, возвращается ErrDeleteQueueStopped.
+func (d *Deleter) Enqueue(ctx context.Context, userID string, shorts []string) (string, error) {
+	d.stopMu.RLock()
+	defer d.stopMu.RUnlock()
+
+	if d.stopped {
+		return "", errors.ErrDeleteQueueStopped
+	}
+
+	job := &Job{
+		ID:     uuid.NewString(),
+		UserID: userID,
+		Status: JobStatusPending,
+		shorts: shorts,
+	}
+
+	if d.jobber != nil {
+		err := d.jobber.StoreDeleteJob(ctx, domain.DeleteJob{ID: job.ID, UserID: userID, Shorts: shorts, CreatedAt: d.now()})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	d.mu.Lock()
+	d.jobs[job.ID] = job
+	d.mu.Unlock()
+
+	select {
+	case d.requests <- job:
+		metrics.DeleteBatchQueue.Add(float64(len(shorts)))
+		return job.ID, nil
+	case <-d.stopping:
+		d.reject(job)
+		return "", errors.ErrDeleteQueueStopped
+	case <-ctx.Done():
+		d.reject(job)
+		return "", fmt.Errorf("%w: %w", errors.ErrDeleteQueueFull, ctx.Err())
+	}
+}
+
+// reject убирает не попавшее в очередь задание.
+func (d *Deleter) reject(job *Job) {
+	d.mu.Lock()
+	delete(d.jobs, job.ID)
+	d.mu.Unlock()
+
+	d.release([]*Job{job})
+}
+
+// Копия задания id пользователя userID. Если задания нет или оно принадлежит другому пользователю, возвращается false.
+func (d *Deleter) Job(id string, userID string) (Job, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	job, exists := d.jobs[id]
+	if !exists || job.UserID != userID {
+		return Job{}, false
+	}
+
+	result := *job
+	if job.Results != nil {
+		result.Results = make(map[string]domain.DeleteStatus, len(job.Results))
+		for short, status := range job.Results {
+			result.Results[short] = status
+		}
+	}
+
+	return result, true
+}
+
+// Фоновое удаление ключей пачками, работает до отмены контекста. При запуске продолжаются задания,
+// сохраненные в storage прошлым запуском. После отмены новые задания не принимаются, оставшиеся в очереди
+// ключи удаляются (с повторами без задержки) и канал Done закрывается.
+func (d *Deleter) Run(ctx context.Context) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	queued := d.resume()
+	for {
+		select {
+		case job := <-d.requests:
+			queued += d.add(job)
+			if queued >= batchSize {
+				d.flush(false)
+				queued = 0
+			}
+		case <-ticker.C:
+			d.flush(false)
+			d.expire()
+			queued = 0
+		case <-ctx.Done():
+			d.stop()
+			for {
+				select {
+				case job := <-d.requests:
+					d.add(job)
+				default:
+					for attempt := 0; attempt < maxAttempts && len(d.pending) > 0; attempt++ {
+						d.flush(true)
+					}
+					d.discard()
+					return
+				}
+			}
+		}
+	}
+}
+
+// resume добавляет к ожидающим удаления задания, сохраненные в storage, и возвращает количество их ключей.
+func (d *Deleter) resume() int {
+	if d.jobber == nil {
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobStorageTimeout)
+	defer cancel()
+
+	stored, err := d.jobber.GetDeleteJobs(ctx)
+	if err != nil {
+		d.logger.Errorw(`Error occured while loading delete jobs`, `error`, err)
+		return 0
+	}
+
+	queued := 0
+	for _, item := range stored {
+		job := &Job{
+			ID:     item.ID,
+			UserID: item.UserID,
+			Status: JobStatusPending,
+			shorts: item.Shorts,
+		}
+
+		d.mu.Lock()
+		d.jobs[job.ID] = job
+		d.mu.Unlock()
+
+		metrics.DeleteBatchQueue.Add(float64(len(job.shorts)))
+		queued += d.add(job)
+	}
+
+	return queued
+}
+
+// stop закрывает прием заданий: дожидается вызовов Enqueue, уже отправляющих задание в очередь,
+// после чего Enqueue возвращает ErrDeleteQueueStopped.
+func (d *Deleter) stop() {
+	close(d.stopping)
+
+	d.stopMu.Lock()
+	d.stopped = true
+	d.stopMu.Unlock()
+}
+
+// Канал, закрывающийся после завершения Run.
+func (d *Deleter) Done() <-chan struct{} {
+	return d.done
+}
+
+// flush удаляет накопленные ключи по пользователям. Пачки, время повтора которых еще не наступило,
+// пропускаются, если не передан force (остановка). Вызывается только из горутины Run.
+func (d *Deleter) flush(force bool) {
+	now := d.now()
+	for userID, p := range d.pending {
+		if !force && now.Before(p.retryAt) {
+			continue
+		}
+
+		shorts := make([]string, 0, len(p.shorts))
+		for short := range p.shorts {
+			shorts = append(shorts, short)
+		}
+
+		d.finish(p.jobs, JobStatusRunning, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), deleteTimeout)
+		results, err := d.storage.DeleteBatch(ctx, userID, shorts)
+		cancel()
+
+		if err == nil {
+			delete(d.pending, userID)
+			metrics.DeleteBatchQueue.Sub(float64(len(shorts)))
+			d.finish(p.jobs, JobStatusDone, results)
+			d.release(p.jobs)
+			continue
+		}
+
+		p.attempts++
+		// при остановке пачка не отбрасывается: discard оставит ее задания в storage до следующего запуска
+		if p.attempts >= maxAttempts && !force {
+			d.logger.Errorw(`Error occured while deleting urls, giving up`, `error`, err, `userID`, userID, `count`, len(shorts))
+			delete(d.pending, userID)
+			metrics.DeleteBatchQueue.Sub(float64(len(shorts)))
+			d.finish(p.jobs, JobStatusFailed, nil)
+			d.release(p.jobs)
+			continue
+		}
+
+		d.logger.Warnw(`Error occured while deleting urls, will retry`, `error`, err, `userID`, userID, `attempt`, p.attempts)
+		p.retryAt = now.Add(retryDelay << (p.attempts - 1))
+		d.finish(p.jobs, JobStatusPending, nil)
+	}
+}
+
+// finish выставляет статус заданиям, для завершенных заданий запоминает результат по их ключам и время завершения.
+func (d *Deleter) finish(jobs []*Job, status JobStatus, results map[string]domain.DeleteStatus) {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, job := range jobs {
+		job.Status = status
+		if status == JobStatusDone {
+			job.Results = make(map[string]domain.DeleteStatus, len(job.shorts))
+			for _, short := range job.shorts {
+				job.Results[short] = results[short]
+			}
+		}
+
+		if status == JobStatusDone || status == JobStatusFailed {
+			job.finishedAt = now
+		}
+	}
+}
+
+// release удаляет из storage выполненные или отброшенные задания.
+func (d *Deleter) release(jobs []*Job) {
+	if d.jobber == nil {
+		return
+	}
+
+	for _, job := range jobs {
+		ctx, cancel := context.WithTimeout(context.Background(), jobStorageTimeout)
+		err := d.jobber.RemoveDeleteJob(ctx, job.ID)
+		cancel()
+
+		if err != nil {
+			d.logger.Errorw(`Error occured while removing delete job`, `error`, err, `jobID`, job.ID)
+		}
+	}
+}
+
+// add добавляет ключи задания к ожидающим удаления ключам пользователя и возвращает количество ключей задания.
+func (d *Deleter) add(job *Job) int {
+	p, exists := d.pending[job.UserID]
+	if !exists {
+		p = &pending{shorts: make(map[string]struct{}, len(job.shorts))}
+		d.pending[job.UserID] = p
+	}
+
+	p.jobs = append(p.jobs, job)
+	for _, short := range job.shorts {
+		if _, exists := p.shorts[short]; exists {
+			// повтор ключа: в очереди он учтен один раз
+			metrics.DeleteBatchQueue.Dec()
+			continue
+		}
+		p.shorts[short] = struct{}{}
+	}
+
+	return len(job.shorts)
+}
+
+// discard отбрасывает ключи, которые не удалось удалить при остановке. Сохраненные в storage задания
+// остаются в нем и продолжаются при следующем запуске.
+func (d *Deleter) discard() {
+	for userID, p := range d.pending {
+		d.logger.Errorw(`Urls are not deleted before shutdown`, `userID`, userID, `count`, len(p.shorts))
+		metrics.DeleteBatchQueue.Sub(float64(len(p.shorts)))
+		d.finish(p.jobs, JobStatusFailed, nil)
+		delete(d.pending, userID)
+	}
+}
+
+// expire удаляет задания, завершенные раньше чем jobRetention назад.
+func (d *Deleter) expire() {
+	before := d.now().Add(-jobRetention)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id, job := range d.jobs {
+		if !job.finishedAt.IsZero() && job.finishedAt.Before(before) {
+			delete(d.jobs, id)
+		}
+	}
+}
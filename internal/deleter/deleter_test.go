@@ -0,0 +1,285 @@
+package deleter
+
+import (
+	"context"
+	_goerrors "errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/mikesvis/short/internal/logger"
+	"github.com/mikesvis/short/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Storage, запоминающий вызовы DeleteBatch. Первые failures вызовов завершаются ошибкой.
+type fakeStorage struct {
+	storage.Storage
+	mu       sync.Mutex
+	failures int
+	calls    map[string][][]string
+}
+
+func newFakeStorage(failures int) *fakeStorage {
+	return &fakeStorage{failures: failures, calls: make(map[string][][]string)}
+}
+
+func (s *fakeStorage) DeleteBatch(ctx context.Context, userID string, pack []string) (map[string]domain.DeleteStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := append([]string(nil), pack...)
+	sort.Strings(sorted)
+	s.calls[userID] = append(s.calls[userID], sorted)
+
+	if s.failures > 0 {
+		s.failures--
+		return nil, _goerrors.New("storage is unavailable")
+	}
+
+	result := make(map[string]domain.DeleteStatus, len(pack))
+	for _, short := range pack {
+		result[short] = domain.DeleteStatusDeleted
+	}
+
+	return result, nil
+}
+
+// Storage, дополнительно хранящий задания на удаление.
+type fakeJobStorage struct {
+	*fakeStorage
+	jobs []domain.DeleteJob
+}
+
+func (s *fakeJobStorage) StoreDeleteJob(ctx context.Context, job domain.DeleteJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+func (s *fakeJobStorage) RemoveDeleteJob(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, job := range s.jobs {
+		if job.ID == id {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *fakeJobStorage) GetDeleteJobs(ctx context.Context) ([]domain.DeleteJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]domain.DeleteJob(nil), s.jobs...), nil
+}
+
+func TestDeleter_Run(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := newFakeStorage(0)
+	d := New(s, 10, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := d.Enqueue(ctx, "DoomGuy", []string{"iddqd", "idkfa"})
+	require.NoError(t, err)
+	second, err := d.Enqueue(ctx, "DoomGuy", []string{"idkfa", "idclip"})
+	require.NoError(t, err)
+	_, err = d.Enqueue(ctx, "Commander", []string{"idspispopd"})
+	require.NoError(t, err)
+
+	job, exists := d.Job(first, "DoomGuy")
+	require.True(t, exists)
+	assert.Equal(t, JobStatusPending, job.Status)
+
+	// запросы, принятые до остановки, удаляются при завершении Run
+	cancel()
+	d.Run(ctx)
+
+	select {
+	case <-d.Done():
+	default:
+		t.Fatal("Done is not closed after Run")
+	}
+
+	assert.Equal(t, map[string][][]string{
+		"DoomGuy":   {{"idclip", "iddqd", "idkfa"}},
+		"Commander": {{"idspispopd"}},
+	}, s.calls)
+	assert.Empty(t, d.pending)
+
+	// ключ из обоих заданий удален одной пачкой, результат есть в каждом задании
+	job, _ = d.Job(first, "DoomGuy")
+	assert.Equal(t, JobStatusDone, job.Status)
+	assert.Equal(t, map[string]domain.DeleteStatus{"iddqd": domain.DeleteStatusDeleted, "idkfa": domain.DeleteStatusDeleted}, job.Results)
+	job, _ = d.Job(second, "DoomGuy")
+	assert.Equal(t, map[string]domain.DeleteStatus{"idkfa": domain.DeleteStatusDeleted, "idclip": domain.DeleteStatusDeleted}, job.Results)
+
+	_, exists = d.Job(first, "Commander")
+	assert.False(t, exists)
+}
+
+func TestDeleter_flush(t *testing.T) {
+	l, _ := logger.NewLogger()
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		failures int
+		// сдвиги времени относительно now для последовательных вызовов flush
+		flushes []time.Duration
+		calls   int
+		pending bool
+		status  JobStatus
+	}{
+		{
+			name:     "Deleted at first attempt",
+			failures: 0,
+			flushes:  []time.Duration{0},
+			calls:    1,
+			pending:  false,
+			status:   JobStatusDone,
+		},
+		{
+			name:     "Retry is delayed",
+			failures: 1,
+			flushes:  []time.Duration{0, retryDelay / 2},
+			calls:    1,
+			pending:  true,
+			status:   JobStatusPending,
+		},
+		{
+			name:     "Deleted at retry",
+			failures: 1,
+			flushes:  []time.Duration{0, retryDelay},
+			calls:    2,
+			pending:  false,
+			status:   JobStatusDone,
+		},
+		{
+			name:     "Retry delay is doubled",
+			failures: 2,
+			flushes:  []time.Duration{0, retryDelay, 2 * retryDelay, 3 * retryDelay},
+			calls:    3,
+			pending:  false,
+			status:   JobStatusDone,
+		},
+		{
+			name:     "Given up after max attempts",
+			failures: maxAttempts + 1,
+			flushes:  []time.Duration{0, time.Hour, 2 * time.Hour, 3 * time.Hour, 4 * time.Hour, 5 * time.Hour},
+			calls:    maxAttempts,
+			pending:  false,
+			status:   JobStatusFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeStorage(tt.failures)
+			d := New(s, 10, l)
+			id, err := d.Enqueue(context.Background(), "DoomGuy", []string{"iddqd"})
+			require.NoError(t, err)
+			d.add(<-d.requests)
+
+			for _, shift := range tt.flushes {
+				d.now = func() time.Time { return now.Add(shift) }
+				d.flush(false)
+			}
+
+			assert.Len(t, s.calls["DoomGuy"], tt.calls)
+			_, pending := d.pending["DoomGuy"]
+			assert.Equal(t, tt.pending, pending)
+			job, _ := d.Job(id, "DoomGuy")
+			assert.Equal(t, tt.status, job.Status)
+		})
+	}
+}
+
+func TestDeleter_Enqueue(t *testing.T) {
+	l, _ := logger.NewLogger()
+	d := New(newFakeStorage(0), 1, l)
+
+	_, err := d.Enqueue(context.Background(), "DoomGuy", []string{"iddqd"})
+	require.NoError(t, err)
+
+	// очередь заполнена, запрос ждет до отмены контекста
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = d.Enqueue(ctx, "DoomGuy", []string{"idkfa"})
+	assert.ErrorIs(t, err, errors.ErrDeleteQueueFull)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, d.jobs, 1)
+}
+
+func TestDeleter_expire(t *testing.T) {
+	l, _ := logger.NewLogger()
+	now := time.Now()
+	d := New(newFakeStorage(0), 10, l)
+	d.now = func() time.Time { return now }
+
+	finished, _ := d.Enqueue(context.Background(), "DoomGuy", []string{"iddqd"})
+	d.add(<-d.requests)
+	d.flush(false)
+	queued, _ := d.Enqueue(context.Background(), "DoomGuy", []string{"idkfa"})
+
+	d.now = func() time.Time { return now.Add(jobRetention + time.Second) }
+	d.expire()
+
+	_, exists := d.Job(finished, "DoomGuy")
+	assert.False(t, exists)
+	_, exists = d.Job(queued, "DoomGuy")
+	assert.True(t, exists)
+}
+
+func TestDeleter_Stopped(t *testing.T) {
+	l, _ := logger.NewLogger()
+	d := New(newFakeStorage(0), 10, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.Run(ctx)
+
+	// после остановки задание не зависает в очереди навсегда, а отклоняется
+	_, err := d.Enqueue(context.Background(), "DoomGuy", []string{"iddqd"})
+	assert.ErrorIs(t, err, errors.ErrDeleteQueueStopped)
+	assert.Empty(t, d.jobs)
+}
+
+func TestDeleter_Resume(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := &fakeJobStorage{fakeStorage: newFakeStorage(maxAttempts)}
+
+	// storage недоступен до остановки: принятое задание остается сохраненным
+	d := New(s, 10, l)
+	id, err := d.Enqueue(context.Background(), "DoomGuy", []string{"iddqd"})
+	require.NoError(t, err)
+	require.Len(t, s.jobs, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.Run(ctx)
+	job, _ := d.Job(id, "DoomGuy")
+	assert.Equal(t, JobStatusFailed, job.Status)
+	require.Len(t, s.jobs, 1)
+
+	// после перезапуска задание продолжается под тем же ID и удаляется из storage после выполнения
+	d = New(s, 10, l)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	d.Run(ctx)
+
+	job, exists := d.Job(id, "DoomGuy")
+	require.True(t, exists)
+	assert.Equal(t, JobStatusDone, job.Status)
+	assert.Equal(t, map[string]domain.DeleteStatus{"iddqd": domain.DeleteStatusDeleted}, job.Results)
+	assert.Empty(t, s.jobs)
+}
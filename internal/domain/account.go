@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// Зарегистрированный аккаунт пользователя. ID аккаунта - это ID пользователя, которому принадлежат ссылки.
+type Account struct {
+	// ID пользователя.
+	ID string
+
+	// Логин, хранится в нижнем регистре.
+	Login string
+
+	// bcrypt хеш пароля.
+	PasswordHash string
+
+	// Время регистрации.
+	CreatedAt time.Time
+}
@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"sort"
+	"time"
+)
+
+// Права API ключа.
+type APIKeyScope string
+
+const (
+	// Сокращение ссылок.
+	APIKeyScopeCreate APIKeyScope = "create"
+
+	// Чтение ссылок пользователя, статистики и заданий на удаление.
+	APIKeyScopeRead APIKeyScope = "read"
+
+	// Удаление ссылок пользователя.
+	APIKeyScopeDelete APIKeyScope = "delete"
+)
+
+// Проверка, что права известны.
+func (s APIKeyScope) Valid() bool {
+	switch s {
+	case APIKeyScopeCreate, APIKeyScopeRead, APIKeyScopeDelete:
+		return true
+	}
+
+	return false
+}
+
+// Долгоживущий API ключ пользователя для сервисных аккаунтов. Сам ключ не хранится, хранится его хеш.
+type APIKey struct {
+	// ID ключа.
+	ID string
+
+	// ID пользователя, от имени которого работает ключ.
+	UserID string
+
+	// Название ключа.
+	Name string
+
+	// Хеш ключа.
+	Hash string
+
+	// Права ключа, пустые права - ключу разрешено все.
+	Scopes []APIKeyScope
+
+	// Время создания ключа.
+	CreatedAt time.Time
+}
+
+// Проверка, что ключу разрешено действие scope.
+func (k APIKey) Allows(scope APIKeyScope) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// API ключи пользователя userID из ключей keys в порядке создания.
+func UserAPIKeys(keys map[string]APIKey, userID string) []APIKey {
+	result := make([]APIKey, 0)
+	for _, key := range keys {
+		if key.UserID == userID {
+			result = append(result, key)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
+	return result
+}
@@ -0,0 +1,49 @@
+package domain
+
+import "time"
+
+// Принятое задание на удаление ключей пользователя, хранится в storage до выполнения.
+type DeleteJob struct {
+	// ID задания.
+	ID string
+
+	// ID пользователя.
+	UserID string
+
+	// Удаляемые короткие ключи.
+	Shorts []string
+
+	// Время постановки в очередь.
+	CreatedAt time.Time
+}
+
+// Результат удаления короткого ключа.
+type DeleteStatus string
+
+const (
+	// Ссылка удалена.
+	DeleteStatusDeleted DeleteStatus = "deleted"
+
+	// Ссылки с таким ключом нет.
+	DeleteStatusNotFound DeleteStatus = "not_found"
+
+	// Ссылка принадлежит другому пользователю.
+	DeleteStatusNotOwned DeleteStatus = "not_owned"
+
+	// Ссылка была удалена ранее.
+	DeleteStatusAlreadyDeleted DeleteStatus = "already_deleted"
+)
+
+// Результат удаления ссылки item пользователем userID. Пустой item - ссылка не найдена.
+func DeleteStatusOf(item URL, userID string) DeleteStatus {
+	switch {
+	case item.Short == "":
+		return DeleteStatusNotFound
+	case item.UserID != userID:
+		return DeleteStatusNotOwned
+	case item.Deleted:
+		return DeleteStatusAlreadyDeleted
+	}
+
+	return DeleteStatusDeleted
+}
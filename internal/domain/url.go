@@ -1,6 +1,11 @@
 // Модуль доменных сущностей.
 package domain
 
+import (
+	"net/http"
+	"time"
+)
+
 // ID в виде строки.
 type ID string
 
@@ -17,4 +22,44 @@ type URL struct {
 
 	// Флаг удаленного элемента.
 	Deleted bool
+
+	// Время истечения ссылки, нулевое значение - ссылка бессрочная.
+	ExpiresAt time.Time
+
+	// Максимальное количество переходов по ссылке, нулевое значение - без ограничения.
+	MaxClicks int
+
+	// Количество переходов по ссылке с ограничением.
+	Clicks int
+
+	// bcrypt хеш пароля ссылки, пустое значение - ссылка без пароля.
+	PasswordHash string
+
+	// HTTP статус редиректа, нулевое значение - статус по-умолчанию из конфига.
+	RedirectType int
+}
+
+// Проверка, что ссылка истекла к моменту now.
+func (u URL) Expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt)
+}
+
+// Проверка, что у ссылки с ограничением переходов не осталось доступных переходов.
+func (u URL) ClicksExhausted() bool {
+	return u.MaxClicks > 0 && u.Clicks >= u.MaxClicks
+}
+
+// Проверка, что ссылка защищена паролем.
+func (u URL) Protected() bool {
+	return len(u.PasswordHash) > 0
+}
+
+// Проверка, что статус допустим для редиректа: 301, 302, 307 или 308.
+func ValidRedirectType(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
 }
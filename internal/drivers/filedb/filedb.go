@@ -1,68 +1,251 @@
 // Модуль storage для хранения в файлах.
+//
+// Файл используется как журнал (append-only log): каждая запись - это JSON строка с состоянием ссылки.
+// При старте журнал один раз читается в индекс в памяти, более поздние записи с тем же uuid
+// перекрывают более ранние (так работают обновления и удаления). Чтение выполняется только из индекса,
+// запись - дописыванием в конец файла. Когда в журнале накапливается много перекрытых записей,
+// файл атомарно переписывается (компактизация).
+//
+// Сброс записей на диск выполняется согласно SyncPolicy. Если процесс упал посреди записи,
+// при следующем старте недописанная последняя запись отрезается. Файл блокируется через
+// соседний файл с суффиксом .lock, чтобы два процесса не писали в один журнал.
+//
+// API ключи, аккаунты пользователей и отозванные токены хранятся отдельно от журнала в соседних файлах
+// с суффиксами .apikeys, .accounts и .revoked, эти файлы небольшие и при каждом изменении атомарно
+// переписываются целиком.
 package filedb
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mikesvis/short/internal/domain"
 	"github.com/mikesvis/short/internal/errors"
-	"github.com/mikesvis/short/internal/keygen"
+	"github.com/mikesvis/short/internal/metrics"
 	"go.uber.org/zap"
 )
 
+// Имя драйвера в метриках storage.
+const driverName = "filedb"
+
+// Минимальное количество записей в журнале, начиная с которого имеет смысл компактизация.
+const compactMinRecords = 1000
+
+// Суффикс соседнего файла с API ключами.
+const apiKeysSuffix = ".apikeys"
+
+// Суффикс соседнего файла с аккаунтами.
+const accountsSuffix = ".accounts"
+
+// Суффикс соседнего файла с отозванными токенами.
+const revokedSuffix = ".revoked"
+
+// Суффикс соседнего файла со счетчиком последовательных ключей.
+const sequenceSuffix = ".sequence"
+
+// Суффикс соседнего файла с невыполненными заданиями на удаление.
+const deleteJobsSuffix = ".deletions"
+
+// Во сколько раз количество записей в журнале должно превышать количество живых элементов для компактизации.
+const compactRatio = 2
+
+// Политика сброса журнала на диск (fsync).
+type SyncPolicy string
+
+const (
+	// SyncAlways - fsync после каждой операции записи, операция завершается только после сброса на диск.
+	SyncAlways SyncPolicy = "always"
+
+	// SyncInterval - fsync в фоне раз в заданный период, если были записи.
+	SyncInterval SyncPolicy = "interval"
+
+	// SyncNever - fsync не вызывается, сброс на диск на усмотрение ОС.
+	SyncNever SyncPolicy = "never"
+)
+
 type fileDBItem struct {
-	UUID        string `json:"uuid"`
-	UserID      string `json:"user_id"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	Deleted     bool   `json:"is_deleted"`
+	UUID         string     `json:"uuid"`
+	UserID       string     `json:"user_id"`
+	ShortURL     string     `json:"short_url"`
+	OriginalURL  string     `json:"original_url"`
+	Deleted      bool       `json:"is_deleted"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxClicks    int        `json:"max_clicks,omitempty"`
+	Clicks       int        `json:"clicks,omitempty"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	RedirectType int        `json:"redirect_type,omitempty"`
 }
 
-// Storage для хранения в файлах, включает в себя путь к файлу и логгер.
+type fileDBAPIKey struct {
+	ID        string               `json:"id"`
+	UserID    string               `json:"user_id"`
+	Name      string               `json:"name"`
+	Hash      string               `json:"hash"`
+	Scopes    []domain.APIKeyScope `json:"scopes,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+type fileDBDeleteJob struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	Shorts    []string  `json:"shorts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type fileDBAccount struct {
+	ID           string    `json:"id"`
+	Login        string    `json:"login"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Storage для хранения в файлах, включает в себя путь к файлу, открытый на дозапись файл,
+// файл блокировки, индекс в памяти, API ключи по хешу, аккаунты по ID пользователя с индексом по логину,
+// отозванные токены со временем их истечения, счетчик последовательных ключей, невыполненные задания
+// на удаление и логгер. Доступ к индексу и файлам защищен RWMutex.
 type FileDB struct {
-	fileName string
-	logger   *zap.SugaredLogger
+	mu         sync.RWMutex
+	fileName   string
+	file       *os.File
+	lock       *os.File
+	syncPolicy SyncPolicy
+	dirty      bool
+	done       chan struct{}
+	wg         sync.WaitGroup
+	items      map[string]fileDBItem
+	order      []string
+	shorts     map[string]string
+	fulls      map[string]string
+	users      map[string][]string
+	apiKeys    map[string]domain.APIKey
+	accounts   map[string]domain.Account
+	logins     map[string]string
+	revoked    map[string]time.Time
+	sequence   uint64
+	deleteJobs []fileDBDeleteJob
+	records    int
+	logger     *zap.SugaredLogger
 }
 
-// Конструктор storage для файла.
-func NewFileDB(fileName string, logger *zap.SugaredLogger) *FileDB {
-	s := &FileDB{fileName, logger}
+// Конструктор storage для файла. Файл блокируется, журнал читается в индекс (с отрезанием недописанной
+// последней записи) и остается открытым на дозапись. Для политики SyncInterval запускается фоновый fsync.
+// Пустая политика равнозначна SyncAlways.
+func NewFileDB(fileName string, syncPolicy SyncPolicy, syncInterval time.Duration, logger *zap.SugaredLogger) (*FileDB, error) {
+	switch syncPolicy {
+	case "":
+		syncPolicy = SyncAlways
+	case SyncAlways, SyncNever:
+	case SyncInterval:
+		if syncInterval <= 0 {
+			return nil, fmt.Errorf("file sync interval must be positive, got %s", syncInterval)
+		}
+	default:
+		return nil, fmt.Errorf("unknown file sync policy %q", syncPolicy)
+	}
 
-	return s
+	s := &FileDB{
+		fileName:   fileName,
+		syncPolicy: syncPolicy,
+		done:       make(chan struct{}),
+		items:      make(map[string]fileDBItem),
+		shorts:     make(map[string]string),
+		fulls:      make(map[string]string),
+		users:      make(map[string][]string),
+		apiKeys:    make(map[string]domain.APIKey),
+		accounts:   make(map[string]domain.Account),
+		logins:     make(map[string]string),
+		revoked:    make(map[string]time.Time),
+		logger:     logger,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadAPIKeys(); err != nil {
+		s.release()
+		return nil, err
+	}
+
+	if err := s.loadAccounts(); err != nil {
+		s.release()
+		return nil, err
+	}
+
+	if err := s.loadSidecar(revokedSuffix, &s.revoked); err != nil {
+		s.release()
+		return nil, err
+	}
+
+	if err := s.loadSidecar(sequenceSuffix, &s.sequence); err != nil {
+		s.release()
+		return nil, err
+	}
+
+	if err := s.loadSidecar(deleteJobsSuffix, &s.deleteJobs); err != nil {
+		s.release()
+		return nil, err
+	}
+
+	// файла счетчика еще нет: номера не меньше количества уже сохраненных ссылок могли быть выданы
+	if s.sequence == 0 {
+		s.sequence = uint64(len(s.items))
+	}
+
+	if s.needsCompaction() {
+		if err := s.compact(); err != nil {
+			s.release()
+			return nil, err
+		}
+	}
+
+	if syncPolicy == SyncInterval {
+		s.wg.Add(1)
+		go s.syncLoop(syncInterval)
+	}
+
+	return s, nil
 }
 
 // Сохранение короткой ссылки. При сохранении происходит поиск на предмет уже существующей ссылки.
-// В случае если такая ссылка уже была ранее создана вернется ошибка.
+// В случае если такая ссылка уже была ранее создана вернется ошибка ErrConflict,
+// если занят короткий ключ - ErrShortKeyConflict. Истекшая ссылка с тем же полным URL перезаписывается новой.
 func (s *FileDB) Store(ctx context.Context, u domain.URL) (domain.URL, error) {
-	old, err := s.GetByFull(ctx, u.Full)
-	if err != nil {
-		return domain.URL{}, nil
+	defer metrics.ObserveStorage(driverName, "Store", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, expired := s.findFull(u.Full, time.Now())
+	if len(id) > 0 && !expired {
+		return s.items[id].toURL(), errors.ErrConflict
 	}
 
-	if (old != domain.URL{}) {
-		return old, errors.ErrConflict
+	if shortID, exists := s.shorts[u.Short]; exists && shortID != id {
+		return domain.URL{}, errors.ErrShortKeyConflict
 	}
 
-	file, err := os.OpenFile(s.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		return domain.URL{}, err
+	// истекшая ссылка перекрывается записью с тем же uuid
+	if len(id) == 0 {
+		id = uuid.NewString()
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
+	item := newFileDBItem(id, u)
 
-	item := fileDBItem{
-		UUID:        uuid.NewString(),
-		UserID:      u.UserID,
-		ShortURL:    u.Short,
-		OriginalURL: u.Full,
+	if err := s.append(item); err != nil {
+		return domain.URL{}, err
 	}
 
-	if err := encoder.Encode(&item); err != nil {
+	if err := s.commit(); err != nil {
 		return domain.URL{}, err
 	}
 
@@ -71,189 +254,951 @@ func (s *FileDB) Store(ctx context.Context, u domain.URL) (domain.URL, error) {
 
 // Поиск по полной ссылке.
 func (s *FileDB) GetByFull(ctx context.Context, fullURL string) (domain.URL, error) {
-	file, err := os.OpenFile(s.fileName, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return domain.URL{}, err
+	defer metrics.ObserveStorage(driverName, "GetByFull", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, exists := s.fulls[fullURL]
+	if !exists {
+		return domain.URL{}, nil
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
+	return s.items[id].toURL(), nil
+}
 
-	for {
-		var i fileDBItem
-		if err := decoder.Decode(&i); err == io.EOF {
-			break
-		} else if err != nil {
-			return domain.URL{}, err
+// Поиск по короткой ссылке.
+func (s *FileDB) GetByShort(ctx context.Context, shortURL string) (domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "GetByShort", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, exists := s.shorts[shortURL]
+	if !exists {
+		return domain.URL{}, nil
+	}
+
+	return s.items[id].toURL(), nil
+}
+
+// Пинг хранилки в файле.
+func (s *FileDB) Ping(ctx context.Context) error {
+	defer metrics.ObserveStorage(driverName, "Ping", time.Now())
+
+	_, error := os.Stat(s.fileName)
+
+	return error
+}
+
+// Пакетное сохранение коротких URL. В методе используется поиск уже существующих URL.
+// Если хотя бы один новый короткий ключ занят, ничего не сохраняется и возвращается ErrShortKeyConflict.
+func (s *FileDB) StoreBatch(ctx context.Context, us map[string]domain.URL) (map[string]domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "StoreBatch", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.hasShortConflict(us, now) {
+		return nil, errors.ErrShortKeyConflict
+	}
+
+	for k, v := range us {
+		id, expired := s.findFull(v.Full, now)
+		if len(id) > 0 && !expired {
+			// урл был сохранен ранее: восстанавливаем его старый short вместо нового
+			us[k] = s.items[id].toURL()
+			continue
+		}
+
+		// истекшая ссылка перекрывается записью с тем же uuid
+		if len(id) == 0 {
+			id = uuid.NewString()
+		}
+
+		item := newFileDBItem(id, v)
+
+		if err := s.append(item); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.commit(); err != nil {
+		return nil, err
+	}
+
+	return us, nil
+}
+
+// Получение ссылок, созданных пользоваетелем.
+func (s *FileDB) GetUserURLs(ctx context.Context, userID string) ([]domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "GetUserURLs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.users[userID]
+	result := make([]domain.URL, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, s.items[id].toURL())
+	}
+
+	return result, nil
+}
+
+// Пакетное удаление коротких ссылок. Удаляются только ссылки, принадлежащие пользователю userID.
+// Для каждой удаленной ссылки в журнал дописывается запись с флагом is_deleted.
+// Возвращает результат удаления по каждому ключу.
+func (s *FileDB) DeleteBatch(ctx context.Context, userID string, pack []string) (map[string]domain.DeleteStatus, error) {
+	defer metrics.ObserveStorage(driverName, "DeleteBatch", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]domain.DeleteStatus, len(pack))
+	for _, short := range pack {
+		if _, seen := result[short]; seen {
+			continue
 		}
 
-		if i.OriginalURL != fullURL {
+		id, exists := s.shorts[short]
+		if !exists {
+			result[short] = domain.DeleteStatusNotFound
 			continue
 		}
 
-		return domain.URL{
-			UserID:  i.UserID,
-			Full:    i.OriginalURL,
-			Short:   i.ShortURL,
-			Deleted: i.Deleted,
-		}, nil
+		item := s.items[id]
+		result[short] = domain.DeleteStatusOf(item.toURL(), userID)
+		if result[short] != domain.DeleteStatusDeleted {
+			continue
+		}
+
+		item.Deleted = true
+		if err := s.append(item); err != nil {
+			s.logger.Errorw(`Error occured while appending deleted item`, err, `short`, short)
+			return nil, err
+		}
 	}
 
-	return domain.URL{}, nil
+	if err := s.commit(); err != nil {
+		s.logger.Errorw(`Error occured while syncing file`, err)
+		return nil, err
+	}
+
+	return result, nil
 }
 
-// Поиск по короткой ссылке.
-func (s *FileDB) GetByShort(ctx context.Context, shortURL string) (domain.URL, error) {
-	file, err := os.OpenFile(s.fileName, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
+// Количество неудаленных ссылок.
+func (s *FileDB) CountURLs(ctx context.Context) (int, error) {
+	defer metrics.ObserveStorage(driverName, "CountURLs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, item := range s.items {
+		if !item.Deleted {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Количество пользователей, у которых есть неудаленные ссылки.
+func (s *FileDB) CountUsers(ctx context.Context) (int, error) {
+	defer metrics.ObserveStorage(driverName, "CountUsers", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, ids := range s.users {
+		for _, id := range ids {
+			if !s.items[id].Deleted {
+				count++
+				break
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// Учет перехода по короткой ссылке. В журнал дописывается запись с увеличенным счетчиком переходов,
+// операция выполняется под блокировкой на запись, поэтому конкурентные переходы не превышают лимит.
+// Если лимит исчерпан, возвращается ErrClickLimitReached.
+func (s *FileDB) Click(ctx context.Context, shortURL string) (domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "Click", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, exists := s.shorts[shortURL]
+	if !exists {
+		return domain.URL{}, nil
+	}
+
+	item := s.items[id]
+	if item.toURL().ClicksExhausted() {
+		return item.toURL(), errors.ErrClickLimitReached
+	}
+
+	item.Clicks++
+	if err := s.append(item); err != nil {
 		return domain.URL{}, err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
+	if err := s.commit(); err != nil {
+		return domain.URL{}, err
+	}
+
+	return item.toURL(), nil
+}
+
+// Компактизация журнала: файл атомарно переписывается актуальными состояниями элементов.
+func (s *FileDB) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// Закрытие хранилки: останавливается фоновый fsync, журнал сбрасывается на диск,
+// файл закрывается и блокировка снимается.
+func (s *FileDB) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.file.Sync()
+	if closeErr := s.release(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// load блокирует файл, читает журнал в индекс и оставляет файл открытым на дозапись.
+// Если последняя запись в журнале недописана (процесс упал во время записи), она отрезается.
+// Испорченная запись в середине журнала считается ошибкой.
+func (s *FileDB) load() error {
+	file, err := os.OpenFile(s.fileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(s.fileName+".lock", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = lockFile(lock); err != nil {
+		lock.Close()
+		file.Close()
+		return err
+	}
 
+	s.file = file
+	s.lock = lock
+
+	if err = s.recover(); err != nil {
+		s.release()
+		return err
+	}
+
+	return nil
+}
+
+// recover читает журнал построчно в индекс. Недописанная или испорченная последняя строка отрезается,
+// после последней записи гарантируется перевод строки.
+func (s *FileDB) recover() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
 	for {
-		var i fileDBItem
-		if err := decoder.Decode(&i); err == io.EOF {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(line) == 0 {
 			break
-		} else if err != nil {
-			return domain.URL{}, err
 		}
 
-		if i.ShortURL != shortURL {
+		var i fileDBItem
+		decodeErr := json.Unmarshal(line, &i)
+		if decodeErr == nil {
+			s.put(i)
+			offset += int64(len(line))
+			continue
+		}
+
+		// пустые строки пропускаем
+		if len(bytes.TrimSpace(line)) == 0 {
+			offset += int64(len(line))
 			continue
 		}
 
-		return domain.URL{
-			UserID:  i.UserID,
-			Full:    i.OriginalURL,
-			Short:   i.ShortURL,
-			Deleted: i.Deleted,
-		}, nil
+		// испорченная строка не последняя: это не оборванная запись, а повреждение журнала
+		if _, peekErr := reader.Peek(1); peekErr != io.EOF {
+			return fmt.Errorf("file storage %s is corrupted at offset %d: %w", s.fileName, offset, decodeErr)
+		}
+
+		s.logger.Warnw(`Truncating torn record at the end of file storage`, `file`, s.fileName, `offset`, offset, `record`, string(line))
+		if err = s.file.Truncate(offset); err != nil {
+			return err
+		}
+
+		if err = s.file.Sync(); err != nil {
+			return err
+		}
+
+		break
 	}
 
-	return domain.URL{}, nil
+	// последняя запись могла быть записана без перевода строки, дописываем его
+	// чтобы новые записи начинались с новой строки
+	return ensureTrailingNewline(s.file)
 }
 
-// Пинг хранилки в файле.
-func (s *FileDB) Ping(ctx context.Context) error {
-	_, error := os.Stat(s.fileName)
+// release закрывает файл журнала и снимает блокировку.
+func (s *FileDB) release() error {
+	err := s.file.Close()
+	unlockFile(s.lock)
+	s.lock.Close()
 
-	return error
+	return err
 }
 
-// Пакетное сохранение коротких URL. В методе используется поиск уже существующих URL.
-func (s *FileDB) StoreBatch(ctx context.Context, us map[string]domain.URL) (map[string]domain.URL, error) {
-	// в мапе хранится полный урл = ключ корреляции
-	wantToStore := make(map[string]string, len(us))
+// syncLoop раз в interval сбрасывает журнал на диск, если с прошлого раза были записи.
+func (s *FileDB) syncLoop(interval time.Duration) {
+	defer s.wg.Done()
 
-	for k, v := range us {
-		wantToStore[string(v.Full)] = k
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				if err := s.file.Sync(); err != nil {
+					s.logger.Errorw(`Error occured while syncing file`, err)
+				} else {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		}
 	}
+}
 
-	// для начала найдем совпадения по урлу, которые были сохранены ранее
-	file, err := os.OpenFile(s.fileName, os.O_RDONLY|os.O_CREATE, 0666)
+// append дописывает запись в журнал и обновляет индекс, вызывается под блокировкой на запись.
+func (s *FileDB) append(item fileDBItem) error {
+	line, err := json.Marshal(&item)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	for {
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
 
-		var i fileDBItem
-		if err = decoder.Decode(&i); err == io.EOF {
-			break
-		} else if err != nil {
-			return nil, err
+	s.put(item)
+
+	return nil
+}
+
+// commit завершает операцию записи: сбрасывает журнал на диск согласно политике и при необходимости
+// запускает компактизацию. Вызывается под блокировкой на запись.
+func (s *FileDB) commit() error {
+	switch s.syncPolicy {
+	case SyncAlways:
+		if err := s.file.Sync(); err != nil {
+			return err
 		}
+	case SyncInterval:
+		s.dirty = true
+	}
 
-		k, exists := wantToStore[i.OriginalURL]
-		if exists {
-			// урл был сохранен ранее: удаляем из списка на сохранение и
-			// восстанавливаем его старый short вместо нового
-			delete(wantToStore, i.OriginalURL)
-			us[k] = domain.URL{
-				UserID:  i.UserID,
-				Full:    i.OriginalURL,
-				Short:   i.ShortURL,
-				Deleted: i.Deleted,
-			}
+	if s.needsCompaction() {
+		if err := s.compact(); err != nil {
+			s.logger.Errorw(`Error occured while compacting file`, err)
 		}
+	}
 
-		// список на сохранение пустой, не смысла искать далее (все элементы уже есть в хранилке)
-		if len(wantToStore) == 0 {
-			break
+	return nil
+}
+
+// Удаление ссылок, истекших раньше before. Журнал после удаления переписывается компактизацией.
+func (s *FileDB) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	defer metrics.ObserveStorage(driverName, "DeleteExpired", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order := s.order[:0]
+	count := 0
+	for _, id := range s.order {
+		item := s.items[id]
+		if !item.toURL().Expired(before) {
+			order = append(order, id)
+			continue
 		}
+
+		s.unindex(item)
+		delete(s.items, id)
+		count++
 	}
+	s.order = order
 
-	// все элементы на сохранение уже есть, нечего сохранять
-	if len(wantToStore) == 0 {
-		return us, nil
+	if count == 0 {
+		return 0, nil
 	}
 
-	// будем сохранять только те елементы, которых нет
-	file, err = os.OpenFile(s.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		return nil, err
+	return count, s.compact()
+}
+
+// findFull ищет ссылку по полному URL и возвращает ее uuid (пустой, если ссылки нет) и признак истечения.
+func (s *FileDB) findFull(full string, now time.Time) (string, bool) {
+	id, exists := s.fulls[full]
+	if !exists {
+		return "", false
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
+	return id, s.items[id].toURL().Expired(now)
+}
 
-	// пишем в файл только новые
-	for _, v := range wantToStore {
+// hasShortConflict проверяет, что короткие ключи новых ссылок пакета не заняты и не повторяются внутри пакета.
+// Ключ истекшей ссылки с тем же полным URL не считается занятым, эта ссылка будет перезаписана.
+func (s *FileDB) hasShortConflict(us map[string]domain.URL, now time.Time) bool {
+	seen := make(map[string]struct{}, len(us))
+	for _, v := range us {
+		fullID, expired := s.findFull(v.Full, now)
+		if len(fullID) > 0 && !expired {
+			continue
+		}
 
-		item := fileDBItem{
-			UUID:        uuid.NewString(),
-			UserID:      us[v].UserID,
-			ShortURL:    us[v].Short,
-			OriginalURL: us[v].Full,
-			Deleted:     us[v].Deleted,
+		if id, exists := s.shorts[v.Short]; exists && id != fullID {
+			return true
 		}
 
-		if err := encoder.Encode(&item); err != nil {
-			return nil, err
+		if _, exists := seen[v.Short]; exists {
+			return true
 		}
+		seen[v.Short] = struct{}{}
 	}
 
-	return us, nil
+	return false
 }
 
-// Получение ссылок, созданных пользоваетелем.
-func (s *FileDB) GetUserURLs(ctx context.Context, userID string) ([]domain.URL, error) {
-	file, err := os.OpenFile(s.fileName, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return nil, err
+// put обновляет индекс записью журнала: более поздняя запись с тем же uuid перекрывает предыдущую.
+func (s *FileDB) put(item fileDBItem) {
+	s.records++
+
+	old, exists := s.items[item.UUID]
+	s.items[item.UUID] = item
+	if exists {
+		// флаг удаления, время истечения и счетчик переходов не меняют ключи индекса
+		if old.ShortURL == item.ShortURL && old.OriginalURL == item.OriginalURL && old.UserID == item.UserID {
+			return
+		}
+		s.unindex(old)
+	} else {
+		s.order = append(s.order, item.UUID)
 	}
-	defer file.Close()
 
-	result := make([]domain.URL, 0, 20)
+	s.shorts[item.ShortURL] = item.UUID
+	s.fulls[item.OriginalURL] = item.UUID
+	s.users[item.UserID] = append(s.users[item.UserID], item.UUID)
+}
 
-	decoder := json.NewDecoder(file)
-	for {
-		var i fileDBItem
-		if err := decoder.Decode(&i); err == io.EOF {
+// unindex убирает из индексов ключи старого состояния элемента.
+func (s *FileDB) unindex(item fileDBItem) {
+	delete(s.shorts, item.ShortURL)
+	delete(s.fulls, item.OriginalURL)
+
+	ids := s.users[item.UserID]
+	for k, id := range ids {
+		if id == item.UUID {
+			s.users[item.UserID] = append(ids[:k:k], ids[k+1:]...)
 			break
-		} else if err != nil {
-			return nil, err
 		}
+	}
+}
+
+func (s *FileDB) needsCompaction() bool {
+	return s.records >= compactMinRecords && s.records > compactRatio*len(s.items)
+}
+
+// compact переписывает журнал во временный файл в той же директории и переименовывает его в файл хранилки,
+// после чего файл заново открывается на дозапись. Вызывается под блокировкой на запись.
+func (s *FileDB) compact() error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.fileName), filepath.Base(s.fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// сохраняем права исходного файла, временный создается с 0600
+	if info, err := s.file.Stat(); err == nil {
+		tmpFile.Chmod(info.Mode().Perm())
+	}
+
+	encoder := json.NewEncoder(tmpFile)
+	for _, id := range s.order {
+		item := s.items[id]
+		if err = encoder.Encode(&item); err != nil {
+			tmpFile.Close()
+			return err
+		}
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpFile.Name(), s.fileName); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.fileName, os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	s.file.Close()
+	s.file = file
+	s.records = len(s.items)
+	s.dirty = false
+
+	return nil
+}
+
+// Сохранение API ключа.
+func (s *FileDB) StoreAPIKey(ctx context.Context, key domain.APIKey) error {
+	defer metrics.ObserveStorage(driverName, "StoreAPIKey", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apiKeys[key.Hash] = key
+	if err := s.saveAPIKeys(); err != nil {
+		delete(s.apiKeys, key.Hash)
+		s.logger.Errorw(`Error occured while saving api keys`, err)
+		return err
+	}
+
+	return nil
+}
 
-		if i.UserID != userID {
+// Получение API ключа по хешу.
+func (s *FileDB) GetAPIKeyByHash(ctx context.Context, hash string) (domain.APIKey, error) {
+	defer metrics.ObserveStorage(driverName, "GetAPIKeyByHash", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.apiKeys[hash], nil
+}
+
+// Получение API ключей пользователя в порядке создания.
+func (s *FileDB) GetUserAPIKeys(ctx context.Context, userID string) ([]domain.APIKey, error) {
+	defer metrics.ObserveStorage(driverName, "GetUserAPIKeys", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return domain.UserAPIKeys(s.apiKeys, userID), nil
+}
+
+// Отзыв API ключа id пользователя userID, ключ удаляется из файла ключей.
+func (s *FileDB) RevokeAPIKey(ctx context.Context, userID string, id string) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "RevokeAPIKey", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, key := range s.apiKeys {
+		if key.ID != id || key.UserID != userID {
 			continue
 		}
 
-		result = append(result, domain.URL{
-			UserID: i.UserID,
-			Full:   i.OriginalURL,
-			Short:  i.ShortURL,
-		})
+		delete(s.apiKeys, hash)
+		if err := s.saveAPIKeys(); err != nil {
+			s.apiKeys[hash] = key
+			s.logger.Errorw(`Error occured while saving api keys`, err)
+			return false, err
+		}
+
+		return true, nil
 	}
 
-	return result, nil
+	return false, nil
 }
 
-// Получение рандомного ключа
-func (s *FileDB) GetRandkey(n uint) string {
-	return keygen.GetRandkey(n)
+// loadAPIKeys читает файл API ключей.
+func (s *FileDB) loadAPIKeys() error {
+	var keys []fileDBAPIKey
+	if err := s.loadSidecar(apiKeysSuffix, &keys); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		s.apiKeys[k.Hash] = domain.APIKey(k)
+	}
+
+	return nil
+}
+
+// saveAPIKeys переписывает файл API ключей, вызывается под блокировкой на запись.
+func (s *FileDB) saveAPIKeys() error {
+	keys := make([]fileDBAPIKey, 0, len(s.apiKeys))
+	for _, k := range s.apiKeys {
+		keys = append(keys, fileDBAPIKey(k))
+	}
+
+	return s.saveSidecar(apiKeysSuffix, keys)
+}
+
+// Сохранение аккаунта. Если логин занят, возвращается ErrLoginTaken, если у пользователя уже есть аккаунт - ErrConflict.
+func (s *FileDB) StoreAccount(ctx context.Context, a domain.Account) error {
+	defer metrics.ObserveStorage(driverName, "StoreAccount", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.logins[a.Login]; exists {
+		return errors.ErrLoginTaken
+	}
+
+	if _, exists := s.accounts[a.ID]; exists {
+		return errors.ErrConflict
+	}
+
+	s.accounts[a.ID] = a
+	s.logins[a.Login] = a.ID
+	if err := s.saveAccounts(); err != nil {
+		delete(s.accounts, a.ID)
+		delete(s.logins, a.Login)
+		s.logger.Errorw(`Error occured while saving accounts`, err)
+		return err
+	}
+
+	return nil
+}
+
+// Получение аккаунта по ID пользователя.
+func (s *FileDB) GetAccount(ctx context.Context, userID string) (domain.Account, error) {
+	defer metrics.ObserveStorage(driverName, "GetAccount", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.accounts[userID], nil
+}
+
+// Получение аккаунта по логину.
+func (s *FileDB) GetAccountByLogin(ctx context.Context, login string) (domain.Account, error) {
+	defer metrics.ObserveStorage(driverName, "GetAccountByLogin", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, exists := s.logins[login]
+	if !exists {
+		return domain.Account{}, nil
+	}
+
+	return s.accounts[id], nil
+}
+
+// Перенос ссылок пользователя fromUserID пользователю toUserID. В журнал дописываются
+// записи ссылок с новым владельцем.
+func (s *FileDB) MergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	defer metrics.ObserveStorage(driverName, "MergeUserURLs", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := append([]string(nil), s.users[fromUserID]...)
+	for _, id := range ids {
+		item := s.items[id]
+		item.UserID = toUserID
+		if err := s.append(item); err != nil {
+			s.logger.Errorw(`Error occured while writing to file`, err)
+			return 0, err
+		}
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	return len(ids), s.commit()
+}
+
+// loadAccounts читает файл аккаунтов.
+func (s *FileDB) loadAccounts() error {
+	var accounts []fileDBAccount
+	if err := s.loadSidecar(accountsSuffix, &accounts); err != nil {
+		return err
+	}
+
+	for _, a := range accounts {
+		s.accounts[a.ID] = domain.Account(a)
+		s.logins[a.Login] = a.ID
+	}
+
+	return nil
+}
+
+// saveAccounts переписывает файл аккаунтов, вызывается под блокировкой на запись.
+func (s *FileDB) saveAccounts() error {
+	accounts := make([]fileDBAccount, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, fileDBAccount(a))
+	}
+
+	return s.saveSidecar(accountsSuffix, accounts)
+}
+
+// Отзыв токена jti до expiresAt. Записи об отзыве истекших токенов удаляются при каждом отзыве.
+func (s *FileDB) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "RevokeToken", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range s.revoked {
+		if v.Before(now) {
+			delete(s.revoked, k)
+		}
+	}
+
+	if _, exists := s.revoked[jti]; exists {
+		return false, nil
+	}
+
+	s.revoked[jti] = expiresAt
+	if err := s.saveSidecar(revokedSuffix, s.revoked); err != nil {
+		delete(s.revoked, jti)
+		s.logger.Errorw(`Error occured while saving revoked tokens`, err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Проверка, что токен jti отозван.
+func (s *FileDB) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "IsTokenRevoked", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.revoked[jti]
+	return exists, nil
+}
+
+// Резервирование size номеров счетчика последовательных ключей, счетчик сохраняется в соседний файл.
+func (s *FileDB) ReserveSequence(ctx context.Context, size uint64) (uint64, error) {
+	defer metrics.ObserveStorage(driverName, "ReserveSequence", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.saveSidecar(sequenceSuffix, s.sequence+size); err != nil {
+		s.logger.Errorw(`Error occured while saving key sequence`, err)
+		return 0, err
+	}
+	s.sequence += size
+
+	return s.sequence, nil
+}
+
+// Сохранение задания на удаление в соседний файл.
+func (s *FileDB) StoreDeleteJob(ctx context.Context, job domain.DeleteJob) error {
+	defer metrics.ObserveStorage(driverName, "StoreDeleteJob", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteJobs = append(s.deleteJobs, fileDBDeleteJob(job))
+	if err := s.saveSidecar(deleteJobsSuffix, s.deleteJobs); err != nil {
+		s.deleteJobs = s.deleteJobs[:len(s.deleteJobs)-1]
+		s.logger.Errorw(`Error occured while saving delete jobs`, err)
+		return err
+	}
+
+	return nil
+}
+
+// Удаление задания на удаление из соседнего файла.
+func (s *FileDB) RemoveDeleteJob(ctx context.Context, id string) error {
+	defer metrics.ObserveStorage(driverName, "RemoveDeleteJob", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]fileDBDeleteJob, 0, len(s.deleteJobs))
+	for _, job := range s.deleteJobs {
+		if job.ID != id {
+			jobs = append(jobs, job)
+		}
+	}
+
+	if len(jobs) == len(s.deleteJobs) {
+		return nil
+	}
+
+	if err := s.saveSidecar(deleteJobsSuffix, jobs); err != nil {
+		s.logger.Errorw(`Error occured while saving delete jobs`, err)
+		return err
+	}
+	s.deleteJobs = jobs
+
+	return nil
+}
+
+// Невыполненные задания на удаление в порядке постановки.
+func (s *FileDB) GetDeleteJobs(ctx context.Context) ([]domain.DeleteJob, error) {
+	defer metrics.ObserveStorage(driverName, "GetDeleteJobs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]domain.DeleteJob, 0, len(s.deleteJobs))
+	for _, job := range s.deleteJobs {
+		jobs = append(jobs, domain.DeleteJob(job))
+	}
+
+	return jobs, nil
+}
+
+// loadSidecar читает JSON из соседнего файла с суффиксом suffix, отсутствующий файл означает, что данных нет.
+func (s *FileDB) loadSidecar(suffix string, v any) error {
+	data, err := os.ReadFile(s.fileName + suffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("file %s%s is corrupted: %w", s.fileName, suffix, err)
+	}
+
+	return nil
+}
+
+// saveSidecar переписывает соседний файл с суффиксом suffix через временный файл в той же директории,
+// чтобы при падении файл не остался недописанным.
+func (s *FileDB) saveSidecar(suffix string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	fileName := s.fileName + suffix
+	tmpFile, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), fileName)
+}
+
+// ensureTrailingNewline дописывает перевод строки, если непустой файл им не заканчивается.
+func ensureTrailingNewline(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		return nil
+	}
+
+	last := make([]byte, 1)
+	if _, err = file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+
+	if last[0] == '\n' {
+		return nil
+	}
+
+	_, err = file.Write([]byte("\n"))
+	return err
+}
+
+func (i fileDBItem) toURL() domain.URL {
+	u := domain.URL{
+		UserID:       i.UserID,
+		Full:         i.OriginalURL,
+		Short:        i.ShortURL,
+		Deleted:      i.Deleted,
+		MaxClicks:    i.MaxClicks,
+		Clicks:       i.Clicks,
+		PasswordHash: i.PasswordHash,
+		RedirectType: i.RedirectType,
+	}
+
+	if i.ExpiresAt != nil {
+		u.ExpiresAt = *i.ExpiresAt
+	}
+
+	return u
+}
+
+// newFileDBItem создает запись журнала для ссылки u.
+func newFileDBItem(id string, u domain.URL) fileDBItem {
+	item := fileDBItem{
+		UUID:         id,
+		UserID:       u.UserID,
+		ShortURL:     u.Short,
+		OriginalURL:  u.Full,
+		Deleted:      u.Deleted,
+		MaxClicks:    u.MaxClicks,
+		Clicks:       u.Clicks,
+		PasswordHash: u.PasswordHash,
+		RedirectType: u.RedirectType,
+	}
+
+	if !u.ExpiresAt.IsZero() {
+		expiresAt := u.ExpiresAt
+		item.ExpiresAt = &expiresAt
+	}
+
+	return item
 }
@@ -5,11 +5,15 @@ import (
 	_context "context"
 	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mikesvis/short/internal/context"
 	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/errors"
 	"github.com/mikesvis/short/internal/logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,27 +32,33 @@ func TestNewStorageURL(t *testing.T) {
 		{
 			name: "New storage is of type",
 			args: args{
-				filePath: "dummyFile.json",
+				filePath: os.TempDir() + "/dummyFile.json",
 			},
 			want: &FileDB{},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			newStorage := NewFileDB(tt.args.filePath, l)
+			newStorage, err := NewFileDB(tt.args.filePath, SyncAlways, 0, l)
+			require.NoError(t, err)
 			assert.IsType(t, tt.want, newStorage)
+			newStorage.Close()
+			os.Remove(tt.args.filePath)
 		})
 	}
 }
 
 func BenchmarkNewStorageURL(b *testing.B) {
 	l, _ := logger.NewLogger()
-	filepath := "dummyFile.json"
+	filepath := os.TempDir() + "/dummyFile.json"
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		NewFileDB(filepath, l)
+		s, _ := NewFileDB(filepath, SyncAlways, 0, l)
+		s.Close()
 	}
+
+	os.Remove(filepath)
 }
 
 func TestStore(t *testing.T) {
@@ -112,9 +122,9 @@ func TestStore(t *testing.T) {
 			if tt.args.mustFail {
 				fileName = os.TempDir() + "/!"
 			}
-			s := &FileDB{
-				fileName: fileName,
-			}
+			s, err := NewFileDB(fileName, SyncAlways, 0, nil)
+			require.NoError(t, err)
+			defer s.Close()
 
 			// Storing
 			result, err := s.Store(ctx, tt.args.item)
@@ -149,9 +159,7 @@ func BenchmarkStore(b *testing.B) {
 	tmpFile, _ := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	tmpFile.Close()
 
-	s := &FileDB{
-		fileName: tmpFile.Name(),
-	}
+	s, _ := NewFileDB(tmpFile.Name(), SyncAlways, 0, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -213,17 +221,17 @@ func TestGetByFull(t *testing.T) {
 			if tt.wantErr {
 				tmpFile = ""
 			}
-			s := &FileDB{
-				fileName: tmpFile,
-			}
-			item, err := s.GetByFull(ctx, tt.args)
+			s, err := NewFileDB(tmpFile, SyncAlways, 0, nil)
 			if tt.wantErr {
 				require.Error(t, err)
 				return
 			}
 			require.NoError(t, err)
+			item, err := s.GetByFull(ctx, tt.args)
+			require.NoError(t, err)
 			assert.IsType(t, tt.want, item)
 			assert.EqualValues(t, tt.want, item)
+			s.Close()
 			os.Remove(tmpFile)
 		})
 	}
@@ -235,9 +243,7 @@ func BenchmarkGetByFull(b *testing.B) {
 	tmpFile, _ := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	tmpFile.Write([]byte(JSONstring))
 	tmpFile.Close()
-	s := &FileDB{
-		fileName: tmpFile.Name(),
-	}
+	s, _ := NewFileDB(tmpFile.Name(), SyncAlways, 0, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -284,17 +290,17 @@ func TestGetByShort(t *testing.T) {
 			if tt.wantErr {
 				tmpFile = ""
 			}
-			s := &FileDB{
-				fileName: tmpFile,
-			}
-			item, err := s.GetByShort(ctx, tt.args)
+			s, err := NewFileDB(tmpFile, SyncAlways, 0, nil)
 			if tt.wantErr {
 				require.Error(t, err)
 				return
 			}
 			require.NoError(t, err)
+			item, err := s.GetByShort(ctx, tt.args)
+			require.NoError(t, err)
 			assert.IsType(t, tt.want, item)
 			assert.EqualValues(t, tt.want, item)
+			s.Close()
 			os.Remove(tmpFile)
 		})
 	}
@@ -306,9 +312,7 @@ func BenchmarkGetByShort(b *testing.B) {
 	tmpFile, _ := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	tmpFile.Write([]byte(JSONstring))
 	tmpFile.Close()
-	s := &FileDB{
-		fileName: tmpFile.Name(),
-	}
+	s, _ := NewFileDB(tmpFile.Name(), SyncAlways, 0, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -390,17 +394,17 @@ func TestStoreBatch(t *testing.T) {
 			}
 
 			// Using temp file in storage
-			s := &FileDB{
-				fileName: fileName,
-			}
-
-			// Storing
-			stored, err := s.StoreBatch(ctx, tt.args)
+			s, err := NewFileDB(fileName, SyncAlways, 0, nil)
 			if tt.wantErr {
 				require.Error(t, err)
 				return
 			}
 			require.NoError(t, err)
+			defer s.Close()
+
+			// Storing
+			stored, err := s.StoreBatch(ctx, tt.args)
+			require.NoError(t, err)
 
 			// Reading temp file
 			file, err := os.OpenFile(s.fileName, os.O_RDONLY, 0666)
@@ -424,9 +428,7 @@ func BenchmarkStoreBatch(b *testing.B) {
 	tmpFile, _ := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	tmpFile.Close()
 
-	s := &FileDB{
-		fileName: tmpFile.Name(),
-	}
+	s, _ := NewFileDB(tmpFile.Name(), SyncAlways, 0, nil)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -447,9 +449,7 @@ func TestGetUserURLs(t *testing.T) {
 	tmpFile, _ := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	tmpFile.Close()
 
-	s := &FileDB{
-		fileName: tmpFile.Name(),
-	}
+	s, _ := NewFileDB(tmpFile.Name(), SyncAlways, 0, nil)
 	uuid.SetRand(rand.New(rand.NewSource(1)))
 	s.Store(ctx, domain.URL{
 		UserID: "DoomGuy",
@@ -497,9 +497,7 @@ func BenchmarkGetUserURLs(b *testing.B) {
 	tmpFile, _ := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	tmpFile.Close()
 
-	s := &FileDB{
-		fileName: tmpFile.Name(),
-	}
+	s, _ := NewFileDB(tmpFile.Name(), SyncAlways, 0, nil)
 	uuid.SetRand(rand.New(rand.NewSource(1)))
 	s.Store(ctx, domain.URL{
 		UserID: "DoomGuy",
@@ -520,9 +518,7 @@ func TestFileDB_Ping(t *testing.T) {
 	tmpFile, _ := os.CreateTemp(os.TempDir(), "dbtest*.json")
 	tmpFile.Close()
 
-	s := &FileDB{
-		fileName: tmpFile.Name(),
-	}
+	s, _ := NewFileDB(tmpFile.Name(), SyncAlways, 0, nil)
 
 	tests := []struct {
 		name    string
@@ -545,51 +541,714 @@ func TestFileDB_Ping(t *testing.T) {
 	os.Remove(tmpFile.Name())
 }
 
-func TestFileDB_GetRandkey(t *testing.T) {
+func TestFileDB_DeleteBatch(t *testing.T) {
+	ctx := _context.Background()
 	l, _ := logger.NewLogger()
-	type want struct {
-		typeOf  string
-		len     int
-		isEmpty bool
-	}
+
 	tests := []struct {
-		name string
-		arg  uint
-		want want
+		name    string
+		userID  string
+		pack    []string
+		want    map[string]bool
+		results map[string]domain.DeleteStatus
 	}{
 		{
-			name: "Rand key is string of length",
-			arg:  5,
-			want: want{
-				typeOf:  "",
-				len:     5,
-				isEmpty: false,
+			name:   "Delete own URLs",
+			userID: "DoomGuy",
+			pack:   []string{"idkfa", "iddqd"},
+			want:   map[string]bool{"idkfa": true, "iddqd": true, "idclip": false},
+			results: map[string]domain.DeleteStatus{
+				"idkfa": domain.DeleteStatusDeleted,
+				"iddqd": domain.DeleteStatusDeleted,
 			},
-		}, {
-			name: "Rand key is empty sting of zero length",
-			arg:  0,
-			want: want{
-				typeOf:  "",
-				len:     0,
-				isEmpty: true,
+		},
+		{
+			name:   "Do not delete URLs of other user",
+			userID: "Heretic",
+			pack:   []string{"idkfa", "idclip"},
+			want:   map[string]bool{"idkfa": false, "iddqd": false, "idclip": true},
+			results: map[string]domain.DeleteStatus{
+				"idkfa":  domain.DeleteStatusNotOwned,
+				"idclip": domain.DeleteStatusDeleted,
+			},
+		},
+		{
+			name:   "Unknown keys are ignored",
+			userID: "DoomGuy",
+			pack:   []string{"dummyShort"},
+			want:   map[string]bool{"idkfa": false, "iddqd": false, "idclip": false},
+			results: map[string]domain.DeleteStatus{
+				"dummyShort": domain.DeleteStatusNotFound,
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &FileDB{
-				fileName: "/tmp/dummyFile.db",
-				logger:   l,
+			tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+			require.Nil(t, err)
+			tmpFile.Close()
+			defer os.Remove(tmpFile.Name())
+
+			s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+			require.NoError(t, err)
+			defer s.Close()
+			s.StoreBatch(ctx, map[string]domain.URL{
+				"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"},
+				"2": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+				"3": {UserID: "Heretic", Full: "http://idclip.com", Short: "idclip"},
+			})
+
+			results, err := s.DeleteBatch(ctx, tt.userID, tt.pack)
+			require.NoError(t, err)
+			assert.Equal(t, tt.results, results)
+
+			for short, deleted := range tt.want {
+				item, err := s.GetByShort(ctx, short)
+				require.NoError(t, err)
+				assert.Equal(t, deleted, item.Deleted, short)
 			}
-			randKey := s.GetRandkey(tt.arg)
-			assert.IsType(t, "", randKey)
-			assert.Len(t, randKey, tt.want.len)
-			if !tt.want.isEmpty {
-				assert.NotEmpty(t, s.GetRandkey(tt.arg))
+
+			// повторное удаление
+			results, err = s.DeleteBatch(ctx, tt.userID, tt.pack)
+			require.NoError(t, err)
+			for short, status := range results {
+				if tt.results[short] == domain.DeleteStatusDeleted {
+					assert.Equal(t, domain.DeleteStatusAlreadyDeleted, status, short)
+				}
+			}
+		})
+	}
+}
+
+func TestFileDB_ReloadAppliesLaterRecords(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"})
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"})
+	s.DeleteBatch(ctx, "DoomGuy", []string{"idkfa"})
+	require.NoError(t, s.Close())
+
+	// в журнале две записи о сохранении и одна об удалении
+	content, err := os.ReadFile(tmpFile.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(content), "\n"))
+
+	reloaded, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	item, err := reloaded.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.True(t, item.Deleted)
+
+	urls, err := reloaded.GetUserURLs(ctx, "DoomGuy")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func TestFileDB_ReadsDoNotTouchDisk(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"})
+
+	// файла больше нет, но поиск работает по индексу
+	require.NoError(t, os.Remove(tmpFile.Name()))
+
+	item, err := s.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.Equal(t, "http://idkfa.com", item.Full)
+}
+
+func TestFileDB_Compact(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"})
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"})
+	s.DeleteBatch(ctx, "DoomGuy", []string{"idkfa", "iddqd"})
+
+	require.NoError(t, s.Compact())
+
+	// после компактизации в журнале только актуальные состояния, дозапись продолжает работать
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idclip.com", Short: "idclip"})
+	require.NoError(t, s.Close())
+
+	content, err := os.ReadFile(tmpFile.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(content), "\n"))
+
+	reloaded, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	for short, deleted := range map[string]bool{"idkfa": true, "iddqd": true, "idclip": false} {
+		item, err := reloaded.GetByShort(ctx, short)
+		require.NoError(t, err)
+		assert.Equal(t, deleted, item.Deleted, short)
+	}
+}
+
+func TestNewFileDB_RecoversTornRecord(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	const complete = `{"uuid":"1","user_id":"DoomGuy","short_url":"idkfa","original_url":"http://idkfa.com","is_deleted":false}` + "\n"
+
+	tests := []struct {
+		name      string
+		content   string
+		wantErr   bool
+		wantItems []string
+		wantFile  string
+	}{
+		{
+			name:      "Torn last record is truncated",
+			content:   complete + `{"uuid":"2","user_id":"DoomGuy","short_u`,
+			wantItems: []string{"idkfa"},
+			wantFile:  complete,
+		},
+		{
+			name:      "Complete last record without newline is kept",
+			content:   complete + `{"uuid":"2","user_id":"DoomGuy","short_url":"iddqd","original_url":"http://iddqd.com"}`,
+			wantItems: []string{"idkfa", "iddqd"},
+			wantFile:  complete + `{"uuid":"2","user_id":"DoomGuy","short_url":"iddqd","original_url":"http://iddqd.com"}` + "\n",
+		},
+		{
+			name:    "Corrupted record in the middle is an error",
+			content: `{"uuid":"2","user_id":"DoomGuy","short_u` + "\n" + complete,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+			require.Nil(t, err)
+			tmpFile.Write([]byte(tt.content))
+			tmpFile.Close()
+			defer os.Remove(tmpFile.Name())
+			defer os.Remove(tmpFile.Name() + ".lock")
+
+			s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer s.Close()
+
+			for _, short := range tt.wantItems {
+				item, err := s.GetByShort(ctx, short)
+				require.NoError(t, err)
+				assert.Equal(t, short, item.Short)
+			}
+
+			content, err := os.ReadFile(tmpFile.Name())
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFile, string(content))
+		})
+	}
+}
+
+func TestNewFileDB_Lock(t *testing.T) {
+	l, _ := logger.NewLogger()
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	defer os.Remove(tmpFile.Name() + ".lock")
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+
+	// второй экземпляр на тот же файл не стартует, пока первый не закрыт
+	_, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.ErrorIs(t, err, errors.ErrFileLocked)
+
+	require.NoError(t, s.Close())
+
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+}
+
+func TestNewFileDB_SyncPolicy(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tests := []struct {
+		name     string
+		policy   SyncPolicy
+		interval time.Duration
+		wantErr  bool
+	}{
+		{name: "Always", policy: SyncAlways},
+		{name: "Interval", policy: SyncInterval, interval: time.Millisecond},
+		{name: "Never", policy: SyncNever},
+		{name: "Empty policy is always", policy: ""},
+		{name: "Interval without period", policy: SyncInterval, wantErr: true},
+		{name: "Unknown policy", policy: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+			require.Nil(t, err)
+			tmpFile.Close()
+			defer os.Remove(tmpFile.Name())
+			defer os.Remove(tmpFile.Name() + ".lock")
+
+			s, err := NewFileDB(tmpFile.Name(), tt.policy, tt.interval, l)
+			if tt.wantErr {
+				require.Error(t, err)
 				return
 			}
+			require.NoError(t, err)
 
-			assert.Empty(t, s.GetRandkey(tt.arg))
+			_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"})
+			require.NoError(t, err)
+			time.Sleep(5 * time.Millisecond)
+			require.NoError(t, s.Close())
+
+			content, err := os.ReadFile(tmpFile.Name())
+			require.NoError(t, err)
+			assert.Contains(t, string(content), `"short_url":"idkfa"`)
 		})
 	}
 }
+
+func TestFileDB_ShortKeyConflict(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"})
+	require.NoError(t, err)
+
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://iddqd.com", Short: "idkfa"})
+	assert.ErrorIs(t, err, errors.ErrShortKeyConflict)
+
+	_, err = s.StoreBatch(ctx, map[string]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+		"2": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "idkfa"},
+	})
+	assert.ErrorIs(t, err, errors.ErrShortKeyConflict)
+
+	_, err = s.StoreBatch(ctx, map[string]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+		"2": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "iddqd"},
+	})
+	assert.ErrorIs(t, err, errors.ErrShortKeyConflict)
+
+	// неудачный пакет ничего не сохраняет
+	item, err := s.GetByShort(ctx, "iddqd")
+	require.NoError(t, err)
+	assert.Empty(t, item)
+
+	got, err := s.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.Equal(t, "http://idkfa.com", got.Full)
+}
+
+func TestFileDB_Expiration(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+	now := time.Now()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+
+	_, err = s.StoreBatch(ctx, map[string]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa", ExpiresAt: now.Add(-time.Hour)},
+		"2": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd", ExpiresAt: now.Add(-48 * time.Hour)},
+		"3": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "idclp", ExpiresAt: now.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// время истечения переживает перезапуск
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	item, err := s.GetByShort(ctx, "idclp")
+	require.NoError(t, err)
+	assert.True(t, item.ExpiresAt.Equal(now.Add(time.Hour)))
+
+	// истекшая ссылка заменяется новой вместо конфликта
+	stored, err := s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkf2"})
+	require.NoError(t, err)
+	assert.Equal(t, "idkf2", stored.Short)
+
+	item, err = s.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.Empty(t, item)
+
+	deleted, err := s.DeleteExpired(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	item, err = s.GetByShort(ctx, "iddqd")
+	require.NoError(t, err)
+	assert.Empty(t, item)
+
+	item, err = s.GetByFull(ctx, "http://idclip.com")
+	require.NoError(t, err)
+	assert.Equal(t, "idclp", item.Short)
+}
+
+func TestFileDB_Click(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := NewFileDB(tmpFile.Name(), SyncNever, 0, l)
+	require.NoError(t, err)
+
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa", MaxClicks: 3})
+	require.NoError(t, err)
+
+	// конкурентные переходы не превышают лимит
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	clicks := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Click(ctx, "idkfa")
+			if err == nil {
+				mu.Lock()
+				clicks++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, errors.ErrClickLimitReached)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, clicks)
+	require.NoError(t, s.Close())
+
+	// счетчик переходов переживает перезапуск
+	s, err = NewFileDB(tmpFile.Name(), SyncNever, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.Click(ctx, "idkfa")
+	assert.ErrorIs(t, err, errors.ErrClickLimitReached)
+
+	item, err := s.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.Equal(t, 3, item.Clicks)
+}
+
+func TestFileDB_LinkOptions(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa", PasswordHash: "$2a$10$hash", RedirectType: 301})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	item, err := s.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.True(t, item.Protected())
+	assert.Equal(t, "$2a$10$hash", item.PasswordHash)
+	assert.Equal(t, 301, item.RedirectType)
+}
+
+func TestFileDB_Count(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.StoreBatch(ctx, map[string]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"},
+		"2": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+		"3": {UserID: "Cacodemon", Full: "http://idclip.com", Short: "idclp"},
+		"4": {UserID: "Imp", Full: "http://idspispopd.com", Short: "idspi"},
+	})
+	require.NoError(t, err)
+	s.DeleteBatch(ctx, "Imp", []string{"idspi"})
+
+	urls, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, urls)
+
+	users, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users)
+}
+
+func TestFileDB_APIKeys(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	defer os.Remove(tmpFile.Name() + ".apikeys")
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ci := domain.APIKey{ID: "1", UserID: "DoomGuy", Name: "ci", Hash: "hash1", Scopes: []domain.APIKeyScope{domain.APIKeyScopeCreate}, CreatedAt: now}
+	bot := domain.APIKey{ID: "2", UserID: "DoomGuy", Name: "bot", Hash: "hash2", CreatedAt: now.Add(time.Second)}
+	imp := domain.APIKey{ID: "3", UserID: "Imp", Name: "ci", Hash: "hash3", CreatedAt: now}
+	for _, key := range []domain.APIKey{bot, ci, imp} {
+		require.NoError(t, s.StoreAPIKey(ctx, key))
+	}
+
+	revoked, err := s.RevokeAPIKey(ctx, "DoomGuy", "3")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	revoked, err = s.RevokeAPIKey(ctx, "Imp", "3")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	require.NoError(t, s.Close())
+
+	// ключи переживают перезапуск, отозванный ключ не возвращается
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	key, err := s.GetAPIKeyByHash(ctx, "hash1")
+	require.NoError(t, err)
+	assert.Equal(t, ci, key)
+
+	key, err = s.GetAPIKeyByHash(ctx, "hash3")
+	require.NoError(t, err)
+	assert.Empty(t, key)
+
+	keys, err := s.GetUserAPIKeys(ctx, "DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, []domain.APIKey{ci, bot}, keys)
+
+	// журнал ссылок ключами не затрагивается
+	data, err := os.ReadFile(tmpFile.Name())
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestFileDB_Accounts(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	defer os.Remove(tmpFile.Name() + ".accounts")
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+
+	_, err = s.StoreBatch(ctx, map[string]domain.URL{
+		"1": {UserID: "Anonymous", Full: "http://idkfa.com", Short: "idkfa"},
+		"2": {UserID: "Anonymous", Full: "http://iddqd.com", Short: "iddqd"},
+		"3": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "idclp"},
+	})
+	require.NoError(t, err)
+
+	doomGuy := domain.Account{ID: "DoomGuy", Login: "doomguy", PasswordHash: "hash", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	require.NoError(t, s.StoreAccount(ctx, doomGuy))
+	assert.ErrorIs(t, s.StoreAccount(ctx, domain.Account{ID: "Imp", Login: "doomguy"}), errors.ErrLoginTaken)
+	assert.ErrorIs(t, s.StoreAccount(ctx, domain.Account{ID: "DoomGuy", Login: "other"}), errors.ErrConflict)
+
+	merged, err := s.MergeUserURLs(ctx, "Anonymous", "DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, 2, merged)
+
+	merged, err = s.MergeUserURLs(ctx, "Nobody", "DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, 0, merged)
+	require.NoError(t, s.Close())
+
+	// аккаунты и перенесенные ссылки переживают перезапуск
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	a, err := s.GetAccountByLogin(ctx, "doomguy")
+	require.NoError(t, err)
+	assert.Equal(t, doomGuy, a)
+
+	a, err = s.GetAccount(ctx, "DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, doomGuy, a)
+
+	urls, err := s.GetUserURLs(ctx, "DoomGuy")
+	require.NoError(t, err)
+	assert.Len(t, urls, 3)
+
+	urls, err = s.GetUserURLs(ctx, "Anonymous")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func TestFileDB_RevokeToken(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	defer os.Remove(tmpFile.Name() + ".revoked")
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+
+	revoked, err := s.RevokeToken(ctx, "expired", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = s.RevokeToken(ctx, "jti", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = s.RevokeToken(ctx, "jti", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+	require.NoError(t, s.Close())
+
+	// отозванные токены переживают перезапуск
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	isRevoked, err := s.IsTokenRevoked(ctx, "jti")
+	require.NoError(t, err)
+	assert.True(t, isRevoked)
+
+	isRevoked, err = s.IsTokenRevoked(ctx, "expired")
+	require.NoError(t, err)
+	assert.False(t, isRevoked)
+}
+
+func TestFileDB_ReserveSequence(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	defer os.Remove(tmpFile.Name() + ".sequence")
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru", Short: "short"})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// без файла счетчика номера продолжаются с количества сохраненных ссылок
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	value, err := s.ReserveSequence(ctx, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1001), value)
+	require.NoError(t, s.Close())
+
+	// счетчик переживает перезапуск и не зависит от удаления ссылок
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.DeleteBatch(ctx, "DoomGuy", []string{"short"})
+	require.NoError(t, err)
+
+	value, err = s.ReserveSequence(ctx, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2001), value)
+}
+
+func TestFileDB_DeleteJobs(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+
+	tmpFile, err := os.CreateTemp(os.TempDir(), "dbtest*.json")
+	require.Nil(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	defer os.Remove(tmpFile.Name() + ".deletions")
+
+	s, err := NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	first := domain.DeleteJob{ID: "first", UserID: "DoomGuy", Shorts: []string{"iddqd", "idkfa"}, CreatedAt: now}
+	second := domain.DeleteJob{ID: "second", UserID: "Commander", Shorts: []string{"idclip"}, CreatedAt: now.Add(time.Second)}
+	require.NoError(t, s.StoreDeleteJob(ctx, first))
+	require.NoError(t, s.StoreDeleteJob(ctx, second))
+	require.NoError(t, s.RemoveDeleteJob(ctx, "first"))
+	require.NoError(t, s.RemoveDeleteJob(ctx, "missing"))
+	require.NoError(t, s.Close())
+
+	// невыполненные задания переживают перезапуск
+	s, err = NewFileDB(tmpFile.Name(), SyncAlways, 0, l)
+	require.NoError(t, err)
+	defer s.Close()
+
+	jobs, err := s.GetDeleteJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.DeleteJob{second}, jobs)
+}
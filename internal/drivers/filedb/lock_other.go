@@ -0,0 +1,15 @@
+//go:build !unix
+
+package filedb
+
+import "os"
+
+// lockFile на платформах без flock ничего не делает.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile на платформах без flock ничего не делает.
+func unlockFile(file *os.File) error {
+	return nil
+}
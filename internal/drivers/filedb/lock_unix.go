@@ -0,0 +1,27 @@
+//go:build unix
+
+package filedb
+
+import (
+	_goerrors "errors"
+	"os"
+	"syscall"
+
+	"github.com/mikesvis/short/internal/errors"
+)
+
+// lockFile берет эксклюзивную блокировку на файл без ожидания.
+// Если файл уже заблокирован другим процессом, вернется errors.ErrFileLocked.
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if _goerrors.Is(err, syscall.EWOULDBLOCK) {
+		return errors.ErrFileLocked
+	}
+
+	return err
+}
+
+// unlockFile снимает блокировку с файла.
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
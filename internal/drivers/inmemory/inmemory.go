@@ -3,122 +3,468 @@ package inmemory
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mikesvis/short/internal/domain"
 	"github.com/mikesvis/short/internal/errors"
-	"github.com/mikesvis/short/internal/keygen"
+	"github.com/mikesvis/short/internal/metrics"
 	"go.uber.org/zap"
 )
 
-// Storage для хранения в памяти, включает в себя мапу с элементами ссылок и логгер.
+// Имя драйвера в метриках storage.
+const driverName = "inmemory"
+
+// Storage для хранения в памяти, включает в себя мапу с элементами ссылок, индексы по короткому ключу,
+// полному URL и ID пользователя, API ключи по хешу, аккаунты по ID пользователя с индексом по логину,
+// отозванные токены со временем их истечения, счетчик последовательных ключей, а также логгер. Доступ к данным защищен RWMutex.
 type InMemory struct {
-	items  map[domain.ID]domain.URL
-	logger *zap.SugaredLogger
+	mu       sync.RWMutex
+	items    map[domain.ID]domain.URL
+	shorts   map[string]domain.ID
+	fulls    map[string]domain.ID
+	users    map[string][]domain.ID
+	apiKeys  map[string]domain.APIKey
+	accounts map[string]domain.Account
+	logins   map[string]string
+	revoked  map[string]time.Time
+	sequence uint64
+	logger   *zap.SugaredLogger
 }
 
 // Конструктор storage в памяти.
 func NewInMemory(logger *zap.SugaredLogger) *InMemory {
-	items := make(map[domain.ID]domain.URL)
-	return &InMemory{items, logger}
+	return &InMemory{
+		items:    make(map[domain.ID]domain.URL),
+		shorts:   make(map[string]domain.ID),
+		fulls:    make(map[string]domain.ID),
+		users:    make(map[string][]domain.ID),
+		apiKeys:  make(map[string]domain.APIKey),
+		accounts: make(map[string]domain.Account),
+		logins:   make(map[string]string),
+		revoked:  make(map[string]time.Time),
+		logger:   logger,
+	}
 }
 
 // Сохранение короткой ссылки. При сохранении происходит поиск на предмет уже существующей ссылки.
-// В случае если такая ссылка уже была ранее создана вернется ошибка.
+// В случае если такая ссылка уже была ранее создана вернется ошибка ErrConflict,
+// если занят короткий ключ - ErrShortKeyConflict. Истекшая ссылка с тем же полным URL заменяется новой.
 func (s *InMemory) Store(ctx context.Context, u domain.URL) (domain.URL, error) {
-	for _, v := range s.items {
-		if v.Full == u.Full {
-			return v, errors.ErrConflict
-		}
+	defer metrics.ObserveStorage(driverName, "Store", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpiredFull(u.Full, time.Now())
+
+	if id, exists := s.fulls[u.Full]; exists {
+		return s.items[id], errors.ErrConflict
+	}
+
+	if _, exists := s.shorts[u.Short]; exists {
+		return domain.URL{}, errors.ErrShortKeyConflict
 	}
-	s.items[domain.ID(uuid.NewString())] = u
+
+	s.put(domain.ID(uuid.NewString()), u)
 	return u, nil
 }
 
 // Поиск по полной ссылке.
 func (s *InMemory) GetByFull(ctx context.Context, fullURL string) (domain.URL, error) {
-	for _, v := range s.items {
-		if string(v.Full) != fullURL {
-			continue
-		}
+	defer metrics.ObserveStorage(driverName, "GetByFull", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-		return v, nil
+	id, exists := s.fulls[fullURL]
+	if !exists {
+		return domain.URL{}, nil
 	}
 
-	return domain.URL{}, nil
+	return s.items[id], nil
 }
 
 // Поиск по короткой ссылке.
 func (s *InMemory) GetByShort(ctx context.Context, shortURL string) (domain.URL, error) {
-	for _, v := range s.items {
-		if string(v.Short) != shortURL {
-			continue
-		}
+	defer metrics.ObserveStorage(driverName, "GetByShort", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-		return v, nil
+	id, exists := s.shorts[shortURL]
+	if !exists {
+		return domain.URL{}, nil
 	}
 
-	return domain.URL{}, nil
+	return s.items[id], nil
 }
 
 // Пакетное сохранение коротких URL. В методе используется поиск уже существующих URL.
+// Если хотя бы один новый короткий ключ занят, ничего не сохраняется и возвращается ErrShortKeyConflict.
 func (s *InMemory) StoreBatch(ctx context.Context, us map[string]domain.URL) (map[string]domain.URL, error) {
-	// в мапе хранится полный урл = ключ корреляции
-	wantToStore := make(map[string]string, len(us))
+	defer metrics.ObserveStorage(driverName, "StoreBatch", time.Now())
 
-	for k, v := range us {
-		wantToStore[string(v.Full)] = k
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, v := range us {
+		s.removeExpiredFull(v.Full, now)
+	}
+
+	if s.hasShortConflict(us) {
+		return nil, errors.ErrShortKeyConflict
 	}
 
-	// для начала найдем совпадения по урлу, которые были сохранены ранее
-	for _, v := range s.items {
-		k, exists := wantToStore[v.Full]
+	for k, v := range us {
+		id, exists := s.fulls[v.Full]
 		if exists {
-			// урл был сохранен ранее: удаляем из списка на сохранение и
-			// восстанавливаем его старый short вместо нового
-			delete(wantToStore, v.Full)
-			us[k] = domain.URL{
-				UserID:  v.UserID,
-				Full:    v.Full,
-				Short:   v.Short,
-				Deleted: v.Deleted,
+			// урл был сохранен ранее: восстанавливаем его старый short вместо нового
+			us[k] = s.items[id]
+			continue
+		}
+
+		s.put(domain.ID(uuid.NewString()), v)
+	}
+
+	return us, nil
+}
+
+// Получение ссылок, созданных пользоваетелем.
+func (s *InMemory) GetUserURLs(ctx context.Context, userID string) ([]domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "GetUserURLs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.users[userID]
+	result := make([]domain.URL, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, s.items[id])
+	}
+
+	return result, nil
+}
+
+// Пакетное удаление коротких ссылок. Удаляются только ссылки, принадлежащие пользователю userID,
+// у них выставляется флаг Deleted. Возвращает результат удаления по каждому ключу.
+func (s *InMemory) DeleteBatch(ctx context.Context, userID string, pack []string) (map[string]domain.DeleteStatus, error) {
+	defer metrics.ObserveStorage(driverName, "DeleteBatch", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]domain.DeleteStatus, len(pack))
+	for _, short := range pack {
+		if _, seen := result[short]; seen {
+			continue
+		}
+
+		var item domain.URL
+		if id, exists := s.shorts[short]; exists {
+			item = s.items[id]
+		}
+
+		result[short] = domain.DeleteStatusOf(item, userID)
+		if result[short] != domain.DeleteStatusDeleted {
+			continue
+		}
+
+		item.Deleted = true
+		s.items[s.shorts[short]] = item
+	}
+
+	return result, nil
+}
+
+// Учет перехода по короткой ссылке. Счетчик увеличивается под блокировкой на запись, поэтому
+// конкурентные переходы не превышают лимит. Если лимит исчерпан, возвращается ErrClickLimitReached.
+func (s *InMemory) Click(ctx context.Context, shortURL string) (domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "Click", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, exists := s.shorts[shortURL]
+	if !exists {
+		return domain.URL{}, nil
+	}
+
+	item := s.items[id]
+	if item.ClicksExhausted() {
+		return item, errors.ErrClickLimitReached
+	}
+
+	item.Clicks++
+	s.items[id] = item
+
+	return item, nil
+}
+
+// Удаление ссылок, истекших раньше before.
+func (s *InMemory) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	defer metrics.ObserveStorage(driverName, "DeleteExpired", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for id, item := range s.items {
+		if item.Expired(before) {
+			s.remove(id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Количество неудаленных ссылок.
+func (s *InMemory) CountURLs(ctx context.Context) (int, error) {
+	defer metrics.ObserveStorage(driverName, "CountURLs", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, item := range s.items {
+		if !item.Deleted {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Количество пользователей, у которых есть неудаленные ссылки.
+func (s *InMemory) CountUsers(ctx context.Context) (int, error) {
+	defer metrics.ObserveStorage(driverName, "CountUsers", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, ids := range s.users {
+		for _, id := range ids {
+			if !s.items[id].Deleted {
+				count++
+				break
 			}
 		}
+	}
 
-		// список на сохранение пустой, не смысла искать далее (все элементы уже есть в хранилке)
-		if len(wantToStore) == 0 {
-			break
+	return count, nil
+}
+
+// Сохранение API ключа.
+func (s *InMemory) StoreAPIKey(ctx context.Context, key domain.APIKey) error {
+	defer metrics.ObserveStorage(driverName, "StoreAPIKey", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apiKeys[key.Hash] = key
+
+	return nil
+}
+
+// Получение API ключа по хешу.
+func (s *InMemory) GetAPIKeyByHash(ctx context.Context, hash string) (domain.APIKey, error) {
+	defer metrics.ObserveStorage(driverName, "GetAPIKeyByHash", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.apiKeys[hash], nil
+}
+
+// Получение API ключей пользователя в порядке создания.
+func (s *InMemory) GetUserAPIKeys(ctx context.Context, userID string) ([]domain.APIKey, error) {
+	defer metrics.ObserveStorage(driverName, "GetUserAPIKeys", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return domain.UserAPIKeys(s.apiKeys, userID), nil
+}
+
+// Отзыв API ключа id пользователя userID, ключ удаляется.
+func (s *InMemory) RevokeAPIKey(ctx context.Context, userID string, id string) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "RevokeAPIKey", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, key := range s.apiKeys {
+		if key.ID == id && key.UserID == userID {
+			delete(s.apiKeys, hash)
+			return true, nil
 		}
 	}
 
-	// все элементы на сохранение уже есть, нечего сохранять
-	if len(wantToStore) == 0 {
-		return us, nil
+	return false, nil
+}
+
+// Сохранение аккаунта. Если логин занят, возвращается ErrLoginTaken, если у пользователя уже есть аккаунт - ErrConflict.
+func (s *InMemory) StoreAccount(ctx context.Context, a domain.Account) error {
+	defer metrics.ObserveStorage(driverName, "StoreAccount", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.logins[a.Login]; exists {
+		return errors.ErrLoginTaken
 	}
 
-	// будем сохранять только те елементы, которых нет
-	for _, v := range wantToStore {
-		s.items[domain.ID(uuid.NewString())] = us[v]
+	if _, exists := s.accounts[a.ID]; exists {
+		return errors.ErrConflict
 	}
 
-	return us, nil
+	s.accounts[a.ID] = a
+	s.logins[a.Login] = a.ID
+
+	return nil
 }
 
-// Получение ссылок, созданных пользоваетелем.
-func (s *InMemory) GetUserURLs(ctx context.Context, userID string) ([]domain.URL, error) {
-	result := make([]domain.URL, 0, 20)
-	for _, v := range s.items {
-		if v.UserID != userID {
+// Получение аккаунта по ID пользователя.
+func (s *InMemory) GetAccount(ctx context.Context, userID string) (domain.Account, error) {
+	defer metrics.ObserveStorage(driverName, "GetAccount", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.accounts[userID], nil
+}
+
+// Получение аккаунта по логину.
+func (s *InMemory) GetAccountByLogin(ctx context.Context, login string) (domain.Account, error) {
+	defer metrics.ObserveStorage(driverName, "GetAccountByLogin", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, exists := s.logins[login]
+	if !exists {
+		return domain.Account{}, nil
+	}
+
+	return s.accounts[id], nil
+}
+
+// Перенос ссылок пользователя fromUserID пользователю toUserID.
+func (s *InMemory) MergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	defer metrics.ObserveStorage(driverName, "MergeUserURLs", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.users[fromUserID]
+	for _, id := range ids {
+		item := s.items[id]
+		item.UserID = toUserID
+		s.items[id] = item
+	}
+
+	s.users[toUserID] = append(s.users[toUserID], ids...)
+	delete(s.users, fromUserID)
+
+	return len(ids), nil
+}
+
+// Отзыв токена jti до expiresAt. Записи об отзыве истекших токенов удаляются при каждом отзыве.
+func (s *InMemory) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "RevokeToken", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range s.revoked {
+		if v.Before(now) {
+			delete(s.revoked, k)
+		}
+	}
+
+	if _, exists := s.revoked[jti]; exists {
+		return false, nil
+	}
+	s.revoked[jti] = expiresAt
+
+	return true, nil
+}
+
+// Проверка, что токен jti отозван.
+func (s *InMemory) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "IsTokenRevoked", time.Now())
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, exists := s.revoked[jti]
+	return exists, nil
+}
+
+// Резервирование size номеров счетчика последовательных ключей.
+func (s *InMemory) ReserveSequence(ctx context.Context, size uint64) (uint64, error) {
+	defer metrics.ObserveStorage(driverName, "ReserveSequence", time.Now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequence += size
+	return s.sequence, nil
+}
+
+// removeExpiredFull удаляет истекшую ссылку с полным URL full, чтобы его можно было сократить заново.
+func (s *InMemory) removeExpiredFull(full string, now time.Time) {
+	if id, exists := s.fulls[full]; exists && s.items[id].Expired(now) {
+		s.remove(id)
+	}
+}
+
+// hasShortConflict проверяет, что короткие ключи новых ссылок пакета не заняты и не повторяются внутри пакета.
+func (s *InMemory) hasShortConflict(us map[string]domain.URL) bool {
+	seen := make(map[string]struct{}, len(us))
+	for _, v := range us {
+		if _, exists := s.fulls[v.Full]; exists {
 			continue
 		}
 
-		result = append(result, v)
+		if _, exists := s.shorts[v.Short]; exists {
+			return true
+		}
+
+		if _, exists := seen[v.Short]; exists {
+			return true
+		}
+		seen[v.Short] = struct{}{}
 	}
 
-	return result, nil
+	return false
+}
+
+// remove удаляет элемент вместе с индексами, вызывается под блокировкой на запись.
+func (s *InMemory) remove(id domain.ID) {
+	item := s.items[id]
+	delete(s.items, id)
+	delete(s.shorts, item.Short)
+	delete(s.fulls, item.Full)
+
+	ids := s.users[item.UserID]
+	for k, v := range ids {
+		if v == id {
+			s.users[item.UserID] = append(ids[:k:k], ids[k+1:]...)
+			break
+		}
+	}
 }
 
-// Получение рандомного ключа
-func (s *InMemory) GetRandkey(n uint) string {
-	return keygen.GetRandkey(n)
+// put сохраняет элемент и обновляет индексы, вызывается под блокировкой на запись.
+func (s *InMemory) put(id domain.ID, u domain.URL) {
+	s.items[id] = u
+	s.shorts[u.Short] = id
+	s.fulls[u.Full] = id
+	s.users[u.UserID] = append(s.users[u.UserID], id)
 }
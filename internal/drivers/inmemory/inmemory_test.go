@@ -2,18 +2,32 @@ package inmemory
 
 import (
 	_context "context"
+	"fmt"
 	"math/rand"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/mikesvis/short/internal/context"
 	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/errors"
 	"github.com/mikesvis/short/internal/logger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// newTestInMemory создает storage в памяти и наполняет его элементами вместе с индексами.
+func newTestInMemory(items map[domain.ID]domain.URL) *InMemory {
+	s := NewInMemory(nil)
+	for id, u := range items {
+		s.put(id, u)
+	}
+
+	return s
+}
+
 func TestNewStorageURL(t *testing.T) {
 	l, _ := logger.NewLogger()
 	type args struct {
@@ -112,9 +126,7 @@ func TestStore(t *testing.T) {
 	uuid.SetRand(rand.New(rand.NewSource(1)))
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &InMemory{
-				items: tt.fields.items,
-			}
+			s := newTestInMemory(tt.fields.items)
 			_, err := s.Store(ctx, tt.args)
 			if tt.want.wantErr {
 				require.Error(t, err)
@@ -187,9 +199,7 @@ func TestGetByFull(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &InMemory{
-				items: tt.fields.items,
-			}
+			s := newTestInMemory(tt.fields.items)
 			item, _ := s.GetByFull(ctx, tt.args)
 			assert.IsType(t, tt.want, item)
 			assert.EqualValues(t, tt.want, item)
@@ -260,9 +270,7 @@ func TestGetByShort(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &InMemory{
-				items: tt.fields.items,
-			}
+			s := newTestInMemory(tt.fields.items)
 			item, _ := s.GetByShort(ctx, tt.args)
 			assert.IsType(t, tt.want, item)
 			assert.EqualValues(t, tt.want, item)
@@ -345,9 +353,7 @@ func TestStoreBatch(t *testing.T) {
 	uuid.SetRand(rand.New(rand.NewSource(1)))
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &InMemory{
-				items: tt.fields.items,
-			}
+			s := newTestInMemory(tt.fields.items)
 			stored, err := s.StoreBatch(ctx, tt.args)
 			assert.NoError(t, err)
 			assert.EqualValues(t, tt.want, stored)
@@ -424,9 +430,7 @@ func TestGetUserURLs(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &InMemory{
-				items: tt.fields.items,
-			}
+			s := newTestInMemory(tt.fields.items)
 			got, err := s.GetUserURLs(ctx, tt.args)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("InMemory.GetUserURLs() error = %v, wantErr %v", err, tt.wantErr)
@@ -441,15 +445,13 @@ func TestGetUserURLs(t *testing.T) {
 
 func BenchmarkGetUserURLs(b *testing.B) {
 	ctx := _context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy")
-	s := &InMemory{
-		items: map[domain.ID]domain.URL{
-			"1": {
-				UserID: "DoomGuy",
-				Full:   "http://iddqd.com",
-				Short:  "idkfa",
-			},
+	s := newTestInMemory(map[domain.ID]domain.URL{
+		"1": {
+			UserID: "DoomGuy",
+			Full:   "http://iddqd.com",
+			Short:  "idkfa",
 		},
-	}
+	})
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -457,51 +459,385 @@ func BenchmarkGetUserURLs(b *testing.B) {
 	}
 }
 
-func TestInMemory_GetRandkey(t *testing.T) {
+// Стресс-тест конкурентного доступа, имеет смысл запускать с флагом -race.
+func TestInMemory_ConcurrentAccess(t *testing.T) {
+	ctx := _context.Background()
 	l, _ := logger.NewLogger()
-	type want struct {
-		typeOf  string
-		len     int
-		isEmpty bool
+	s := NewInMemory(l)
+
+	const workers = 16
+	const perWorker = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("user%d", w)
+			for i := 0; i < perWorker; i++ {
+				u := domain.URL{
+					UserID: userID,
+					Full:   fmt.Sprintf("http://iddqd.com/%d/%d", w, i),
+					Short:  fmt.Sprintf("%d-%d", w, i),
+				}
+				_, err := s.Store(ctx, u)
+				assert.NoError(t, err)
+
+				s.StoreBatch(ctx, map[string]domain.URL{"1": u})
+				s.GetByShort(ctx, u.Short)
+				s.GetByFull(ctx, u.Full)
+				s.GetUserURLs(ctx, userID)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		urls, err := s.GetUserURLs(ctx, fmt.Sprintf("user%d", w))
+		require.NoError(t, err)
+		assert.Len(t, urls, perWorker)
+	}
+
+	item, err := s.GetByShort(ctx, "3-42")
+	require.NoError(t, err)
+	assert.Equal(t, "http://iddqd.com/3/42", item.Full)
+}
+
+func BenchmarkGetByShortParallel(b *testing.B) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+	s := NewInMemory(l)
+	for i := 0; i < 10000; i++ {
+		s.Store(ctx, domain.URL{
+			Full:  fmt.Sprintf("http://www.yandex.ru/verylongpath/%d", i),
+			Short: fmt.Sprintf("short%d", i),
+		})
 	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.GetByShort(ctx, "short5000")
+		}
+	})
+}
+
+func TestInMemory_DeleteBatch(t *testing.T) {
+	ctx := _context.Background()
+
 	tests := []struct {
-		name string
-		arg  uint
-		want want
+		name    string
+		userID  string
+		pack    []string
+		want    map[string]bool
+		results map[string]domain.DeleteStatus
 	}{
 		{
-			name: "Rand key is string of length",
-			arg:  5,
-			want: want{
-				typeOf:  "",
-				len:     5,
-				isEmpty: false,
+			name:   "Delete own URLs",
+			userID: "DoomGuy",
+			pack:   []string{"idkfa", "iddqd"},
+			want:   map[string]bool{"idkfa": true, "iddqd": true, "idclip": false},
+			results: map[string]domain.DeleteStatus{
+				"idkfa": domain.DeleteStatusDeleted,
+				"iddqd": domain.DeleteStatusDeleted,
 			},
-		}, {
-			name: "Rand key is empty sting of zero length",
-			arg:  0,
-			want: want{
-				typeOf:  "",
-				len:     0,
-				isEmpty: true,
+		},
+		{
+			name:   "Do not delete URLs of other user",
+			userID: "Heretic",
+			pack:   []string{"idkfa", "idclip"},
+			want:   map[string]bool{"idkfa": false, "iddqd": false, "idclip": true},
+			results: map[string]domain.DeleteStatus{
+				"idkfa":  domain.DeleteStatusNotOwned,
+				"idclip": domain.DeleteStatusDeleted,
+			},
+		},
+		{
+			name:   "Unknown keys are ignored",
+			userID: "DoomGuy",
+			pack:   []string{"dummyShort"},
+			want:   map[string]bool{"idkfa": false, "iddqd": false, "idclip": false},
+			results: map[string]domain.DeleteStatus{
+				"dummyShort": domain.DeleteStatusNotFound,
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &InMemory{
-				items:  make(map[domain.ID]domain.URL),
-				logger: l,
+			s := newTestInMemory(map[domain.ID]domain.URL{
+				"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"},
+				"2": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+				"3": {UserID: "Heretic", Full: "http://idclip.com", Short: "idclip"},
+			})
+
+			results, err := s.DeleteBatch(ctx, tt.userID, tt.pack)
+			require.NoError(t, err)
+			assert.Equal(t, tt.results, results)
+
+			for short, deleted := range tt.want {
+				item, err := s.GetByShort(ctx, short)
+				require.NoError(t, err)
+				assert.Equal(t, deleted, item.Deleted, short)
+			}
+
+			// повторное удаление
+			results, err = s.DeleteBatch(ctx, tt.userID, tt.pack)
+			require.NoError(t, err)
+			for short, status := range results {
+				if tt.results[short] == domain.DeleteStatusDeleted {
+					assert.Equal(t, domain.DeleteStatusAlreadyDeleted, status, short)
+				}
 			}
-			randKey := s.GetRandkey(tt.arg)
-			assert.IsType(t, "", randKey)
-			assert.Len(t, randKey, tt.want.len)
-			if !tt.want.isEmpty {
-				assert.NotEmpty(t, s.GetRandkey(tt.arg))
+		})
+	}
+}
+
+func TestInMemory_ShortKeyConflict(t *testing.T) {
+	ctx := _context.Background()
+	s := newTestInMemory(map[domain.ID]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"},
+	})
+
+	_, err := s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://iddqd.com", Short: "idkfa"})
+	assert.ErrorIs(t, err, errors.ErrShortKeyConflict)
+
+	_, err = s.StoreBatch(ctx, map[string]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+		"2": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "idkfa"},
+	})
+	assert.ErrorIs(t, err, errors.ErrShortKeyConflict)
+
+	_, err = s.StoreBatch(ctx, map[string]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+		"2": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "iddqd"},
+	})
+	assert.ErrorIs(t, err, errors.ErrShortKeyConflict)
+
+	// неудачный пакет ничего не сохраняет
+	assert.Len(t, s.items, 1)
+}
+
+func TestInMemory_Expiration(t *testing.T) {
+	ctx := _context.Background()
+	now := time.Now()
+	s := newTestInMemory(map[domain.ID]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa", ExpiresAt: now.Add(-time.Hour)},
+		"2": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd", ExpiresAt: now.Add(-48 * time.Hour)},
+		"3": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "idclp", ExpiresAt: now.Add(time.Hour)},
+	})
+
+	// истекшая ссылка заменяется новой вместо конфликта
+	stored, err := s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkf2"})
+	require.NoError(t, err)
+	assert.Equal(t, "idkf2", stored.Short)
+
+	old, err := s.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.Empty(t, old)
+
+	// действующая ссылка дает конфликт
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://idclip.com", Short: "idcl2"})
+	assert.ErrorIs(t, err, errors.ErrConflict)
+
+	deleted, err := s.DeleteExpired(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	item, err := s.GetByShort(ctx, "iddqd")
+	require.NoError(t, err)
+	assert.Empty(t, item)
+
+	item, err = s.GetByShort(ctx, "idclp")
+	require.NoError(t, err)
+	assert.Equal(t, "http://idclip.com", item.Full)
+}
+
+func TestInMemory_Click(t *testing.T) {
+	ctx := _context.Background()
+	s := newTestInMemory(map[domain.ID]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa", MaxClicks: 5},
+		"2": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+	})
+
+	// конкурентные переходы не превышают лимит
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	clicks := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Click(ctx, "idkfa")
+			if err == nil {
+				mu.Lock()
+				clicks++
+				mu.Unlock()
 				return
 			}
+			assert.ErrorIs(t, err, errors.ErrClickLimitReached)
+		}()
+	}
+	wg.Wait()
 
-			assert.Empty(t, s.GetRandkey(tt.arg))
-		})
+	assert.Equal(t, 5, clicks)
+	item, err := s.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.True(t, item.ClicksExhausted())
+
+	// ссылка без лимита не исчерпывается
+	for i := 0; i < 10; i++ {
+		_, err = s.Click(ctx, "iddqd")
+		require.NoError(t, err)
+	}
+
+	item, err = s.Click(ctx, "idclip")
+	require.NoError(t, err)
+	assert.Empty(t, item)
+}
+
+func TestInMemory_Count(t *testing.T) {
+	ctx := _context.Background()
+	s := newTestInMemory(map[domain.ID]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa"},
+		"2": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd"},
+		"3": {UserID: "Cacodemon", Full: "http://idclip.com", Short: "idclp"},
+		"4": {UserID: "Imp", Full: "http://idspispopd.com", Short: "idspi", Deleted: true},
+	})
+
+	urls, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, urls)
+
+	users, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users)
+}
+
+func TestInMemory_APIKeys(t *testing.T) {
+	ctx := _context.Background()
+	s := NewInMemory(nil)
+
+	now := time.Now()
+	ci := domain.APIKey{ID: "1", UserID: "DoomGuy", Name: "ci", Hash: "hash1", Scopes: []domain.APIKeyScope{domain.APIKeyScopeCreate}, CreatedAt: now}
+	bot := domain.APIKey{ID: "2", UserID: "DoomGuy", Name: "bot", Hash: "hash2", CreatedAt: now.Add(time.Second)}
+	imp := domain.APIKey{ID: "3", UserID: "Imp", Name: "ci", Hash: "hash3", CreatedAt: now}
+	for _, key := range []domain.APIKey{bot, ci, imp} {
+		require.NoError(t, s.StoreAPIKey(ctx, key))
 	}
+
+	key, err := s.GetAPIKeyByHash(ctx, "hash1")
+	require.NoError(t, err)
+	assert.Equal(t, ci, key)
+
+	key, err = s.GetAPIKeyByHash(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, key)
+
+	keys, err := s.GetUserAPIKeys(ctx, "DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, []domain.APIKey{ci, bot}, keys)
+
+	// чужой ключ не отзывается
+	revoked, err := s.RevokeAPIKey(ctx, "DoomGuy", "3")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	revoked, err = s.RevokeAPIKey(ctx, "DoomGuy", "1")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	key, err = s.GetAPIKeyByHash(ctx, "hash1")
+	require.NoError(t, err)
+	assert.Empty(t, key)
+
+	keys, err = s.GetUserAPIKeys(ctx, "DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, []domain.APIKey{bot}, keys)
+}
+
+func TestInMemory_Accounts(t *testing.T) {
+	ctx := _context.Background()
+	s := newTestInMemory(map[domain.ID]domain.URL{
+		"1": {UserID: "Anonymous", Full: "http://idkfa.com", Short: "idkfa"},
+		"2": {UserID: "Anonymous", Full: "http://iddqd.com", Short: "iddqd", Deleted: true},
+		"3": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "idclp"},
+		"4": {UserID: "Imp", Full: "http://idspispopd.com", Short: "idspi"},
+	})
+
+	doomGuy := domain.Account{ID: "DoomGuy", Login: "doomguy", PasswordHash: "hash", CreatedAt: time.Now()}
+	require.NoError(t, s.StoreAccount(ctx, doomGuy))
+	assert.ErrorIs(t, s.StoreAccount(ctx, domain.Account{ID: "Imp", Login: "doomguy"}), errors.ErrLoginTaken)
+	assert.ErrorIs(t, s.StoreAccount(ctx, domain.Account{ID: "DoomGuy", Login: "other"}), errors.ErrConflict)
+
+	a, err := s.GetAccountByLogin(ctx, "doomguy")
+	require.NoError(t, err)
+	assert.Equal(t, doomGuy, a)
+
+	a, err = s.GetAccount(ctx, "DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, doomGuy, a)
+
+	a, err = s.GetAccount(ctx, "Imp")
+	require.NoError(t, err)
+	assert.Empty(t, a)
+
+	merged, err := s.MergeUserURLs(ctx, "Anonymous", "DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, 2, merged)
+
+	urls, err := s.GetUserURLs(ctx, "DoomGuy")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"idkfa", "iddqd", "idclp"}, []string{urls[0].Short, urls[1].Short, urls[2].Short})
+
+	urls, err = s.GetUserURLs(ctx, "Anonymous")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	item, err := s.GetByShort(ctx, "idkfa")
+	require.NoError(t, err)
+	assert.Equal(t, "DoomGuy", item.UserID)
+}
+
+func TestInMemory_RevokeToken(t *testing.T) {
+	ctx := _context.Background()
+	s := newTestInMemory(map[domain.ID]domain.URL{})
+
+	revoked, err := s.RevokeToken(ctx, "expired", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = s.RevokeToken(ctx, "jti", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// повторный отзыв
+	revoked, err = s.RevokeToken(ctx, "jti", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	isRevoked, err := s.IsTokenRevoked(ctx, "jti")
+	require.NoError(t, err)
+	assert.True(t, isRevoked)
+
+	isRevoked, err = s.IsTokenRevoked(ctx, "unknown")
+	require.NoError(t, err)
+	assert.False(t, isRevoked)
+
+	// запись об отзыве истекшего токена удалена при отзыве
+	isRevoked, err = s.IsTokenRevoked(ctx, "expired")
+	require.NoError(t, err)
+	assert.False(t, isRevoked)
+}
+
+func TestInMemory_ReserveSequence(t *testing.T) {
+	ctx := _context.Background()
+	s := newTestInMemory(map[domain.ID]domain.URL{})
+
+	value, err := s.ReserveSequence(ctx, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000), value)
+
+	value, err = s.ReserveSequence(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1010), value)
 }
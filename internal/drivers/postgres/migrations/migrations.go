@@ -0,0 +1,269 @@
+// Модуль версионных миграций схемы базы postgres.
+//
+// Миграции лежат в каталоге sql и встраиваются в бинарник. Имя файла миграции имеет вид
+// <версия>_<название>.up.sql или <версия>_<название>.down.sql. Примененные версии хранятся
+// в таблице schema_migrations, одновременный запуск миграций несколькими экземплярами
+// исключается через advisory lock.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// Ключ advisory lock, под которым выполняются миграции.
+const lockKey = 7243661
+
+var fileNameRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration - миграция схемы с версией, названием и скриптами применения и отката.
+type Migration struct {
+	// Версия миграции.
+	Version int64
+
+	// Название миграции.
+	Name string
+
+	// Скрипт применения.
+	Up string
+
+	// Скрипт отката.
+	Down string
+}
+
+// Status - состояние миграции в базе.
+type Status struct {
+	Migration
+
+	// Флаг примененной миграции.
+	Applied bool
+
+	// Время применения миграции.
+	AppliedAt time.Time
+}
+
+// Migrator применяет и откатывает миграции.
+type Migrator struct {
+	db         *pgxpool.Pool
+	migrations []Migration
+	logger     *zap.SugaredLogger
+}
+
+// Конструктор мигратора, миграции читаются из встроенного каталога sql.
+func New(db *pgxpool.Pool, logger *zap.SugaredLogger) (*Migrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db, migrations, logger}, nil
+}
+
+// Применение всех еще не примененных миграций по возрастанию версий.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+
+			m.logger.Infow("Applying migration", "version", mg.Version, "name", mg.Name)
+			err = inTx(ctx, conn, mg.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mg.Version, mg.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", mg.Version, mg.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Откат steps последних примененных миграций по убыванию версий.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			mg := m.migrations[i]
+			if _, ok := applied[mg.Version]; !ok {
+				continue
+			}
+
+			m.logger.Infow("Reverting migration", "version", mg.Version, "name", mg.Name)
+			err = inTx(ctx, conn, mg.Down, `DELETE FROM schema_migrations WHERE version = $1`, mg.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", mg.Version, mg.Name, err)
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// Состояние всех известных миграций.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var result []Status
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		result = make([]Status, 0, len(m.migrations))
+		for _, mg := range m.migrations {
+			appliedAt, ok := applied[mg.Version]
+			result = append(result, Status{Migration: mg, Applied: ok, AppliedAt: appliedAt})
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// withLock выполняет f на отдельном соединении под advisory lock, предварительно создав таблицу версий.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *pgxpool.Conn) error) error {
+	conn, err := m.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name varchar(255) NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return f(conn)
+}
+
+// appliedVersions возвращает примененные версии и время их применения.
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
+
+	return result, rows.Err()
+}
+
+// inTx выполняет скрипт миграции и запись в таблицу версий в одной транзакции.
+func inTx(ctx context.Context, conn *pgxpool.Conn, script, versionQuery string, args ...any) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// скрипт без аргументов выполняется по simple protocol, поэтому может содержать несколько выражений
+	if _, err = tx.Exec(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(ctx, versionQuery, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// load читает миграции из fsys (каталог sql) и сортирует их по версии.
+// У каждой версии должны быть оба скрипта: up и down.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		matches := fileNameRegexp.FindStringSubmatch(e.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("bad migration file name %s", e.Name())
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = mg
+		}
+
+		if mg.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, mg.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			mg.Up = string(content)
+		} else {
+			mg.Down = string(content)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if len(mg.Up) == 0 || len(mg.Down) == 0 {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down scripts", mg.Version, mg.Name)
+		}
+		result = append(result, *mg)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := load(files)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	// версии идут по возрастанию и у каждой есть оба скрипта
+	for i, mg := range migrations {
+		assert.NotEmpty(t, mg.Up)
+		assert.NotEmpty(t, mg.Down)
+		if i > 0 {
+			assert.Greater(t, mg.Version, migrations[i-1].Version)
+		}
+	}
+
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "create_shorts", migrations[0].Name)
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "Migrations are sorted by version",
+			fsys: fstest.MapFS{
+				"sql/0010_second.up.sql":   {Data: []byte("up10")},
+				"sql/0010_second.down.sql": {Data: []byte("down10")},
+				"sql/0002_first.up.sql":    {Data: []byte("up2")},
+				"sql/0002_first.down.sql":  {Data: []byte("down2")},
+			},
+			want: []Migration{
+				{Version: 2, Name: "first", Up: "up2", Down: "down2"},
+				{Version: 10, Name: "second", Up: "up10", Down: "down10"},
+			},
+		},
+		{
+			name: "Missing down script",
+			fsys: fstest.MapFS{
+				"sql/0001_first.up.sql": {Data: []byte("up1")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Bad file name",
+			fsys: fstest.MapFS{
+				"sql/first.sql": {Data: []byte("up1")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Different names for one version",
+			fsys: fstest.MapFS{
+				"sql/0001_first.up.sql":   {Data: []byte("up1")},
+				"sql/0001_other.down.sql": {Data: []byte("down1")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := load(tt.fsys)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS shorts (
+	id varchar(36) PRIMARY KEY,
+	user_id varchar(36) NOT NULL,
+	full_url varchar(1000) UNIQUE NOT NULL,
+	short_key varchar(255) UNIQUE NOT NULL,
+	is_deleted boolean NOT NULL DEFAULT false
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS shorts_expires_at_idx;
+ALTER TABLE shorts DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,2 @@
+ALTER TABLE shorts ADD COLUMN IF NOT EXISTS expires_at timestamptz NULL;
+CREATE INDEX IF NOT EXISTS shorts_expires_at_idx ON shorts (expires_at) WHERE expires_at IS NOT NULL;
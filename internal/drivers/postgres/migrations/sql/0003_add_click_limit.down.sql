@@ -0,0 +1,2 @@
+ALTER TABLE shorts DROP COLUMN IF EXISTS clicks;
+ALTER TABLE shorts DROP COLUMN IF EXISTS max_clicks;
@@ -0,0 +1 @@
+ALTER TABLE shorts ADD COLUMN IF NOT EXISTS password_hash varchar(255) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE shorts ADD COLUMN IF NOT EXISTS redirect_type smallint NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS clicks (
+	id bigserial PRIMARY KEY,
+	short_key varchar(255) NOT NULL,
+	clicked_at timestamptz NOT NULL,
+	referrer text NOT NULL DEFAULT '',
+	user_agent text NOT NULL DEFAULT '',
+	ip varchar(45) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS clicks_short_key_clicked_at_idx ON clicks (short_key, clicked_at);
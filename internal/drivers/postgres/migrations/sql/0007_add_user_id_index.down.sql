@@ -0,0 +1 @@
+DROP INDEX IF EXISTS shorts_user_id_idx;
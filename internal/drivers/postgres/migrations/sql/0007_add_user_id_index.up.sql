@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS shorts_user_id_idx ON shorts (user_id) WHERE NOT is_deleted;
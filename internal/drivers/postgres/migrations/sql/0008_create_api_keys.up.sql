@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id varchar(36) PRIMARY KEY,
+	user_id varchar(36) NOT NULL,
+	name varchar(100) NOT NULL,
+	key_hash char(64) UNIQUE NOT NULL,
+	scopes text[] NOT NULL DEFAULT '{}',
+	created_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
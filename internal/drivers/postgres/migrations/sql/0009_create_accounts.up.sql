@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS accounts (
+	id varchar(36) PRIMARY KEY,
+	login varchar(64) UNIQUE NOT NULL,
+	password_hash varchar(60) NOT NULL,
+	created_at timestamptz NOT NULL
+);
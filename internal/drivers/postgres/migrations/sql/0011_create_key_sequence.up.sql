@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS key_sequence (
+	name varchar(32) PRIMARY KEY,
+	value bigint NOT NULL
+);
+INSERT INTO key_sequence (name, value) SELECT 'shorts', count(*) FROM shorts ON CONFLICT (name) DO NOTHING;
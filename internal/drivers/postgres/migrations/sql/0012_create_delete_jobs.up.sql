@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS delete_jobs (
+	id varchar(36) PRIMARY KEY,
+	user_id varchar(36) NOT NULL,
+	shorts text[] NOT NULL,
+	created_at timestamptz NOT NULL
+);
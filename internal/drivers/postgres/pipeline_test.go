@@ -0,0 +1,168 @@
+package postgres
+
+import (
+	"context"
+	_goerrors "errors"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mikesvis/short/internal/domain"
+)
+
+// Сообщение конвейера pipelineDeleteBatch: ключ пользователя, ID ссылки и результат удаления.
+type userUpdateItem struct {
+	UserID   string
+	ShortKey string
+	ID       string
+	Status   domain.DeleteStatus
+}
+
+// Предыдущая реализация DeleteBatch: конвейер из 10 воркеров, каждый ключ проверяется отдельным SELECT,
+// затем один UPDATE по найденным id. Оставлена для сравнения в BenchmarkPostgres_DeleteBatch.
+func (s *Postgres) pipelineDeleteBatch(ctx context.Context, userID string, pack []string) (map[string]domain.DeleteStatus, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	inputCh := s.generator(ctx, userID, pack)
+	channels := s.fanOut(ctx, cancel, inputCh)
+	resultCh := s.fanIn(ctx, channels...)
+	return s.deleteBatchByIds(ctx, resultCh)
+}
+
+// генератор добавляет в канал сообщения
+// в каждом сообщении userID + shortKey
+func (s *Postgres) generator(ctx context.Context, userID string, input []string) chan userUpdateItem {
+	inputCh := make(chan userUpdateItem, 10)
+
+	go func() {
+		defer close(inputCh)
+		for _, data := range input {
+			item := userUpdateItem{
+				UserID:   userID,
+				ShortKey: data,
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case inputCh <- item:
+			}
+		}
+	}()
+
+	return inputCh
+}
+
+// fanOut распределяет сообщения (userID + shortKey) на воркеры
+// пишем результат воркеров в пул каналов
+func (s *Postgres) fanOut(ctx context.Context, cancel context.CancelCauseFunc, inputCh chan userUpdateItem) []chan userUpdateItem {
+	numWorkers := 10
+	channels := make([]chan userUpdateItem, numWorkers)
+
+	for i := 0; i < numWorkers; i++ {
+		validateResCh := s.validate(ctx, cancel, inputCh)
+		channels[i] = validateResCh
+	}
+
+	return channels
+}
+
+// Валидируем пользователя и не было ли уже удалено ранее, в сообщение пишем ID и результат удаления ключа.
+// Ошибка запроса отменяет контекст удаления с этой ошибкой в качестве причины.
+func (s *Postgres) validate(ctx context.Context, cancel context.CancelCauseFunc, inputCh <-chan userUpdateItem) chan userUpdateItem {
+	validateRes := make(chan userUpdateItem, 1)
+	go func() {
+		defer close(validateRes)
+
+		for data := range inputCh {
+
+			row := s.db.QueryRow(ctx, `SELECT id, user_id, is_deleted FROM shorts WHERE "short_key" = $1`, data.ShortKey)
+			item := domain.URL{Short: data.ShortKey}
+			err := row.Scan(&data.ID, &item.UserID, &item.Deleted)
+			if _goerrors.Is(err, pgx.ErrNoRows) {
+				item = domain.URL{}
+				err = nil
+			}
+
+			if err != nil {
+				s.logger.Errorw(`Error occured while scanning row`, err, `data`, data)
+				cancel(err)
+				return
+			}
+
+			data.Status = domain.DeleteStatusOf(item, data.UserID)
+			select {
+			case <-ctx.Done():
+				return
+			case validateRes <- data:
+			}
+		}
+	}()
+
+	return validateRes
+}
+
+// fanIn объединяем каналы в результирующий канал
+// в сообщениях уже проверенные ключи с результатом удаления
+func (s *Postgres) fanIn(ctx context.Context, resultChs ...chan userUpdateItem) chan userUpdateItem {
+	finalCh := make(chan userUpdateItem, 10)
+
+	var wg sync.WaitGroup
+
+	for _, ch := range resultChs {
+		chClosure := ch
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for data := range chClosure {
+				select {
+				case <-ctx.Done():
+					return
+				case finalCh <- data:
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(finalCh)
+	}()
+
+	return finalCh
+}
+
+// берем из канала результаты по ключам, для ключей, которые можно удалить, выполняем бач update
+// если конвейер был прерван, возвращаем причину и ничего не обновляем
+func (s *Postgres) deleteBatchByIds(ctx context.Context, inputCh chan userUpdateItem) (map[string]domain.DeleteStatus, error) {
+	result := make(map[string]domain.DeleteStatus)
+	var idsToDelete []string
+	for data := range inputCh {
+		if _, seen := result[data.ShortKey]; seen {
+			continue
+		}
+
+		result[data.ShortKey] = data.Status
+		if data.Status == domain.DeleteStatusDeleted {
+			idsToDelete = append(idsToDelete, data.ID)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+
+	if len(idsToDelete) == 0 {
+		return result, nil
+	}
+
+	_, err := s.db.Exec(ctx, `UPDATE shorts SET "is_deleted" = true WHERE id = ANY($1)`, idsToDelete)
+	if err != nil {
+		s.logger.Errorw(`Error occured while updating rows`, err, `idsToDelete`, idsToDelete)
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -3,102 +3,127 @@ package postgres
 
 import (
 	"context"
-	"database/sql"
 	_goerrors "errors"
-	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/jmoiron/sqlx"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/drivers/postgres/migrations"
 	"github.com/mikesvis/short/internal/errors"
-	"github.com/mikesvis/short/internal/keygen"
+	"github.com/mikesvis/short/internal/metrics"
 	"go.uber.org/zap"
 )
 
+// Имя драйвера в метриках storage.
+const driverName = "postgres"
+
+// Имя ограничения уникальности короткого ключа в таблице shorts.
+const shortKeyConstraint = "shorts_short_key_key"
+
+// Колонки таблицы shorts, соответствующие полям postgresDBItem.
+const itemColumns = `id, user_id, full_url, short_key, is_deleted, expires_at, max_clicks, clicks, password_hash, redirect_type`
+
 type postgresDBItem struct {
-	ID       string `db:"id"`
-	UserID   string `db:"user_id"`
-	FullURL  string `db:"full_url"`
-	ShortKey string `db:"short_key"`
-	Deleted  bool   `db:"is_deleted"`
+	ID           string     `db:"id"`
+	UserID       string     `db:"user_id"`
+	FullURL      string     `db:"full_url"`
+	ShortKey     string     `db:"short_key"`
+	Deleted      bool       `db:"is_deleted"`
+	ExpiresAt    *time.Time `db:"expires_at"`
+	MaxClicks    int        `db:"max_clicks"`
+	Clicks       int        `db:"clicks"`
+	PasswordHash string     `db:"password_hash"`
+	RedirectType int        `db:"redirect_type"`
+}
+
+// Преобразование записи базы в доменную модель.
+func (p postgresDBItem) toURL() domain.URL {
+	u := domain.URL{UserID: p.UserID, Full: p.FullURL, Short: p.ShortKey, Deleted: p.Deleted, MaxClicks: p.MaxClicks, Clicks: p.Clicks, PasswordHash: p.PasswordHash, RedirectType: p.RedirectType}
+	if p.ExpiresAt != nil {
+		u.ExpiresAt = *p.ExpiresAt
+	}
+
+	return u
 }
 
-type userUpdateItem struct {
-	UserID   string
-	ShortKey string
+// Чтение записи из строки результата, колонки должны идти в порядке itemColumns.
+func (p *postgresDBItem) scan(row pgx.Row) error {
+	return row.Scan(&p.ID, &p.UserID, &p.FullURL, &p.ShortKey, &p.Deleted, &p.ExpiresAt, &p.MaxClicks, &p.Clicks, &p.PasswordHash, &p.RedirectType)
 }
 
-// Storage для хранения в базе, включает в себя указатель на sqlx.DB и логгер.
+// Время истечения для записи в базу, нулевое время хранится как NULL.
+func expiresAt(u domain.URL) *time.Time {
+	if u.ExpiresAt.IsZero() {
+		return nil
+	}
+
+	return &u.ExpiresAt
+}
+
+// Storage для хранения в базе, включает в себя пул соединений pgxpool и логгер.
+// Запросы выполняются в режиме pgx.QueryExecModeCacheStatement: каждое соединение пула
+// подготавливает запрос один раз и дальше переиспользует prepared statement.
 type Postgres struct {
-	db     *sqlx.DB
+	db     *pgxpool.Pool
 	logger *zap.SugaredLogger
 }
 
-// Конструктор storage в базе. При инициализации будут созданы недостающие таблицы.
-func NewPostgres(db *sqlx.DB, logger *zap.SugaredLogger) (*Postgres, error) {
-	err := bootstrapDB(db)
+// Конструктор storage в базе. При инициализации применяются недостающие миграции схемы.
+func NewPostgres(db *pgxpool.Pool, logger *zap.SugaredLogger) (*Postgres, error) {
+	migrator, err := migrations.New(db, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Postgres{db, logger}, nil
-}
+	if err = migrator.Up(context.Background()); err != nil {
+		return nil, err
+	}
 
-func bootstrapDB(db *sqlx.DB) error {
-	createTableShort := `
-		CREATE TABLE IF NOT EXISTS shorts (
-			id varchar(36) PRIMARY KEY,
-			user_id varchar(36) NOT NULL,
-			full_url varchar(1000) UNIQUE NOT NULL,
-			short_key varchar(255) UNIQUE NOT NULL,
-			is_deleted boolean NOT NULL DEFAULT false
-		)
-	`
-	// Как проверить эту строку? :(
-	_, err := db.Exec(createTableShort)
-	return err
+	return &Postgres{db, logger}, nil
 }
 
 // Сохранение короткой ссылки. При сохранении происходит поиск на предмет уже существующей ссылки.
-// В случае если такая ссылка уже была ранее создана вернется ошибка.
+// В случае если такая ссылка уже была ранее создана вернется ошибка ErrConflict,
+// если занят короткий ключ - ErrShortKeyConflict. Истекшая ссылка с тем же полным URL заменяется новой.
 func (s *Postgres) Store(ctx context.Context, u domain.URL) (domain.URL, error) {
-	emptyResult := domain.URL{}
+	defer metrics.ObserveStorage(driverName, "Store", time.Now())
 
-	// Как проверить эту строку? :(
-	stmt, err := s.db.PrepareContext(ctx, `INSERT INTO shorts (id, user_id, full_url, short_key) VALUES ($1, $2, $3, $4) ON CONFLICT (short_key) DO NOTHING`)
-	if err != nil {
-		s.logger.Errorw(`Cant prepare db query`, err)
-		return emptyResult, err
-	}
-	defer stmt.Close()
+	emptyResult := domain.URL{}
 
 	// генерируем новый короткий урл
 	item := postgresDBItem{
-		ID:       uuid.NewString(),
-		UserID:   u.UserID,
-		FullURL:  u.Full,
-		ShortKey: u.Short,
+		ID:           uuid.NewString(),
+		UserID:       u.UserID,
+		FullURL:      u.Full,
+		ShortKey:     u.Short,
+		ExpiresAt:    expiresAt(u),
+		MaxClicks:    u.MaxClicks,
+		PasswordHash: u.PasswordHash,
+		RedirectType: u.RedirectType,
 	}
 
-	// Как проверить эту строку на ошибку? При тестах пересечение по ключу не выскакивает, хотя и не сохраняет :(
-	_, err = stmt.ExecContext(ctx, item.ID, item.UserID, item.FullURL, item.ShortKey)
-	if err != nil {
-		s.logger.Infof("old %v", err)
-		var pgErr *pgconn.PgError
-		if _goerrors.As(err, &pgErr) && pgErr.Code != pgerrcode.UniqueViolation {
-			// Ошибка непонятная
-			s.logger.Errorw(`Error occured during insert`, err)
-			return emptyResult, err
-		}
-	}
+	err := s.insert(ctx, item)
 
 	// Ошибок не было, значит успешно сохранили с новым коротким урлом
 	if err == nil {
 		return u, nil
 	}
 
+	var pgErr *pgconn.PgError
+	if !_goerrors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+		// Ошибка непонятная
+		s.logger.Errorw(`Error occured during insert`, err)
+		return emptyResult, err
+	}
+
+	if pgErr.ConstraintName == shortKeyConstraint {
+		return emptyResult, errors.ErrShortKeyConflict
+	}
+
 	// Был конфликт пересечения по короткому урлу, забираем старый короткий урл который уже был в базе
 	old, err := s.GetByFull(ctx, u.Full)
 	if err != nil {
@@ -106,27 +131,65 @@ func (s *Postgres) Store(ctx context.Context, u domain.URL) (domain.URL, error)
 		return emptyResult, err
 	}
 
-	return old, errors.ErrConflict
+	if !old.Expired(time.Now()) {
+		return old, errors.ErrConflict
+	}
+
+	// старая ссылка истекла, заменяем ее новой одним запросом: условие истечения проверяется под блокировкой строки,
+	// поэтому из параллельных запросов ссылку заменяет только первый, остальные получают новую ссылку с ErrConflict
+	var id string
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO shorts (id, user_id, full_url, short_key, expires_at, max_clicks, password_hash, redirect_type)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (full_url) DO UPDATE SET
+			id = EXCLUDED.id, user_id = EXCLUDED.user_id, short_key = EXCLUDED.short_key, is_deleted = false,
+			expires_at = EXCLUDED.expires_at, max_clicks = EXCLUDED.max_clicks, clicks = 0,
+			password_hash = EXCLUDED.password_hash, redirect_type = EXCLUDED.redirect_type
+		WHERE shorts.expires_at <= $9
+		RETURNING id`,
+		item.ID, item.UserID, item.FullURL, item.ShortKey, item.ExpiresAt, item.MaxClicks, item.PasswordHash, item.RedirectType, time.Now(),
+	).Scan(&id)
+
+	if isShortKeyViolation(err) {
+		return emptyResult, errors.ErrShortKeyConflict
+	}
+
+	// ссылку уже заменил параллельный запрос
+	if _goerrors.Is(err, pgx.ErrNoRows) {
+		old, err = s.GetByFull(ctx, u.Full)
+		if err != nil {
+			return emptyResult, err
+		}
+
+		return old, errors.ErrConflict
+	}
+
+	if err != nil {
+		s.logger.Errorw(`Error occured during insert`, err)
+		return emptyResult, err
+	}
+
+	return u, nil
+}
+
+// Вставка одной записи.
+func (s *Postgres) insert(ctx context.Context, item postgresDBItem) error {
+	_, err := s.db.Exec(ctx, `INSERT INTO shorts (id, user_id, full_url, short_key, expires_at, max_clicks, password_hash, redirect_type) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, item.ID, item.UserID, item.FullURL, item.ShortKey, item.ExpiresAt, item.MaxClicks, item.PasswordHash, item.RedirectType)
+	return err
 }
 
 // Поиск по полной ссылке.
 func (s *Postgres) GetByFull(ctx context.Context, fullURL string) (domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "GetByFull", time.Now())
+
 	emptyResult := domain.URL{}
 
 	// пробуем получить по полному урлу
-	// Как проверить эту строку? :(
-	stmt, err := s.db.PrepareContext(ctx, `SELECT id, user_id, full_url, short_key, is_deleted FROM shorts WHERE "full_url" = $1`)
-	if err != nil {
-		s.logger.Errorw(`Cant prepare db query`, err)
-		return emptyResult, err
-	}
-	defer stmt.Close()
-
-	row := stmt.QueryRowContext(ctx, fullURL)
+	row := s.db.QueryRow(ctx, `SELECT `+itemColumns+` FROM shorts WHERE "full_url" = $1`, fullURL)
 
 	var p postgresDBItem
-	err = row.Scan(&p.ID, &p.UserID, &p.FullURL, &p.ShortKey, &p.Deleted)
-	if _goerrors.Is(err, sql.ErrNoRows) {
+	err := p.scan(row)
+	if _goerrors.Is(err, pgx.ErrNoRows) {
 		// нет совпадения по полному урлу, вернем пустой результат
 		return emptyResult, nil
 	}
@@ -137,27 +200,21 @@ func (s *Postgres) GetByFull(ctx context.Context, fullURL string) (domain.URL, e
 		return emptyResult, err
 	}
 
-	return domain.URL{UserID: p.UserID, Full: p.FullURL, Short: p.ShortKey, Deleted: p.Deleted}, nil
+	return p.toURL(), nil
 }
 
 // Поиск по короткой ссылке.
 func (s *Postgres) GetByShort(ctx context.Context, shortURL string) (domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "GetByShort", time.Now())
+
 	emptyResult := domain.URL{}
 
 	// пробуем получить по короткому урлу
-	// Как проверить эту строку? :(
-	stmt, err := s.db.PrepareContext(ctx, `SELECT id, user_id, full_url, short_key, is_deleted FROM shorts WHERE "short_key" = $1`)
-	if err != nil {
-		s.logger.Errorw(`Cant prepare db query`, err)
-		return emptyResult, err
-	}
-	defer stmt.Close()
-
-	row := stmt.QueryRowContext(ctx, shortURL)
+	row := s.db.QueryRow(ctx, `SELECT `+itemColumns+` FROM shorts WHERE "short_key" = $1`, shortURL)
 
 	var p postgresDBItem
-	err = row.Scan(&p.ID, &p.UserID, &p.FullURL, &p.ShortKey, &p.Deleted)
-	if _goerrors.Is(err, sql.ErrNoRows) {
+	err := p.scan(row)
+	if _goerrors.Is(err, pgx.ErrNoRows) {
 		// нет совпадения по короткому урлу, вернем пустой результат
 		return emptyResult, nil
 	}
@@ -168,16 +225,21 @@ func (s *Postgres) GetByShort(ctx context.Context, shortURL string) (domain.URL,
 		return emptyResult, err
 	}
 
-	return domain.URL{UserID: p.UserID, Full: p.FullURL, Short: p.ShortKey, Deleted: p.Deleted}, nil
+	return p.toURL(), nil
 }
 
 // Пинг базы.
 func (s *Postgres) Ping(ctx context.Context) error {
-	return s.db.PingContext(ctx)
+	defer metrics.ObserveStorage(driverName, "Ping", time.Now())
+
+	return s.db.Ping(ctx)
 }
 
 // Пакетное сохранение коротких URL. В методе используется поиск уже существующих URL.
+// Если хотя бы один новый короткий ключ занят, транзакция откатывается и возвращается ErrShortKeyConflict.
 func (s *Postgres) StoreBatch(ctx context.Context, us map[string]domain.URL) (map[string]domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "StoreBatch", time.Now())
+
 	// в мапере хранится полный урл = ключ корреляции
 	mapper := make(map[string]string, len(us))
 	// это хотим сохранить, но существующие будут удаляться из добавления в базу
@@ -191,34 +253,29 @@ func (s *Postgres) StoreBatch(ctx context.Context, us map[string]domain.URL) (ma
 		fullUrls = append(fullUrls, v.Full)
 	}
 
-	// какое-то неведомое колдунство? Иначе where in не сделать
-	// как протестить err?
-	query, args, err := sqlx.In("SELECT id, user_id, full_url, short_key, is_deleted FROM shorts WHERE full_url IN (?)", fullUrls)
+	// ищем существующие
+	rows, err := s.db.Query(ctx, `SELECT `+itemColumns+` FROM shorts WHERE full_url = ANY($1)`, fullUrls)
 	if err != nil {
-		s.logger.Errorw(`Error occured while composing query`, err)
+		s.logger.Errorw(`Error occured while select`, err)
 		return nil, err
 	}
-	query = s.db.Rebind(query)
 
-	// ищем существующие
-	// как протестить err?
-	existingItems := []postgresDBItem{}
-	err = s.db.SelectContext(ctx, &existingItems, query, args...)
+	existingItems, err := pgx.CollectRows(rows, pgx.RowToStructByName[postgresDBItem])
 	if err != nil {
 		s.logger.Errorw(`Error occured while select`, err)
 		return nil, err
 	}
 
+	now := time.Now()
 	for _, v := range existingItems {
+		// истекшие ссылки будут заменены новыми
+		if v.toURL().Expired(now) {
+			continue
+		}
 		// удаляем то что сохранять не нужно
 		delete(toStore, mapper[string(v.FullURL)])
 		// воскрешаем старые урлы сразу в результативную мапу
-		us[mapper[string(v.FullURL)]] = domain.URL{
-			UserID:  v.UserID,
-			Full:    v.FullURL,
-			Short:   v.ShortKey,
-			Deleted: v.Deleted,
-		}
+		us[mapper[string(v.FullURL)]] = v.toURL()
 	}
 
 	// нечего сохранять - уходим
@@ -230,25 +287,92 @@ func (s *Postgres) StoreBatch(ctx context.Context, us map[string]domain.URL) (ma
 	newItems := []postgresDBItem{}
 	for _, v := range toStore {
 		newItems = append(newItems, postgresDBItem{
-			ID:       uuid.NewString(),
-			UserID:   v.UserID,
-			FullURL:  v.Full,
-			ShortKey: v.Short,
-			Deleted:  v.Deleted,
+			ID:           uuid.NewString(),
+			UserID:       v.UserID,
+			FullURL:      v.Full,
+			ShortKey:     v.Short,
+			Deleted:      v.Deleted,
+			ExpiresAt:    expiresAt(v),
+			MaxClicks:    v.MaxClicks,
+			PasswordHash: v.PasswordHash,
+			RedirectType: v.RedirectType,
 		})
 	}
 
-	// сделаем добавление через транзакцию
-	tx := s.db.MustBeginTx(ctx, nil)
-	defer tx.Rollback()
-	// как протестить err?
-	_, err = tx.NamedExecContext(ctx, `INSERT INTO shorts (id, user_id, full_url, short_key) VALUES (:id, :user_id, :full_url, :short_key)`, newItems)
+	// сделаем добавление через транзакцию, вставки уходят в базу одним пакетом. Истекшие ссылки заменяются
+	// тем же условным upsert, что и в Store: ссылку, которую параллельный запрос уже сохранил или заменил,
+	// вставка не трогает, и в результат попадает сохраненная им ссылка
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorw(`Error occured while starting transaction`, err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, v := range newItems {
+		batch.Queue(`
+			INSERT INTO shorts (id, user_id, full_url, short_key, expires_at, max_clicks, password_hash, redirect_type)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (full_url) DO UPDATE SET
+				id = EXCLUDED.id, user_id = EXCLUDED.user_id, short_key = EXCLUDED.short_key, is_deleted = false,
+				expires_at = EXCLUDED.expires_at, max_clicks = EXCLUDED.max_clicks, clicks = 0,
+				password_hash = EXCLUDED.password_hash, redirect_type = EXCLUDED.redirect_type
+			WHERE shorts.expires_at <= $9
+			RETURNING id`,
+			v.ID, v.UserID, v.FullURL, v.ShortKey, v.ExpiresAt, v.MaxClicks, v.PasswordHash, v.RedirectType, now,
+		)
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	taken := []string{}
+	for _, v := range newItems {
+		var id string
+		err = results.QueryRow().Scan(&id)
+		// ссылку уже сохранил параллельный запрос
+		if _goerrors.Is(err, pgx.ErrNoRows) {
+			taken = append(taken, string(v.FullURL))
+			err = nil
+			continue
+		}
+
+		if err != nil {
+			break
+		}
+	}
+	if closeErr := results.Close(); err == nil {
+		err = closeErr
+	}
+
+	if isShortKeyViolation(err) {
+		return nil, errors.ErrShortKeyConflict
+	}
+
 	if err != nil {
 		s.logger.Errorw(`Error occured while batch insert`, err)
 		return nil, err
 	}
 
-	err = tx.Commit()
+	// воскрешаем ссылки, сохраненные параллельным запросом
+	if len(taken) > 0 {
+		rows, err = tx.Query(ctx, `SELECT `+itemColumns+` FROM shorts WHERE full_url = ANY($1)`, taken)
+		if err != nil {
+			s.logger.Errorw(`Error occured while select`, err)
+			return nil, err
+		}
+
+		takenItems, err := pgx.CollectRows(rows, pgx.RowToStructByName[postgresDBItem])
+		if err != nil {
+			s.logger.Errorw(`Error occured while select`, err)
+			return nil, err
+		}
+
+		for _, v := range takenItems {
+			us[mapper[string(v.FullURL)]] = v.toURL()
+		}
+	}
+
+	err = tx.Commit(ctx)
 	// как протестить err?
 	if err != nil {
 		s.logger.Errorw(`Error occured while commiting transaction`, err)
@@ -258,18 +382,32 @@ func (s *Postgres) StoreBatch(ctx context.Context, us map[string]domain.URL) (ma
 	return us, nil
 }
 
+// isShortKeyViolation проверяет, что ошибка вызвана нарушением уникальности короткого ключа.
+func isShortKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return _goerrors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation && pgErr.ConstraintName == shortKeyConstraint
+}
+
+// Пул соединений к базе, нужен для хранилок, работающих с той же базой.
+func (s *Postgres) DB() *pgxpool.Pool {
+	return s.db
+}
+
 // Закрытие соединения к базе.
 func (s *Postgres) Close() error {
-	return s.db.Close()
+	s.db.Close()
+	return nil
 }
 
 // Получение ссылок, созданных пользоваетелем.
 func (s *Postgres) GetUserURLs(ctx context.Context, userID string) ([]domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "GetUserURLs", time.Now())
+
 	if len(userID) == 0 {
 		return nil, nil
 	}
 
-	rows, err := s.db.QueryxContext(ctx, "SELECT id, user_id, full_url, short_key, is_deleted FROM shorts WHERE user_id = $1", userID)
+	rows, err := s.db.Query(ctx, `SELECT `+itemColumns+` FROM shorts WHERE user_id = $1`, userID)
 	if err != nil {
 		s.logger.Errorw(`Error occured while preparing query`, err)
 		return nil, err
@@ -280,166 +418,397 @@ func (s *Postgres) GetUserURLs(ctx context.Context, userID string) ([]domain.URL
 }
 
 // не вижу особого смысла так разбивать, но раз был комментарий то вынесу вот это
-func (s *Postgres) fetchUserURLs(rows *sqlx.Rows) ([]domain.URL, error) {
-	p := postgresDBItem{}
-	result := make([]domain.URL, 0, 20)
-	for rows.Next() {
-		err := rows.StructScan(&p)
-		if err != nil {
-			s.logger.Errorw(`Error occured while scanning row`, err)
-			return nil, err
-		}
+func (s *Postgres) fetchUserURLs(rows pgx.Rows) ([]domain.URL, error) {
+	items, err := pgx.CollectRows(rows, pgx.RowToStructByName[postgresDBItem])
+	if err != nil {
+		s.logger.Errorw(`Error caused by rows fetch`, err)
+		return nil, err
+	}
 
-		result = append(result, domain.URL{
-			UserID:  p.UserID,
-			Full:    p.FullURL,
-			Short:   p.ShortKey,
-			Deleted: p.Deleted,
-		})
+	result := make([]domain.URL, 0, len(items))
+	for _, p := range items {
+		result = append(result, p.toURL())
 	}
 
-	err := rows.Err()
+	return result, nil
+}
+
+// Учет перехода по короткой ссылке. Счетчик увеличивается условным UPDATE, который не срабатывает
+// при исчерпанном лимите, поэтому конкурентные переходы не превышают лимит.
+// Если лимит исчерпан, возвращается ErrClickLimitReached.
+func (s *Postgres) Click(ctx context.Context, shortURL string) (domain.URL, error) {
+	defer metrics.ObserveStorage(driverName, "Click", time.Now())
+
+	row := s.db.QueryRow(ctx, `UPDATE shorts SET clicks = clicks + 1 WHERE short_key = $1 AND (max_clicks = 0 OR clicks < max_clicks) RETURNING `+itemColumns, shortURL)
+
+	var p postgresDBItem
+	err := p.scan(row)
+	if err == nil {
+		return p.toURL(), nil
+	}
+
+	if !_goerrors.Is(err, pgx.ErrNoRows) {
+		s.logger.Errorw(`Error occured during update`, err)
+		return domain.URL{}, err
+	}
+
+	// обновление не сработало: ссылки нет либо лимит исчерпан
+	item, err := s.GetByShort(ctx, shortURL)
+	if err != nil || (item == domain.URL{}) {
+		return item, err
+	}
+
+	return item, errors.ErrClickLimitReached
+}
+
+// Удаление ссылок, истекших раньше before. Возвращает количество удаленных ссылок.
+func (s *Postgres) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	defer metrics.ObserveStorage(driverName, "DeleteExpired", time.Now())
+
+	tag, err := s.db.Exec(ctx, `DELETE FROM shorts WHERE expires_at < $1`, before)
+	if err != nil {
+		s.logger.Errorw(`Error occured while deleting expired`, err)
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// Количество неудаленных ссылок.
+func (s *Postgres) CountURLs(ctx context.Context) (int, error) {
+	defer metrics.ObserveStorage(driverName, "CountURLs", time.Now())
+
+	var count int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM shorts WHERE NOT is_deleted`).Scan(&count); err != nil {
+		s.logger.Errorw(`Error occured while counting urls`, err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Количество пользователей, у которых есть неудаленные ссылки.
+func (s *Postgres) CountUsers(ctx context.Context) (int, error) {
+	defer metrics.ObserveStorage(driverName, "CountUsers", time.Now())
+
+	var count int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(DISTINCT user_id) FROM shorts WHERE NOT is_deleted`).Scan(&count); err != nil {
+		s.logger.Errorw(`Error occured while counting users`, err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Колонки таблицы api_keys, в порядке чтения scanAPIKey.
+const apiKeyColumns = `id, user_id, name, key_hash, scopes, created_at`
+
+// Чтение API ключа из строки результата, колонки должны идти в порядке apiKeyColumns.
+func scanAPIKey(row pgx.Row) (domain.APIKey, error) {
+	var key domain.APIKey
+	var scopes []string
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Hash, &scopes, &key.CreatedAt); err != nil {
+		return domain.APIKey{}, err
+	}
+
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, domain.APIKeyScope(scope))
+	}
+
+	return key, nil
+}
+
+// Сохранение API ключа.
+func (s *Postgres) StoreAPIKey(ctx context.Context, key domain.APIKey) error {
+	defer metrics.ObserveStorage(driverName, "StoreAPIKey", time.Now())
+
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	_, err := s.db.Exec(ctx, `INSERT INTO api_keys (`+apiKeyColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`, key.ID, key.UserID, key.Name, key.Hash, scopes, key.CreatedAt)
+	if err != nil {
+		s.logger.Errorw(`Error occured while inserting api key`, err)
+		return err
+	}
+
+	return nil
+}
+
+// Получение API ключа по хешу.
+func (s *Postgres) GetAPIKeyByHash(ctx context.Context, hash string) (domain.APIKey, error) {
+	defer metrics.ObserveStorage(driverName, "GetAPIKeyByHash", time.Now())
+
+	key, err := scanAPIKey(s.db.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, hash))
+	if _goerrors.Is(err, pgx.ErrNoRows) {
+		return domain.APIKey{}, nil
+	}
+
+	if err != nil {
+		s.logger.Errorw(`Error occured while selecting api key`, err)
+		return domain.APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// Получение API ключей пользователя в порядке создания.
+func (s *Postgres) GetUserAPIKeys(ctx context.Context, userID string) ([]domain.APIKey, error) {
+	defer metrics.ObserveStorage(driverName, "GetUserAPIKeys", time.Now())
+
+	rows, err := s.db.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at, id`, userID)
+	if err != nil {
+		s.logger.Errorw(`Error occured while preparing query`, err)
+		return nil, err
+	}
+
+	keys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (domain.APIKey, error) {
+		return scanAPIKey(row)
+	})
 	if err != nil {
 		s.logger.Errorw(`Error caused by rows fetch`, err)
 		return nil, err
 	}
 
-	return result, nil
+	return keys, nil
 }
 
-// Пакетное удаление коротких ссылок
-func (s *Postgres) DeleteBatch(ctx context.Context, userID string, pack []string) {
-	inputCh := s.generator(ctx, userID, pack)
-	channels := s.fanOut(ctx, inputCh)
-	resultCh := s.fanIn(ctx, channels...)
-	s.deleteBatchByIds(ctx, resultCh)
-}
-
-// генератор добавляет в канал сообщения
-// в каждом сообщении userID + shortKey
-func (s *Postgres) generator(ctx context.Context, userID string, input []string) chan userUpdateItem {
-	inputCh := make(chan userUpdateItem, 10)
-
-	go func() {
-		defer close(inputCh)
-		for _, data := range input {
-			item := userUpdateItem{
-				UserID:   userID,
-				ShortKey: data,
-			}
-			select {
-			case <-ctx.Done():
-				return
-			case inputCh <- item:
-			}
-		}
-	}()
+// Отзыв API ключа id пользователя userID, ключ удаляется.
+func (s *Postgres) RevokeAPIKey(ctx context.Context, userID string, id string) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "RevokeAPIKey", time.Now())
+
+	tag, err := s.db.Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		s.logger.Errorw(`Error occured while deleting api key`, err)
+		return false, err
+	}
 
-	return inputCh
+	return tag.RowsAffected() > 0, nil
 }
 
-// fanOut распределяет сообщения (userID + shortKey) на воркеры
-// пишем результат воркеров в пул каналов
-func (s *Postgres) fanOut(ctx context.Context, inputCh chan userUpdateItem) []chan string {
-	numWorkers := 10
-	channels := make([]chan string, numWorkers)
+// Имя ограничения уникальности логина в таблице accounts.
+const loginConstraint = "accounts_login_key"
 
-	for i := 0; i < numWorkers; i++ {
-		validateResCh := s.validate(ctx, inputCh)
-		channels[i] = validateResCh
+// Имя первичного ключа таблицы accounts.
+const accountIDConstraint = "accounts_pkey"
+
+// Колонки таблицы accounts, в порядке чтения scanAccount.
+const accountColumns = `id, login, password_hash, created_at`
+
+// Чтение аккаунта из строки результата, колонки должны идти в порядке accountColumns.
+// Если строки нет, возвращается пустой аккаунт.
+func scanAccount(row pgx.Row) (domain.Account, error) {
+	var a domain.Account
+	err := row.Scan(&a.ID, &a.Login, &a.PasswordHash, &a.CreatedAt)
+	if _goerrors.Is(err, pgx.ErrNoRows) {
+		return domain.Account{}, nil
 	}
 
-	return channels
+	return a, err
 }
 
-// Валидируем пользователя и не было ли уже удалено ранее
-func (s *Postgres) validate(ctx context.Context, inputCh <-chan userUpdateItem) chan string {
-	validateRes := make(chan string, 1)
-	go func() {
-		defer close(validateRes)
-
-		for data := range inputCh {
+// Сохранение аккаунта. Если логин занят, возвращается ErrLoginTaken, если у пользователя уже есть аккаунт - ErrConflict.
+func (s *Postgres) StoreAccount(ctx context.Context, a domain.Account) error {
+	defer metrics.ObserveStorage(driverName, "StoreAccount", time.Now())
 
-			row := s.db.QueryRowContext(ctx, `SELECT id FROM shorts WHERE "user_id" = $1 AND "short_key" = $2 AND "is_deleted" = false`, data.UserID, data.ShortKey)
-			var id string
-			err := row.Scan(&id)
-			if err != nil {
-				s.logger.Errorw(`Error occured while scanning row`, err, `data`, data)
-				continue
-			}
+	_, err := s.db.Exec(ctx, `INSERT INTO accounts (`+accountColumns+`) VALUES ($1, $2, $3, $4)`, a.ID, a.Login, a.PasswordHash, a.CreatedAt)
 
-			select {
-			case <-ctx.Done():
-				return
-			case validateRes <- id:
-			}
+	var pgErr *pgconn.PgError
+	if _goerrors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		switch pgErr.ConstraintName {
+		case loginConstraint:
+			return errors.ErrLoginTaken
+		case accountIDConstraint:
+			return errors.ErrConflict
 		}
-	}()
+	}
+
+	if err != nil {
+		s.logger.Errorw(`Error occured while inserting account`, err)
+		return err
+	}
 
-	return validateRes
+	return nil
 }
 
-// fanIn объединяем каналы в результирующий канал
-// в сообщениях уже только те ID которые можно update
-func (s *Postgres) fanIn(ctx context.Context, resultChs ...chan string) chan string {
-	finalCh := make(chan string, 10)
+// Получение аккаунта по ID пользователя.
+func (s *Postgres) GetAccount(ctx context.Context, userID string) (domain.Account, error) {
+	defer metrics.ObserveStorage(driverName, "GetAccount", time.Now())
+
+	a, err := scanAccount(s.db.QueryRow(ctx, `SELECT `+accountColumns+` FROM accounts WHERE id = $1`, userID))
+	if err != nil {
+		s.logger.Errorw(`Error occured while selecting account`, err)
+		return domain.Account{}, err
+	}
+
+	return a, nil
+}
 
-	var wg sync.WaitGroup
+// Получение аккаунта по логину.
+func (s *Postgres) GetAccountByLogin(ctx context.Context, login string) (domain.Account, error) {
+	defer metrics.ObserveStorage(driverName, "GetAccountByLogin", time.Now())
 
-	for _, ch := range resultChs {
-		chClosure := ch
+	a, err := scanAccount(s.db.QueryRow(ctx, `SELECT `+accountColumns+` FROM accounts WHERE login = $1`, login))
+	if err != nil {
+		s.logger.Errorw(`Error occured while selecting account`, err)
+		return domain.Account{}, err
+	}
 
-		wg.Add(1)
+	return a, nil
+}
 
-		go func() {
-			defer wg.Done()
+// Перенос ссылок пользователя fromUserID пользователю toUserID одним запросом.
+func (s *Postgres) MergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	defer metrics.ObserveStorage(driverName, "MergeUserURLs", time.Now())
 
-			for data := range chClosure {
-				select {
-				case <-ctx.Done():
-					return
-				case finalCh <- data:
-				}
-			}
-		}()
+	tag, err := s.db.Exec(ctx, `UPDATE shorts SET user_id = $2 WHERE user_id = $1`, fromUserID, toUserID)
+	if err != nil {
+		s.logger.Errorw(`Error occured while merging user urls`, err)
+		return 0, err
 	}
 
-	go func() {
-		wg.Wait()
-		close(finalCh)
-	}()
+	return int(tag.RowsAffected()), nil
+}
+
+// Отзыв токена jti до expiresAt одним запросом: записи об отзыве истекших токенов удаляются,
+// запись об отзыве токена добавляется, если ее еще нет.
+func (s *Postgres) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "RevokeToken", time.Now())
+
+	tag, err := s.db.Exec(ctx, `
+		WITH purged AS (DELETE FROM revoked_tokens WHERE expires_at < $3)
+		INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt, time.Now(),
+	)
+	if err != nil {
+		s.logger.Errorw(`Error occured while revoking token`, err)
+		return false, err
+	}
 
-	return finalCh
+	return tag.RowsAffected() > 0, nil
 }
 
-// берем из канала список id на удаление и выполняем бач update
-func (s *Postgres) deleteBatchByIds(ctx context.Context, inputCh chan string) {
-	var idsToDelete []string
-	for idToDelete := range inputCh {
-		idsToDelete = append(idsToDelete, idToDelete)
+// Проверка, что токен jti отозван.
+func (s *Postgres) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	defer metrics.ObserveStorage(driverName, "IsTokenRevoked", time.Now())
+
+	var revoked bool
+	if err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`, jti).Scan(&revoked); err != nil {
+		s.logger.Errorw(`Error occured while checking revoked token`, err)
+		return false, err
 	}
 
-	if len(idsToDelete) == 0 {
-		return
+	return revoked, nil
+}
+
+// Резервирование size номеров счетчика последовательных ключей. Счетчик общий для всех экземпляров сервиса,
+// блоки номеров у них не пересекаются.
+func (s *Postgres) ReserveSequence(ctx context.Context, size uint64) (uint64, error) {
+	defer metrics.ObserveStorage(driverName, "ReserveSequence", time.Now())
+
+	var value int64
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO key_sequence (name, value) VALUES ('shorts', $1)
+		ON CONFLICT (name) DO UPDATE SET value = key_sequence.value + EXCLUDED.value
+		RETURNING value`,
+		int64(size),
+	).Scan(&value)
+	if err != nil {
+		s.logger.Errorw(`Error occured while reserving key sequence`, err)
+		return 0, err
 	}
 
-	// какое-то неведомое колдунство? Иначе where in не сделать
-	query, args, err := sqlx.In(`UPDATE shorts SET "is_deleted" = true WHERE id IN (?)`, idsToDelete)
+	return uint64(value), nil
+}
+
+// Сохранение задания на удаление.
+func (s *Postgres) StoreDeleteJob(ctx context.Context, job domain.DeleteJob) error {
+	defer metrics.ObserveStorage(driverName, "StoreDeleteJob", time.Now())
+
+	_, err := s.db.Exec(ctx, `INSERT INTO delete_jobs (id, user_id, shorts, created_at) VALUES ($1, $2, $3, $4)`,
+		job.ID, job.UserID, job.Shorts, job.CreatedAt,
+	)
 	if err != nil {
-		s.logger.Errorw(`Error occured while making updating query`, err, `idsToDelete`, idsToDelete)
-		return
+		s.logger.Errorw(`Error occured while storing delete job`, err)
+		return err
+	}
+
+	return nil
+}
+
+// Удаление выполненного или отброшенного задания на удаление.
+func (s *Postgres) RemoveDeleteJob(ctx context.Context, id string) error {
+	defer metrics.ObserveStorage(driverName, "RemoveDeleteJob", time.Now())
+
+	if _, err := s.db.Exec(ctx, `DELETE FROM delete_jobs WHERE id = $1`, id); err != nil {
+		s.logger.Errorw(`Error occured while removing delete job`, err)
+		return err
 	}
-	query = s.db.Rebind(query)
 
-	_, err = s.db.ExecContext(ctx, query, args...)
+	return nil
+}
+
+// Невыполненные задания на удаление в порядке постановки.
+func (s *Postgres) GetDeleteJobs(ctx context.Context) ([]domain.DeleteJob, error) {
+	defer metrics.ObserveStorage(driverName, "GetDeleteJobs", time.Now())
+
+	rows, err := s.db.Query(ctx, `SELECT id, user_id, shorts, created_at FROM delete_jobs ORDER BY created_at, id`)
+	if err != nil {
+		s.logger.Errorw(`Error occured while getting delete jobs`, err)
+		return nil, err
+	}
 
+	jobs, err := pgx.CollectRows(rows, pgx.RowToStructByPos[domain.DeleteJob])
 	if err != nil {
-		s.logger.Errorw(`Error occured while updating rows`, err, `query`, query)
-		return
+		s.logger.Errorw(`Error caused by rows fetch`, err)
+		return nil, err
 	}
+
+	return jobs, nil
 }
 
-// Получение рандомного ключа
-func (s *Postgres) GetRandkey(n uint) string {
-	return keygen.GetRandkey(n)
+// Пакетное удаление коротких ссылок одним запросом: удаляются неудаленные ссылки пользователя из пачки,
+// для остальных ключей по состоянию до удаления определяется, нет ли их, принадлежат ли они другому
+// пользователю или уже удалены. Результат удаления возвращается по каждому ключу. Ошибка запроса
+// возвращается, чтобы удаление можно было повторить.
+func (s *Postgres) DeleteBatch(ctx context.Context, userID string, pack []string) (map[string]domain.DeleteStatus, error) {
+	defer metrics.ObserveStorage(driverName, "DeleteBatch", time.Now())
+
+	rows, err := s.db.Query(ctx, `
+		WITH deleted AS (
+			UPDATE shorts SET "is_deleted" = true
+			WHERE "user_id" = $1 AND "short_key" = ANY($2::text[]) AND NOT "is_deleted"
+			RETURNING short_key
+		)
+		SELECT k.short_key,
+			CASE
+				WHEN d.short_key IS NOT NULL THEN 'deleted'
+				WHEN s.id IS NULL THEN 'not_found'
+				WHEN s.user_id <> $1 THEN 'not_owned'
+				ELSE 'already_deleted'
+			END
+		FROM (SELECT DISTINCT unnest($2::text[]) AS short_key) k
+		LEFT JOIN deleted d ON d.short_key = k.short_key
+		LEFT JOIN shorts s ON s.short_key = k.short_key`,
+		userID, pack,
+	)
+	if err != nil {
+		s.logger.Errorw(`Error occured while deleting rows`, err, `userID`, userID)
+		return nil, err
+	}
+
+	result := make(map[string]domain.DeleteStatus, len(pack))
+	var short string
+	var status domain.DeleteStatus
+	_, err = pgx.ForEachRow(rows, []any{&short, &status}, func() error {
+		result[short] = status
+		return nil
+	})
+	if err != nil {
+		s.logger.Errorw(`Error occured while deleting rows`, err, `userID`, userID)
+		return nil, err
+	}
+
+	return result, nil
 }
@@ -3,12 +3,16 @@ package postgres
 
 import (
 	_context "context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mikesvis/short/internal/context"
 	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/errors"
 	"github.com/mikesvis/short/internal/keygen"
 	"github.com/mikesvis/short/internal/logger"
 	"github.com/stretchr/testify/assert"
@@ -48,7 +52,7 @@ func TestNewPostgres(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			db, err := sqlx.Open("postgres", tt.args.databaseDSN)
+			db, err := pgxpool.New(_context.Background(), tt.args.databaseDSN)
 			require.NoError(t, err)
 			newStorage, err := NewPostgres(db, l)
 			require.NoError(t, err)
@@ -59,7 +63,7 @@ func TestNewPostgres(t *testing.T) {
 
 func TestPostgres_Store(t *testing.T) {
 	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
 	defer db.Close()
 
 	type args struct {
@@ -121,62 +125,11 @@ func TestPostgres_Store(t *testing.T) {
 	}
 }
 
-func TestFileDB_GetRandkey(t *testing.T) {
-	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
-	defer db.Close()
-	type want struct {
-		typeOf  string
-		len     int
-		isEmpty bool
-	}
-	tests := []struct {
-		name string
-		arg  uint
-		want want
-	}{
-		{
-			name: "Rand key is string of length",
-			arg:  5,
-			want: want{
-				typeOf:  "",
-				len:     5,
-				isEmpty: false,
-			},
-		}, {
-			name: "Rand key is empty sting of zero length",
-			arg:  0,
-			want: want{
-				typeOf:  "",
-				len:     0,
-				isEmpty: true,
-			},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			s := &Postgres{
-				db:     db,
-				logger: l,
-			}
-			randKey := s.GetRandkey(tt.arg)
-			assert.IsType(t, "", randKey)
-			assert.Len(t, randKey, tt.want.len)
-			if !tt.want.isEmpty {
-				assert.NotEmpty(t, s.GetRandkey(tt.arg))
-				return
-			}
-
-			assert.Empty(t, s.GetRandkey(tt.arg))
-		})
-	}
-}
-
 func TestPostgres_GetByFull(t *testing.T) {
 	rndString1 := keygen.GetRandkey(5)
 	rndString2 := keygen.GetRandkey(5)
 	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
 	defer db.Close()
 	s := &Postgres{
 		db:     db,
@@ -192,7 +145,7 @@ func TestPostgres_GetByFull(t *testing.T) {
 		},
 	)
 	type fields struct {
-		db     *sqlx.DB
+		db     *pgxpool.Pool
 		logger *zap.SugaredLogger
 	}
 	type args struct {
@@ -260,7 +213,7 @@ func TestPostgres_GetByShort(t *testing.T) {
 	rndString1 := keygen.GetRandkey(5)
 	rndString2 := keygen.GetRandkey(5)
 	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
 	defer db.Close()
 	s := &Postgres{
 		db:     db,
@@ -276,7 +229,7 @@ func TestPostgres_GetByShort(t *testing.T) {
 		},
 	)
 	type fields struct {
-		db     *sqlx.DB
+		db     *pgxpool.Pool
 		logger *zap.SugaredLogger
 	}
 	type args struct {
@@ -343,9 +296,9 @@ func TestPostgres_GetByShort(t *testing.T) {
 func TestPostgres_Ping(t *testing.T) {
 	ctx := _context.Background()
 	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
 	type fields struct {
-		db     *sqlx.DB
+		db     *pgxpool.Pool
 		logger *zap.SugaredLogger
 	}
 	type args struct {
@@ -386,7 +339,7 @@ func TestPostgres_Ping(t *testing.T) {
 
 func TestPostgres_StoreBatch(t *testing.T) {
 	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
 	type args struct {
 		ctx _context.Context
 		us  map[string]domain.URL
@@ -438,7 +391,7 @@ func TestPostgres_StoreBatch(t *testing.T) {
 
 func TestPostgres_Close(t *testing.T) {
 	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
 	tests := []struct {
 		name    string
 		wantErr bool
@@ -467,7 +420,7 @@ func TestPostgres_Close(t *testing.T) {
 func TestPostgres_GetUserURLs(t *testing.T) {
 	rndString1 := keygen.GetRandkey(5)
 	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
 	s := &Postgres{
 		db:     db,
 		logger: l,
@@ -550,27 +503,438 @@ func TestPostgres_GetUserURLs(t *testing.T) {
 
 func TestPostgres_DeleteBatch(t *testing.T) {
 	l, _ := logger.NewLogger()
-	db, _ := sqlx.Open("postgres", getDataBaseDSN())
-	type args struct {
-		ctx    _context.Context
-		userID string
-		pack   []string
-	}
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	own, other, gone := keygen.GetRandkey(8), keygen.GetRandkey(8), keygen.GetRandkey(8)
+	missing := keygen.GetRandkey(12)
+	for short, userID := range map[string]string{own: "DoomGuy", other: "Heretic", gone: "DoomGuy"} {
+		_, err = s.Store(ctx, domain.URL{UserID: userID, Full: `https://` + short + `.com`, Short: short})
+		require.NoError(t, err)
+	}
+	_, err = s.DeleteBatch(ctx, "DoomGuy", []string{gone})
+	require.NoError(t, err)
+
+	results, err := s.DeleteBatch(ctx, "DoomGuy", []string{own, other, gone, missing, own})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]domain.DeleteStatus{
+		own:     domain.DeleteStatusDeleted,
+		other:   domain.DeleteStatusNotOwned,
+		gone:    domain.DeleteStatusAlreadyDeleted,
+		missing: domain.DeleteStatusNotFound,
+	}, results)
+
 	tests := []struct {
-		name string
-		args args
+		name    string
+		short   string
+		deleted bool
 	}{
-		{
-			name: "It just works, dont ask...",
-		},
+		{name: "Own link is deleted", short: own, deleted: true},
+		{name: "Link of other user is kept", short: other, deleted: false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Postgres{
-				db:     db,
-				logger: l,
-			}
-			s.DeleteBatch(tt.args.ctx, tt.args.userID, tt.args.pack)
+			item, err := s.GetByShort(ctx, tt.short)
+			require.NoError(t, err)
+			assert.Equal(t, tt.deleted, item.Deleted)
 		})
 	}
 }
+
+// Сравнение удаления одним запросом с предыдущим конвейером из SELECT на каждый ключ.
+// База поднимается через docker compose up.
+func BenchmarkPostgres_DeleteBatch(b *testing.B) {
+	l, _ := logger.NewLogger()
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
+	defer db.Close()
+	ctx := _context.Background()
+	if err := db.Ping(ctx); err != nil {
+		b.Skip("postgres is not available: ", err)
+	}
+
+	s, err := NewPostgres(db, l)
+	require.NoError(b, err)
+
+	implementations := []struct {
+		name        string
+		deleteBatch func(ctx _context.Context, userID string, pack []string) (map[string]domain.DeleteStatus, error)
+	}{
+		{name: "set-based", deleteBatch: s.DeleteBatch},
+		{name: "pipeline", deleteBatch: s.pipelineDeleteBatch},
+	}
+	for _, size := range []int{10, 100, 1000} {
+		for _, impl := range implementations {
+			b.Run(fmt.Sprintf("%s/%d", impl.name, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					userID := keygen.GetRandkey(8)
+					pack := make(map[string]domain.URL, size)
+					shorts := make([]string, 0, size)
+					for j := 0; j < size; j++ {
+						short := keygen.GetRandkey(12)
+						pack[short] = domain.URL{UserID: userID, Full: `https://` + short + `.com`, Short: short}
+						shorts = append(shorts, short)
+					}
+					_, err := s.StoreBatch(ctx, pack)
+					require.NoError(b, err)
+					b.StartTimer()
+
+					_, err = impl.deleteBatch(ctx, userID, shorts)
+					require.NoError(b, err)
+				}
+			})
+		}
+	}
+}
+
+func TestPostgres_Expiration(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	now := time.Now()
+	short := keygen.GetRandkey(8)
+	full := `https://` + short + `.com`
+
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: full, Short: short, ExpiresAt: now.Add(-48 * time.Hour)})
+	require.NoError(t, err)
+
+	item, err := s.GetByShort(ctx, short)
+	require.NoError(t, err)
+	assert.True(t, item.Expired(now))
+
+	// истекшая ссылка заменяется новой вместо конфликта
+	replacement := keygen.GetRandkey(8)
+	stored, err := s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: full, Short: replacement, ExpiresAt: now.Add(-48 * time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, replacement, stored.Short)
+
+	// параллельные запросы заменяют истекшую ссылку один раз, остальные получают конфликт с ней
+	var wg sync.WaitGroup
+	results := make([]error, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, results[i] = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: full, Short: keygen.GetRandkey(8), ExpiresAt: now.Add(time.Hour)})
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: full, Short: keygen.GetRandkey(8)})
+	assert.ErrorIs(t, err, errors.ErrConflict)
+	replaced := 0
+	for _, err := range results {
+		if err == nil {
+			replaced++
+			continue
+		}
+		assert.ErrorIs(t, err, errors.ErrConflict)
+	}
+	assert.Equal(t, 1, replaced)
+
+	// возвращаем ссылке истекший срок для проверки удаления истекших
+	_, err = s.db.Exec(ctx, `UPDATE shorts SET short_key = $1, expires_at = $2 WHERE full_url = $3`, replacement, now.Add(-48*time.Hour), full)
+	require.NoError(t, err)
+
+	deleted, err := s.DeleteExpired(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, 1)
+
+	item, err = s.GetByShort(ctx, replacement)
+	require.NoError(t, err)
+	assert.Empty(t, item)
+}
+
+func TestPostgres_StoreBatchExpiration(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	now := time.Now()
+	short := keygen.GetRandkey(8)
+	full := `https://` + short + `.com`
+
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: full, Short: short, ExpiresAt: now.Add(-48 * time.Hour)})
+	require.NoError(t, err)
+
+	// параллельные пакеты заменяют истекшую ссылку один раз, остальные получают сохраненную ссылку без ошибки
+	var wg sync.WaitGroup
+	results := make([]map[string]domain.URL, 5)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = s.StoreBatch(ctx, map[string]domain.URL{
+				"1": {UserID: "DoomGuy", Full: full, Short: keygen.GetRandkey(8), ExpiresAt: now.Add(time.Hour)},
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	stored, err := s.GetByFull(ctx, full)
+	require.NoError(t, err)
+	assert.False(t, stored.Expired(now))
+	for i := range results {
+		require.NoError(t, errs[i])
+		assert.Equal(t, stored.Short, results[i]["1"].Short)
+	}
+}
+
+func TestPostgres_Click(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	short := keygen.GetRandkey(8)
+	_, err = s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: `https://` + short + `.com`, Short: short, MaxClicks: 3})
+	require.NoError(t, err)
+
+	// конкурентные переходы не превышают лимит
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	clicks := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Click(ctx, short)
+			if err == nil {
+				mu.Lock()
+				clicks++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, errors.ErrClickLimitReached)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, clicks)
+
+	item, err := s.Click(ctx, keygen.GetRandkey(12))
+	require.NoError(t, err)
+	assert.Empty(t, item)
+}
+
+func BenchmarkPostgres_GetByShort(b *testing.B) {
+	l, _ := logger.NewLogger()
+	db, _ := pgxpool.New(_context.Background(), getDataBaseDSN())
+	defer db.Close()
+	s := &Postgres{
+		db:     db,
+		logger: l,
+	}
+	ctx := _context.Background()
+	short := keygen.GetRandkey(5)
+	s.Store(ctx, domain.URL{
+		UserID: "DoomGuy",
+		Full:   `https://` + short + `.com`,
+		Short:  short,
+	})
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.GetByShort(ctx, short)
+		}
+	})
+}
+
+func TestPostgres_Count(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	urls, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	users, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+
+	short := keygen.GetRandkey(8)
+	_, err = s.Store(ctx, domain.URL{UserID: keygen.GetRandkey(8), Full: `https://` + short + `.com`, Short: short})
+	require.NoError(t, err)
+
+	newURLs, err := s.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, urls+1, newURLs)
+
+	newUsers, err := s.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, users+1, newUsers)
+}
+
+func TestPostgres_APIKeys(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	userID := uuid.NewString()
+	now := time.Now().UTC().Truncate(time.Second)
+	ci := domain.APIKey{ID: uuid.NewString(), UserID: userID, Name: "ci", Hash: keygen.GetRandkey(64), Scopes: []domain.APIKeyScope{domain.APIKeyScopeCreate, domain.APIKeyScopeRead}, CreatedAt: now}
+	bot := domain.APIKey{ID: uuid.NewString(), UserID: userID, Name: "bot", Hash: keygen.GetRandkey(64), CreatedAt: now.Add(time.Second)}
+	for _, key := range []domain.APIKey{bot, ci} {
+		require.NoError(t, s.StoreAPIKey(ctx, key))
+	}
+
+	key, err := s.GetAPIKeyByHash(ctx, ci.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, ci.Scopes, key.Scopes)
+	assert.True(t, ci.CreatedAt.Equal(key.CreatedAt))
+
+	keys, err := s.GetUserAPIKeys(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, []string{ci.ID, bot.ID}, []string{keys[0].ID, keys[1].ID})
+
+	revoked, err := s.RevokeAPIKey(ctx, uuid.NewString(), ci.ID)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	revoked, err = s.RevokeAPIKey(ctx, userID, ci.ID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	key, err = s.GetAPIKeyByHash(ctx, ci.Hash)
+	require.NoError(t, err)
+	assert.Empty(t, key)
+}
+
+func TestPostgres_Accounts(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	anonymousID, userID := uuid.NewString(), uuid.NewString()
+	for _, short := range []string{keygen.GetRandkey(8), keygen.GetRandkey(8)} {
+		_, err = s.Store(ctx, domain.URL{UserID: anonymousID, Full: `https://` + short + `.com`, Short: short})
+		require.NoError(t, err)
+	}
+
+	login := keygen.GetRandkey(12)
+	a := domain.Account{ID: userID, Login: login, PasswordHash: "hash", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	require.NoError(t, s.StoreAccount(ctx, a))
+	assert.ErrorIs(t, s.StoreAccount(ctx, domain.Account{ID: uuid.NewString(), Login: login, CreatedAt: time.Now()}), errors.ErrLoginTaken)
+	assert.ErrorIs(t, s.StoreAccount(ctx, domain.Account{ID: userID, Login: keygen.GetRandkey(12), CreatedAt: time.Now()}), errors.ErrConflict)
+
+	found, err := s.GetAccountByLogin(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, a.ID, found.ID)
+	assert.True(t, a.CreatedAt.Equal(found.CreatedAt))
+
+	found, err = s.GetAccount(ctx, anonymousID)
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	merged, err := s.MergeUserURLs(ctx, anonymousID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, merged)
+
+	urls, err := s.GetUserURLs(ctx, userID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func TestPostgres_RevokeToken(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	expired, jti := uuid.NewString(), uuid.NewString()
+
+	revoked, err := s.RevokeToken(ctx, expired, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = s.RevokeToken(ctx, jti, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = s.RevokeToken(ctx, jti, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	isRevoked, err := s.IsTokenRevoked(ctx, jti)
+	require.NoError(t, err)
+	assert.True(t, isRevoked)
+
+	isRevoked, err = s.IsTokenRevoked(ctx, expired)
+	require.NoError(t, err)
+	assert.False(t, isRevoked)
+}
+
+func TestPostgres_ReserveSequence(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	first, err := s.ReserveSequence(ctx, 1000)
+	require.NoError(t, err)
+
+	// блоки не пересекаются и счетчик только растет
+	second, err := s.ReserveSequence(ctx, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, first+1000, second)
+}
+
+func TestPostgres_DeleteJobs(t *testing.T) {
+	l, _ := logger.NewLogger()
+	db, err := pgxpool.New(_context.Background(), getDataBaseDSN())
+	require.NoError(t, err)
+	s, err := NewPostgres(db, l)
+	require.NoError(t, err)
+
+	ctx := _context.Background()
+	job := domain.DeleteJob{ID: uuid.NewString(), UserID: "DoomGuy", Shorts: []string{"iddqd", "idkfa"}, CreatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	require.NoError(t, s.StoreDeleteJob(ctx, job))
+
+	// найденное задание с ID job.ID
+	find := func() (domain.DeleteJob, bool) {
+		jobs, err := s.GetDeleteJobs(ctx)
+		require.NoError(t, err)
+		for _, v := range jobs {
+			if v.ID == job.ID {
+				return v, true
+			}
+		}
+		return domain.DeleteJob{}, false
+	}
+
+	stored, exists := find()
+	require.True(t, exists)
+	assert.Equal(t, job.UserID, stored.UserID)
+	assert.Equal(t, job.Shorts, stored.Shorts)
+	assert.True(t, job.CreatedAt.Equal(stored.CreatedAt))
+
+	require.NoError(t, s.RemoveDeleteJob(ctx, job.ID))
+	require.NoError(t, s.RemoveDeleteJob(ctx, job.ID))
+	_, exists = find()
+	assert.False(t, exists)
+}
@@ -11,3 +11,45 @@ var ErrEmptyUserID = _goerrors.New("empty user id")
 
 // Неправильный токен
 var ErrInvalidToken = _goerrors.New("invalid token")
+
+// Файл storage уже используется другим процессом.
+var ErrFileLocked = _goerrors.New("file storage is locked by another process")
+
+// Короткий ключ уже занят другой ссылкой.
+var ErrShortKeyConflict = _goerrors.New("short key already exists")
+
+// Алиас уже занят другой ссылкой.
+var ErrAliasTaken = _goerrors.New("alias is already taken")
+
+// Исчерпан лимит переходов по ссылке.
+var ErrClickLimitReached = _goerrors.New("click limit reached")
+
+// Ссылка не найдена.
+var ErrNotFound = _goerrors.New("full url is not found")
+
+// Ссылка удалена, истекла или исчерпала лимит переходов.
+var ErrGone = _goerrors.New("url is gone")
+
+// Операция не поддерживается storage.
+var ErrNotSupported = _goerrors.New("not supported")
+
+// Очередь удаления переполнена.
+var ErrDeleteQueueFull = _goerrors.New("delete queue is full")
+
+// Очередь удаления остановлена и новых заданий не принимает.
+var ErrDeleteQueueStopped = _goerrors.New("delete queue is stopped")
+
+// Токен подписан неизвестным ключом.
+var ErrUnknownKeyID = _goerrors.New("unknown signing key id")
+
+// Ключ подписи токенов не задан.
+var ErrNoSigningKey = _goerrors.New("jwt signing key is not configured")
+
+// Логин уже занят другим аккаунтом.
+var ErrLoginTaken = _goerrors.New("login is already taken")
+
+// Токен отозван.
+var ErrTokenRevoked = _goerrors.New("token is revoked")
+
+// Токена авторизации нет.
+var ErrTokenMissing = _goerrors.New("authorization token is missing")
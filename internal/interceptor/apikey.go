@@ -0,0 +1,63 @@
+// Модуль авторизации по API ключу в gRPC сервере приложения.
+package interceptor
+
+import (
+	_context "context"
+
+	"github.com/mikesvis/short/internal/apikey"
+	"github.com/mikesvis/short/internal/context"
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Ключ метаданных с API ключом: заголовок apikey.HeaderName в нижнем регистре.
+const APIKeyMetadataKey = "x-api-key"
+
+// Авторизация по API ключу из метаданных APIKeyMetadataKey с проверкой права scope для методов methods.
+// Вызов без ключа передается дальше без изменений, его авторизуют SignIn/Auth по токену. С ключом он
+// должен существовать (иначе Unauthenticated) и иметь право scope (иначе PermissionDenied), тогда ID
+// пользователя ключа пишется в контекст и SignIn/Auth пропускают вызов без токена.
+func APIKey(s storage.Storage, scope domain.APIKeyScope, methods ...string) grpc.UnaryServerInterceptor {
+	only := methodSet(methods)
+
+	return func(ctx _context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if _, exists := only[info.FullMethod]; !exists {
+			return handler(ctx, req)
+		}
+
+		plain := MetadataValue(ctx, APIKeyMetadataKey)
+		if len(plain) == 0 {
+			return handler(ctx, req)
+		}
+
+		keyer, isKeyer := s.(storage.StorageAPIKeyer)
+		if !isKeyer {
+			return nil, status.Error(codes.Unauthenticated, "unknown api key")
+		}
+
+		key, err := keyer.GetAPIKeyByHash(ctx, apikey.Hash(plain))
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		// ключ неизвестен или отозван
+		if len(key.ID) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "unknown api key")
+		}
+
+		if !key.Allows(scope) {
+			return nil, status.Errorf(codes.PermissionDenied, "api key has no %s scope", scope)
+		}
+
+		return handler(_context.WithValue(ctx, context.UserIDContextKey, key.UserID), req)
+	}
+}
+
+// authorized - ID пользователя уже в контексте, например по API ключу.
+func authorized(ctx _context.Context) bool {
+	_, exists := ctx.Value(context.UserIDContextKey).(string)
+	return exists
+}
@@ -0,0 +1,98 @@
+// Модуль авторизации в gRPC сервере приложения.
+package interceptor
+
+import (
+	_context "context"
+
+	"github.com/mikesvis/short/internal/context"
+	"github.com/mikesvis/short/internal/session"
+	"github.com/mikesvis/short/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Ключ метаданных с токеном авторизации: имя куки jwt.AuthorizationCookieName в нижнем регистре.
+const AuthorizationMetadataKey = "authorization-jwt"
+
+// Ключ метаданных с refresh токеном: имя куки jwt.RefreshCookieName в нижнем регистре.
+const RefreshMetadataKey = "refresh-jwt"
+
+// Регистрация по токену из метаданных AuthorizationMetadataKey для методов methods. Если токена нет,
+// создается новый пользователь. Истекший токен обновляется по refresh токену из метаданных RefreshMetadataKey,
+// невалидный, истекший без refresh токена или отозванный токен получает Unauthenticated (session.SignIn). Новая пара токенов
+// возвращается в заголовке ответа. ID пользователя прописывается в контекст. Вызов, уже авторизованный
+// по API ключу, пропускается.
+func SignIn(s storage.Storage, methods ...string) grpc.UnaryServerInterceptor {
+	only := methodSet(methods)
+
+	return func(ctx _context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if _, exists := only[info.FullMethod]; !exists || authorized(ctx) {
+			return handler(ctx, req)
+		}
+
+		userID, issued, err := session.SignIn(ctx, s, MetadataValue(ctx, AuthorizationMetadataKey), MetadataValue(ctx, RefreshMetadataKey))
+		if err != nil {
+			return nil, authError(err)
+		}
+
+		if issued != nil {
+			if err = issueTokens(ctx, *issued); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+
+		return handler(_context.WithValue(ctx, context.UserIDContextKey, userID), req)
+	}
+}
+
+// Авторизация по токену из метаданных AuthorizationMetadataKey для методов methods. Истекший токен
+// обновляется по refresh токену из метаданных RefreshMetadataKey, новая пара токенов возвращается
+// в заголовке ответа. ID пользователя прописывается в контекст. Вызов, уже авторизованный по API ключу,
+// пропускается.
+func Auth(s storage.Storage, methods ...string) grpc.UnaryServerInterceptor {
+	only := methodSet(methods)
+
+	return func(ctx _context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if _, exists := only[info.FullMethod]; !exists || authorized(ctx) {
+			return handler(ctx, req)
+		}
+
+		userID, issued, err := session.Authenticate(ctx, s, MetadataValue(ctx, AuthorizationMetadataKey), MetadataValue(ctx, RefreshMetadataKey))
+		if err != nil {
+			return nil, authError(err)
+		}
+
+		if issued != nil {
+			if err = issueTokens(ctx, *issued); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+
+		return handler(_context.WithValue(ctx, context.UserIDContextKey, userID), req)
+	}
+}
+
+// issueTokens возвращает пару токенов в заголовке ответа.
+func issueTokens(ctx _context.Context, tokens session.Tokens) error {
+	return grpc.SetHeader(ctx, metadata.Pairs(AuthorizationMetadataKey, tokens.Access, RefreshMetadataKey, tokens.Refresh))
+}
+
+// authError - статус для ошибки авторизации: проблема с токеном клиента - Unauthenticated, остальное - Internal.
+func authError(err error) error {
+	if session.Unauthorized(err) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+func methodSet(methods []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		set[m] = struct{}{}
+	}
+
+	return set
+}
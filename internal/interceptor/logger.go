@@ -0,0 +1,27 @@
+// Модуль логирования запросов в gRPC сервере приложения.
+package interceptor
+
+import (
+	_context "context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Логирование запросов, статуса ответа и времени обработки.
+func Logger(log *zap.SugaredLogger) grpc.UnaryServerInterceptor {
+	return func(ctx _context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		log.Infow(
+			"Incoming gRPC request",
+			"method", info.FullMethod,
+			"status", status.Code(err).String(),
+			"duration", time.Since(start),
+		)
+
+		return resp, err
+	}
+}
@@ -0,0 +1,22 @@
+package interceptor
+
+import (
+	_context "context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Первое значение входящих метаданных запроса по ключу key или пустая строка.
+func MetadataValue(ctx _context.Context, key string) string {
+	md, exists := metadata.FromIncomingContext(ctx)
+	if !exists {
+		return ""
+	}
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
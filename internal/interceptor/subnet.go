@@ -0,0 +1,39 @@
+// Модуль ограничения доступа по доверенной подсети в gRPC сервере приложения.
+package interceptor
+
+import (
+	_context "context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Ключ метаданных с IP клиента, повторяет заголовок X-Real-IP.
+const RealIPMetadataKey = "x-real-ip"
+
+// Доступ к методам methods только для клиентов из доверенной подсети trustedSubnet в CIDR нотации.
+// IP клиента берется из метаданных RealIPMetadataKey. Если подсеть не задана или IP не входит в нее,
+// отдается PermissionDenied.
+func TrustedSubnet(trustedSubnet string, methods ...string) grpc.UnaryServerInterceptor {
+	only := methodSet(methods)
+	_, subnet, err := net.ParseCIDR(trustedSubnet)
+	if err != nil {
+		subnet = nil
+	}
+
+	return func(ctx _context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if _, exists := only[info.FullMethod]; !exists {
+			return handler(ctx, req)
+		}
+
+		ip := net.ParseIP(MetadataValue(ctx, RealIPMetadataKey))
+
+		if subnet == nil || ip == nil || !subnet.Contains(ip) {
+			return nil, status.Error(codes.PermissionDenied, "client is not in trusted subnet")
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -2,63 +2,159 @@
 package jwt
 
 import (
+	"fmt"
 	"time"
 
 	_jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/mikesvis/short/internal/errors"
 )
 
-// SecretPass используется для шифровки/дешифровки.
-const SecretPass = "mySecretPass"
-
 // Имя куки авторизации.
 const AuthorizationCookieName = "Authorization-JWT"
 
-// Время жизни куки авторизации.
+// Имя заголовка авторизации.
+const AuthorizationHeaderName = "Authorization"
+
+// Схема авторизации в заголовке AuthorizationHeaderName.
+const BearerScheme = "Bearer"
+
+// Имя куки с refresh токеном.
+const RefreshCookieName = "Refresh-JWT"
+
+// Имя заголовка с refresh токеном.
+const RefreshHeaderName = "X-Refresh-Token"
+
+// Время жизни токена доступа.
+const AccessTokenDuration = 15 * time.Minute
+
+// Время жизни refresh токена, оно же максимальное время жизни любого токена.
 const TokenDuration = time.Hour * 24 * 30
 
+// Тип токена.
+type TokenType string
+
+const (
+	// Токен доступа, им авторизуются запросы.
+	AccessToken TokenType = "access"
+
+	// Refresh токен, на него выдается новая пара токенов.
+	RefreshToken TokenType = "refresh"
+)
+
 // Claims в JWT.
 type Claims struct {
 	// ID пользователя.
 	UserID string `json:"userId"`
+
+	// Тип токена. Токены без типа выданы до появления refresh токенов и считаются токенами доступа.
+	Type TokenType `json:"typ,omitempty"`
+
 	_jwt.RegisteredClaims
 }
 
-// Получение ID пользователя из токена.
+// Получение ID пользователя из токена доступа.
 func GetUserIDFromTokenString(tokenString string) (string, error) {
+	claims, err := ParseToken(tokenString, AccessToken)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.UserID, nil
+}
+
+// Разбор токена типа typ. Подпись проверяется ключом из заголовка kid, токен без kid или с неизвестным kid
+// считается токеном с неверной подписью, неизвестный kid дополнительно помечается ErrUnknownKeyID.
+// Токен другого типа возвращает ErrInvalidToken.
+func ParseToken(tokenString string, typ TokenType) (*Claims, error) {
+	claims, err := parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.tokenType() != typ {
+		return nil, fmt.Errorf("%w: %s token is expected, got %s", errors.ErrInvalidToken, typ, claims.tokenType())
+	}
+
+	return claims, nil
+}
+
+// Разбор токена любого типа с проверкой подписи, но без проверки срока. Нужен для отзыва токенов:
+// истекший токен отзывать не нужно, но это решает вызывающий.
+func ParseSignedToken(tokenString string) (*Claims, error) {
+	return parse(tokenString, _jwt.WithoutClaimsValidation())
+}
+
+// parse разбирает токен и проверяет подпись, срок (если не отключен options) и наличие ID пользователя.
+func parse(tokenString string, options ..._jwt.ParserOption) (*Claims, error) {
 	claims := &Claims{}
 
+	options = append(options, _jwt.WithValidMethods([]string{_jwt.SigningMethodHS256.Alg()}))
 	token, err := _jwt.ParseWithClaims(tokenString, claims, func(token *_jwt.Token) (any, error) {
-		return []byte(SecretPass), nil
-	})
-
-	// все хорошо в куке, не трогаем
-	if err == nil && token.Valid {
-		// пустой UserID в токене (по заданию)
-		if len(claims.UserID) == 0 {
-			return "", errors.ErrEmptyUserID
+		kid, _ := token.Header["kid"].(string)
+		if len(kid) == 0 {
+			return nil, fmt.Errorf("%w: token has no key id", _jwt.ErrSignatureInvalid)
+		}
+
+		// kid есть, но ключ неизвестен: например, другой экземпляр сервиса еще не перечитал файл ключей
+		secret, exists := keySecret(kid)
+		if !exists {
+			return nil, fmt.Errorf("%w: %w %q", _jwt.ErrSignatureInvalid, errors.ErrUnknownKeyID, kid)
 		}
 
-		return claims.UserID, nil
+		return secret, nil
+	}, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.ErrInvalidToken
 	}
 
-	if err == nil && !token.Valid {
-		return "", errors.ErrInvalidToken
+	// пустой UserID в токене (по заданию)
+	if len(claims.UserID) == 0 {
+		return nil, errors.ErrEmptyUserID
+	}
+
+	return claims, nil
+}
+
+// Тип токена с учетом токенов без типа.
+func (c *Claims) tokenType() TokenType {
+	if len(c.Type) == 0 {
+		return AccessToken
 	}
 
-	return "", err
+	return c.Type
 }
 
-// Создание токена авторизации.
+// Создание токена доступа.
 func CreateTokenString(userID string, exp time.Time) (string, error) {
+	return CreateToken(userID, AccessToken, exp)
+}
+
+// Создание токена типа typ, подписанного активным ключом, ID ключа передается в заголовке kid.
+// Каждый токен получает уникальный ID (jti), по нему токен отзывается.
+func CreateToken(userID string, typ TokenType, exp time.Time) (string, error) {
 	claims := &Claims{
 		UserID: userID,
+		Type:   typ,
 		RegisteredClaims: _jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  _jwt.NewNumericDate(time.Now()),
 			ExpiresAt: _jwt.NewNumericDate(exp),
 		},
 	}
+	kid, secret := activeKey()
+	if len(secret) == 0 {
+		return "", errors.ErrNoSigningKey
+	}
+
 	token := _jwt.NewWithClaims(_jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(SecretPass))
+	token.Header["kid"] = kid
+	tokenString, err := token.SignedString(secret)
 	if err != nil {
 		return "", err
 	}
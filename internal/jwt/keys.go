@@ -0,0 +1,303 @@
+package jwt
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Период проверки изменения файла ключей.
+const KeysReloadInterval = 10 * time.Second
+
+// Задержка, через которую новый ключ после ротации начинает подписывать токены. До этого ключ только
+// проверяет токены: все экземпляры сервиса успевают перечитать файл ключей и узнать новый kid.
+const KeyActivationDelay = 2 * KeysReloadInterval
+
+// Длина генерируемого секрета ключа в байтах.
+const secretLength = 32
+
+// Ключ подписи токенов.
+type Key struct {
+	// ID ключа, передается в заголовке kid токена.
+	ID string `json:"id"`
+
+	// Секрет ключа в base64.
+	Secret string `json:"secret"`
+
+	// Время создания ключа.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Время, с которого ключ подписывает токены, до него ключ только проверяет токены.
+	// Нулевое время - ключ подписывает сразу.
+	ActivatesAt time.Time `json:"activates_at,omitempty"`
+
+	// Время вывода ключа из оборота, nil - ключ не выводился.
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// Набор ключей: активным ключом подписываются новые токены, токены проверяются любым ключом набора по kid.
+type KeySet struct {
+	// ID активного ключа. Пока активный ключ не начал подписывать (ActivatesAt), подписывает
+	// ключ, начавший подписывать последним.
+	Active string `json:"active"`
+
+	// Ключи набора.
+	Keys []Key `json:"keys"`
+}
+
+// Текущий набор ключей. По-умолчанию ключей нет и токены не создаются: ключ задается при старте сервиса.
+var keys = struct {
+	sync.RWMutex
+	set     KeySet
+	secrets map[string][]byte
+}{}
+
+// Установка набора ключей для подписи и проверки токенов. Набор проверяется на корректность.
+func SetKeys(set KeySet) error {
+	secrets, err := set.secrets()
+	if err != nil {
+		return err
+	}
+
+	keys.Lock()
+	defer keys.Unlock()
+
+	keys.set = set
+	keys.secrets = secrets
+
+	return nil
+}
+
+// ID и секрет ключа, подписывающего токены сейчас. Если ключи не заданы, секрет пустой.
+func activeKey() (string, []byte) {
+	keys.RLock()
+	defer keys.RUnlock()
+
+	id := keys.set.SigningKey(time.Now())
+	return id, keys.secrets[id]
+}
+
+// Секрет ключа id.
+func keySecret(id string) ([]byte, bool) {
+	keys.RLock()
+	defer keys.RUnlock()
+
+	secret, exists := keys.secrets[id]
+	return secret, exists
+}
+
+// Генерация нового ключа со случайными ID и секретом.
+func GenerateKey(now time.Time) (Key, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, err
+	}
+
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, err
+	}
+
+	return Key{
+		ID:        hex.EncodeToString(id),
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+		CreatedAt: now.UTC(),
+	}, nil
+}
+
+// Набор из одного ключа с секретом secret. ID ключа вычисляется по секрету, поэтому совпадает
+// у всех экземпляров сервиса с одинаковым секретом.
+func KeySetFromSecret(secret string) KeySet {
+	hash := sha256.Sum256([]byte(secret))
+	id := hex.EncodeToString(hash[:4])
+
+	return KeySet{
+		Active: id,
+		Keys:   []Key{{ID: id, Secret: base64.StdEncoding.EncodeToString([]byte(secret))}},
+	}
+}
+
+// Загрузка набора ключей из файла path.
+func LoadKeySet(path string) (KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return KeySet{}, err
+	}
+
+	var set KeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return KeySet{}, fmt.Errorf("invalid keys file %s: %w", path, err)
+	}
+
+	if _, err := set.secrets(); err != nil {
+		return KeySet{}, fmt.Errorf("invalid keys file %s: %w", path, err)
+	}
+
+	return set, nil
+}
+
+// Сохранение набора ключей в файл path. Файл заменяется целиком, чтобы сервис не прочитал его частично.
+func (k KeySet) Save(path string) error {
+	data, err := json.MarshalIndent(k, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(append(data, '\n')); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// Ротация ключей в два шага: новый ключ становится активным, но подписывает токены только через
+// KeyActivationDelay, до этого он лишь проверяет токены, а подписывает прежний активный ключ. Прежний ключ
+// выводится из оборота в момент активации нового, но остается для проверки уже выданных токенов.
+// Первый ключ набора подписывает сразу. Ключи, выведенные раньше чем TokenDuration назад, удаляются:
+// подписанные ими токены уже истекли.
+func (k *KeySet) Rotate(now time.Time) (Key, error) {
+	key, err := GenerateKey(now)
+	if err != nil {
+		return Key{}, err
+	}
+
+	now = now.UTC()
+	if len(k.Keys) > 0 {
+		key.ActivatesAt = now.Add(KeyActivationDelay)
+	}
+
+	kept := make([]Key, 0, len(k.Keys)+1)
+	for _, v := range k.Keys {
+		if v.ID == k.Active && v.RetiredAt == nil {
+			retiredAt := key.ActivatesAt
+			v.RetiredAt = &retiredAt
+		}
+
+		if v.RetiredAt != nil && now.Sub(*v.RetiredAt) > TokenDuration {
+			continue
+		}
+
+		kept = append(kept, v)
+	}
+
+	k.Keys = append(kept, key)
+	k.Active = key.ID
+
+	return key, nil
+}
+
+// ID ключа, подписывающего токены в момент now: активный ключ, если он уже начал подписывать,
+// иначе ключ, начавший подписывать последним (при равенстве - созданный последним).
+func (k KeySet) SigningKey(now time.Time) string {
+	var signing *Key
+	for i, v := range k.Keys {
+		if v.ActivatesAt.After(now) {
+			continue
+		}
+
+		if v.ID == k.Active {
+			return v.ID
+		}
+
+		if signing == nil || v.ActivatesAt.After(signing.ActivatesAt) ||
+			(v.ActivatesAt.Equal(signing.ActivatesAt) && v.CreatedAt.After(signing.CreatedAt)) {
+			signing = &k.Keys[i]
+		}
+	}
+
+	if signing == nil {
+		return k.Active
+	}
+
+	return signing.ID
+}
+
+// Секреты ключей набора по ID. Набор должен содержать активный ключ, ID ключей не должны повторяться.
+func (k KeySet) secrets() (map[string][]byte, error) {
+	secrets := make(map[string][]byte, len(k.Keys))
+	for _, key := range k.Keys {
+		if len(key.ID) == 0 {
+			return nil, fmt.Errorf("key id is empty")
+		}
+
+		if _, exists := secrets[key.ID]; exists {
+			return nil, fmt.Errorf("key id %s is duplicated", key.ID)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("secret of key %s is invalid", key.ID)
+		}
+
+		secrets[key.ID] = secret
+	}
+
+	if _, exists := secrets[k.Active]; !exists {
+		return nil, fmt.Errorf("active key %q is not found", k.Active)
+	}
+
+	return secrets, nil
+}
+
+// Перезагрузка ключей из файла path при его изменении, проверка каждые interval до отмены контекста.
+// Некорректный файл не применяется, продолжают работать прежние ключи.
+func WatchKeysFile(ctx context.Context, path string, interval time.Duration, logger *zap.SugaredLogger) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				logger.Errorw(`Error occured while checking keys file`, err, `path`, path)
+				continue
+			}
+
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+
+			set, err := LoadKeySet(path)
+			if err == nil {
+				err = SetKeys(set)
+			}
+
+			if err != nil {
+				logger.Errorw(`Error occured while reloading keys file`, err, `path`, path)
+				continue
+			}
+
+			modTime = info.ModTime()
+			logger.Infow(`Signing keys reloaded`, `active`, set.Active, `keys`, len(set.Keys))
+		}
+	}
+}
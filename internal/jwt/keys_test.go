@@ -0,0 +1,198 @@
+package jwt
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	_jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Установка набора ключей на время теста.
+func setTestKeys(t *testing.T, set KeySet) {
+	keys.RLock()
+	previous := keys.set
+	keys.RUnlock()
+	t.Cleanup(func() { SetKeys(previous) })
+
+	require.NoError(t, SetKeys(set))
+}
+
+// ID ключа из заголовка kid токена.
+func tokenKeyID(t *testing.T, tokenString string) string {
+	parsed, _, err := _jwt.NewParser().ParseUnverified(tokenString, &Claims{})
+	require.NoError(t, err)
+
+	return parsed.Header["kid"].(string)
+}
+
+func TestRotation(t *testing.T) {
+	var set KeySet
+	first, err := set.Rotate(time.Now())
+	require.NoError(t, err)
+	setTestKeys(t, set)
+
+	oldToken, err := CreateTokenString("DoomGuy", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, tokenKeyID(t, oldToken))
+
+	// новый ключ сначала только проверяет токены, подписывает прежний
+	second, err := set.Rotate(time.Now())
+	require.NoError(t, err)
+	require.NoError(t, SetKeys(set))
+
+	pendingToken, err := CreateTokenString("Imp", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, tokenKeyID(t, pendingToken))
+	assert.Equal(t, second.ID, set.SigningKey(time.Now().Add(KeyActivationDelay)))
+
+	// после активации подписывает новый ключ
+	set.Keys[len(set.Keys)-1].ActivatesAt = time.Now().Add(-time.Second)
+	require.NoError(t, SetKeys(set))
+
+	newToken, err := CreateTokenString("Heretic", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, tokenKeyID(t, newToken))
+
+	// после ротации выданные токены остаются валидными
+	userID, err := GetUserIDFromTokenString(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, "DoomGuy", userID)
+
+	userID, err = GetUserIDFromTokenString(pendingToken)
+	require.NoError(t, err)
+	assert.Equal(t, "Imp", userID)
+
+	userID, err = GetUserIDFromTokenString(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, "Heretic", userID)
+}
+
+func TestGetUserIDFromTokenString_Keys(t *testing.T) {
+	setTestKeys(t, KeySetFromSecret("iddqd"))
+	exp := _jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	sign := func(kid string, secret string) string {
+		token := _jwt.NewWithClaims(_jwt.SigningMethodHS256, &Claims{UserID: "DoomGuy", RegisteredClaims: _jwt.RegisteredClaims{ExpiresAt: exp}})
+		if len(kid) > 0 {
+			token.Header["kid"] = kid
+		}
+		tokenString, err := token.SignedString([]byte(secret))
+		require.NoError(t, err)
+
+		return tokenString
+	}
+	kid := KeySetFromSecret("iddqd").Active
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr []error
+	}{
+		{name: "Known key", token: sign(kid, "iddqd")},
+		{name: "Unknown key id", token: sign("idkfa", "iddqd"), wantErr: []error{_jwt.ErrSignatureInvalid, errors.ErrUnknownKeyID}},
+		{name: "No key id", token: sign("", "mySecretPass"), wantErr: []error{_jwt.ErrSignatureInvalid}},
+		{name: "Wrong secret", token: sign(kid, "idkfa"), wantErr: []error{_jwt.ErrSignatureInvalid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := GetUserIDFromTokenString(tt.token)
+			for _, wantErr := range tt.wantErr {
+				assert.ErrorIs(t, err, wantErr)
+			}
+			if len(tt.wantErr) == 0 {
+				require.NoError(t, err)
+				assert.Equal(t, "DoomGuy", userID)
+			}
+		})
+	}
+}
+
+func TestKeySet_Rotate(t *testing.T) {
+	now := time.Now()
+	var set KeySet
+	first, err := set.Rotate(now.Add(-2 * TokenDuration))
+	require.NoError(t, err)
+	second, err := set.Rotate(now.Add(-TokenDuration - time.Hour))
+	require.NoError(t, err)
+	third, err := set.Rotate(now.Add(-time.Hour))
+	require.NoError(t, err)
+	fourth, err := set.Rotate(now)
+	require.NoError(t, err)
+
+	// первый ключ выведен раньше TokenDuration назад и удален, остальные нужны для выданных токенов
+	ids := make([]string, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		ids = append(ids, key.ID)
+	}
+	assert.Equal(t, []string{second.ID, third.ID, fourth.ID}, ids)
+	assert.NotContains(t, ids, first.ID)
+	assert.Equal(t, fourth.ID, set.Active)
+	assert.NotNil(t, set.Keys[0].RetiredAt)
+	assert.NotNil(t, set.Keys[1].RetiredAt)
+	assert.Nil(t, set.Keys[2].RetiredAt)
+
+	// первый ключ набора подписывает сразу, следующие - через KeyActivationDelay
+	assert.True(t, first.ActivatesAt.IsZero())
+	assert.Equal(t, now.UTC().Add(KeyActivationDelay), fourth.ActivatesAt)
+	assert.Equal(t, fourth.ActivatesAt, *set.Keys[1].RetiredAt)
+	assert.Equal(t, third.ID, set.SigningKey(now))
+	assert.Equal(t, fourth.ID, set.SigningKey(now.Add(KeyActivationDelay)))
+}
+
+func TestCreateToken_NoKeys(t *testing.T) {
+	keys.RLock()
+	previous := keys.set
+	keys.RUnlock()
+	t.Cleanup(func() { SetKeys(previous) })
+
+	keys.Lock()
+	keys.set, keys.secrets = KeySet{}, nil
+	keys.Unlock()
+
+	_, err := CreateTokenString("DoomGuy", time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, errors.ErrNoSigningKey)
+}
+
+func TestKeySet_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	var set KeySet
+	_, err := set.Rotate(time.Now())
+	require.NoError(t, err)
+
+	require.NoError(t, set.Save(path))
+	loaded, err := LoadKeySet(path)
+	require.NoError(t, err)
+	assert.Equal(t, set.Active, loaded.Active)
+	assert.Equal(t, set.Keys[0].Secret, loaded.Keys[0].Secret)
+}
+
+func TestSetKeys(t *testing.T) {
+	key, err := GenerateKey(time.Now())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		set     KeySet
+		wantErr bool
+	}{
+		{name: "Valid set", set: KeySet{Active: key.ID, Keys: []Key{key}}},
+		{name: "Missing active key", set: KeySet{Active: "idkfa", Keys: []Key{key}}, wantErr: true},
+		{name: "Duplicated key id", set: KeySet{Active: key.ID, Keys: []Key{key, key}}, wantErr: true},
+		{name: "Invalid secret", set: KeySet{Active: "idkfa", Keys: []Key{{ID: "idkfa", Secret: "not base64!"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestKeys(t, KeySetFromSecret("iddqd"))
+			err := SetKeys(tt.set)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package keygen
+
+import (
+	_goerrors "errors"
+	"sync"
+
+	"github.com/mikesvis/short/internal/alias"
+	"github.com/mikesvis/short/internal/errors"
+)
+
+const (
+	// Максимальное количество попыток подобрать свободный ключ.
+	MaxAttempts = 10
+
+	// Максимальная длина ключа, дальше аллокатор не растет.
+	MaxKeyLength = 16
+
+	// Вес последней попытки в скользящем среднем доли коллизий.
+	collisionAlpha = 0.1
+
+	// Доля коллизий, после которой длина ключа увеличивается.
+	collisionThreshold = 0.2
+)
+
+// Allocator подбирает свободные короткие ключи. Доля коллизий при случайной генерации примерно равна
+// заполненности пространства ключей, поэтому аллокатор ведет ее скользящее среднее и, когда оно
+// превышает порог, увеличивает длину ключа на единицу.
+type Allocator struct {
+	mu        sync.Mutex
+	length    uint
+	rate      float64
+	generator KeyGenerator
+}
+
+// Конструктор аллокатора. generator - стратегия генерации ключей, length - начальная длина ключа.
+func NewAllocator(generator KeyGenerator, length uint) *Allocator {
+	return &Allocator{
+		length:    length,
+		generator: generator,
+	}
+}
+
+// Выделение ключей. store получает функцию next для генерации ключа по полной ссылке и пытается сохранить
+// ссылки с новыми ключами. Если store вернул ErrShortKeyConflict, попытка повторяется с новыми ключами,
+// но не больше MaxAttempts раз. Любая другая ошибка (или ее отсутствие) возвращается как есть.
+// Зарезервированные слова (пути роутера) ключами не выдаются: такой ключ перекрыт маршрутом и не открывается.
+func (a *Allocator) Allocate(store func(next func(full string) string) error) error {
+	for attempt := 0; attempt < MaxAttempts; attempt++ {
+		next := func(full string) string {
+			key := a.generator.Generate(full, a.Length(), attempt)
+			// номера попыток за MaxAttempts не пересекаются с номерами попыток Allocate
+			for retry := attempt + MaxAttempts; alias.IsReserved(key); retry += MaxAttempts {
+				key = a.generator.Generate(full, a.Length(), retry)
+			}
+
+			return key
+		}
+
+		err := store(next)
+		collided := _goerrors.Is(err, errors.ErrShortKeyConflict)
+		a.observe(collided)
+		if !collided {
+			return err
+		}
+	}
+
+	return errors.ErrShortKeyConflict
+}
+
+// Текущая длина ключа.
+func (a *Allocator) Length() uint {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.length
+}
+
+// observe учитывает результат попытки в скользящем среднем и при необходимости увеличивает длину ключа.
+func (a *Allocator) observe(collided bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	x := 0.0
+	if collided {
+		x = 1
+	}
+	a.rate = a.rate*(1-collisionAlpha) + x*collisionAlpha
+
+	if a.rate > collisionThreshold && a.length < MaxKeyLength {
+		a.length++
+		a.rate = 0
+	}
+}
@@ -0,0 +1,115 @@
+package keygen
+
+import (
+	_goerrors "errors"
+	"testing"
+
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocator_Allocate(t *testing.T) {
+	errDummy := _goerrors.New("dummy error")
+	tests := []struct {
+		name         string
+		results      []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "Stored from first attempt",
+			results:      []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "Retry after collisions",
+			results:      []error{errors.ErrShortKeyConflict, errors.ErrShortKeyConflict, nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "Full URL conflict is not retried",
+			results:      []error{errors.ErrConflict},
+			wantErr:      errors.ErrConflict,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Other error is not retried",
+			results:      []error{errors.ErrShortKeyConflict, errDummy},
+			wantErr:      errDummy,
+			wantAttempts: 2,
+		},
+		{
+			name:         "Attempts are exhausted",
+			wantErr:      errors.ErrShortKeyConflict,
+			wantAttempts: MaxAttempts,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAllocator(NewRandomGenerator(), KeyLength)
+			attempts := 0
+			err := a.Allocate(func(next func(full string) string) error {
+				assert.NotEmpty(t, next("http://www.yandex.ru/verylongpath"))
+				attempts++
+				if attempts > len(tt.results) {
+					return errors.ErrShortKeyConflict
+				}
+				return tt.results[attempts-1]
+			})
+
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantAttempts, attempts)
+		})
+	}
+}
+
+func TestAllocator_GrowsKeyLength(t *testing.T) {
+	a := NewAllocator(NewRandomGenerator(), KeyLength)
+
+	// редкие коллизии не увеличивают длину ключа
+	for i := 0; i < 100; i++ {
+		a.observe(i%20 == 0)
+	}
+	assert.Equal(t, uint(KeyLength), a.Length())
+
+	// заполненное пространство ключей: почти каждая попытка - коллизия
+	var lengths []uint
+	a.Allocate(func(next func(full string) string) error {
+		lengths = append(lengths, uint(len(next("http://www.yandex.ru/verylongpath"))))
+		return errors.ErrShortKeyConflict
+	})
+	assert.Equal(t, uint(KeyLength), lengths[0])
+	assert.Greater(t, a.Length(), uint(KeyLength))
+	assert.Greater(t, lengths[len(lengths)-1], lengths[0])
+}
+
+func TestAllocator_MaxKeyLength(t *testing.T) {
+	a := NewAllocator(NewRandomGenerator(), MaxKeyLength)
+	for i := 0; i < 100; i++ {
+		a.observe(true)
+	}
+	assert.Equal(t, uint(MaxKeyLength), a.Length())
+}
+
+// Генератор, выдающий ключи по очереди.
+type queueGenerator struct {
+	keys []string
+}
+
+func (g *queueGenerator) Generate(full string, n uint, attempt int) string {
+	key := g.keys[0]
+	g.keys = g.keys[1:]
+	return key
+}
+
+func TestAllocator_Reserved(t *testing.T) {
+	a := NewAllocator(&queueGenerator{keys: []string{"debug", "Login", "bavoki"}}, KeyLength)
+	var key string
+	err := a.Allocate(func(next func(full string) string) error {
+		key = next("http://www.yandex.ru/verylongpath")
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "bavoki", key)
+}
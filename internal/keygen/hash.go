@@ -0,0 +1,36 @@
+package keygen
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"strconv"
+)
+
+// HashGenerator строит ключ из sha256 полной ссылки: одна и та же ссылка получает один и тот же ключ.
+// При коллизии к ссылке добавляется номер попытки.
+type HashGenerator struct{}
+
+// Конструктор генератора ключей по хешу ссылки.
+func NewHashGenerator() *HashGenerator {
+	return &HashGenerator{}
+}
+
+// Первые n символов base62 представления хеша ссылки.
+func (g *HashGenerator) Generate(full string, n uint, attempt int) string {
+	content := full
+	if attempt > 0 {
+		content += "#" + strconv.Itoa(attempt)
+	}
+	sum := sha256.Sum256([]byte(content))
+
+	x := new(big.Int).SetBytes(sum[:])
+	base := big.NewInt(int64(len(alphabet)))
+	mod := new(big.Int)
+	result := make([]byte, 0, n)
+	for uint(len(result)) < n {
+		x.DivMod(x, base, mod)
+		result = append(result, alphabet[mod.Int64()])
+	}
+
+	return string(result)
+}
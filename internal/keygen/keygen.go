@@ -1,22 +1,81 @@
-// Модуль генерации рандомных строк
+// Модуль генерации коротких ключей.
 package keygen
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"fmt"
 )
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+// Алфавит base62, из которого составляются ключи.
+const alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 // Длина строки при генерации
 const KeyLength = 5
 
+// Стратегии генерации ключей.
+const (
+	// Случайный ключ base62 из crypto/rand.
+	StrategyRandom = "random"
+
+	// Последовательный счетчик, закодированный в перемешанный солью base62.
+	StrategySequential = "sequential"
+
+	// Хеш полной ссылки.
+	StrategyHash = "hash"
+
+	// Произносимый ключ из чередующихся согласных и гласных.
+	StrategyWords = "words"
+)
+
+// KeyGenerator - стратегия генерации коротких ключей.
+type KeyGenerator interface {
+	// Generate возвращает ключ длиной не меньше n для полной ссылки full.
+	// attempt - номер попытки, на повторной попытке генератор должен вернуть другой ключ.
+	Generate(full string, n uint, attempt int) string
+}
+
+// Конструктор генератора по названию стратегии. Соль используется стратегией sequential.
+func NewGenerator(strategy, salt string) (KeyGenerator, error) {
+	switch strategy {
+	case "", StrategyRandom:
+		return NewRandomGenerator(), nil
+	case StrategySequential:
+		return NewSequentialGenerator(salt), nil
+	case StrategyHash:
+		return NewHashGenerator(), nil
+	case StrategyWords:
+		return NewWordGenerator(), nil
+	}
+
+	return nil, fmt.Errorf("unknown key strategy %s", strategy)
+}
+
 // Получение рандомного ключа/строки
 func GetRandkey(n uint) string {
-	b := make([]byte, n)
+	return randomString(alphabet, n)
+}
+
+// randomString возвращает строку длины n из символов набора chars, выбранных через crypto/rand.
+// Байты, выходящие за последнее целое кратное len(chars), отбрасываются, чтобы не было смещения распределения.
+func randomString(chars string, n uint) string {
+	result := make([]byte, 0, n)
+	limit := 256 - 256%len(chars)
+	buf := make([]byte, n+n/4+1)
+	for uint(len(result)) < n {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
 
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			result = append(result, chars[int(b)%len(chars)])
+			if uint(len(result)) == n {
+				break
+			}
+		}
 	}
 
-	return string(b)
+	return string(result)
 }
@@ -1,9 +1,12 @@
 package keygen
 
 import (
+	_goerrors "errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetRandkey(t *testing.T) {
@@ -55,3 +58,127 @@ func BenchmarkGetRandkey(b *testing.B) {
 		GetRandkey(5)
 	}
 }
+
+func TestNewGenerator(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		want     KeyGenerator
+		wantErr  bool
+	}{
+		{name: "Default strategy", strategy: "", want: &RandomGenerator{}},
+		{name: "Random", strategy: StrategyRandom, want: &RandomGenerator{}},
+		{name: "Sequential", strategy: StrategySequential, want: &SequentialGenerator{}},
+		{name: "Hash", strategy: StrategyHash, want: &HashGenerator{}},
+		{name: "Words", strategy: StrategyWords, want: &WordGenerator{}},
+		{name: "Unknown strategy", strategy: "dummy", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGenerator(tt.strategy, "salt")
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.IsType(t, tt.want, g)
+		})
+	}
+}
+
+func TestRandomGenerator_Generate(t *testing.T) {
+	g := NewRandomGenerator()
+	key := g.Generate("http://www.yandex.ru/verylongpath", 8, 0)
+	assert.Len(t, key, 8)
+	for _, r := range key {
+		assert.Contains(t, alphabet, string(r))
+	}
+}
+
+func TestSequentialGenerator_Generate(t *testing.T) {
+	g := NewSequentialGenerator("salt")
+	seen := make(map[string]struct{})
+	for i := 0; i < 1000; i++ {
+		key := g.Generate("", 8, 0)
+		assert.Len(t, key, 8)
+		_, exists := seen[key]
+		require.False(t, exists, key)
+		seen[key] = struct{}{}
+	}
+
+	// номера одной длины кодируются без совпадений
+	keys := make(map[string]struct{})
+	for x := uint64(0); x < 62*62; x++ {
+		keys[g.encode(x, 2)] = struct{}{}
+	}
+	assert.Len(t, keys, 62*62)
+
+	// номер, не помещающийся в n символов, удлиняет ключ
+	assert.Len(t, g.encode(62*62, 2), 3)
+
+	// соль меняет кодировку
+	assert.NotEqual(t, g.encode(42, 5), NewSequentialGenerator("other").encode(42, 5))
+}
+
+func TestSequentialGenerator_Length(t *testing.T) {
+	// первые ключи имеют длину аллокатора
+	a := NewAllocator(NewSequentialGenerator("salt"), KeyLength)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, a.Allocate(func(next func(full string) string) error {
+			assert.Len(t, next(""), int(KeyLength))
+			return nil
+		}))
+	}
+
+	// номера берутся из зарезервированных в постоянном счетчике блоков
+	var stored uint64 = 2500
+	g := NewSequentialGenerator("salt")
+	g.SetReserver(func(size uint64) (uint64, error) {
+		stored += size
+		return stored, nil
+	})
+	assert.Equal(t, g.encode(2501, KeyLength), g.Generate("", KeyLength, 0))
+	for i := 0; i < SequentialBlockSize-1; i++ {
+		g.Generate("", KeyLength, 0)
+	}
+	assert.Equal(t, uint64(3500), stored)
+	assert.Equal(t, g.encode(3501, KeyLength), g.Generate("", KeyLength, 0))
+	assert.Equal(t, uint64(4500), stored)
+
+	// storage недоступен: номера выдаются дальше из памяти
+	g.SetReserver(func(size uint64) (uint64, error) {
+		return 0, _goerrors.New("connection refused")
+	})
+	assert.Equal(t, g.encode(3502, KeyLength), g.Generate("", KeyLength, 0))
+}
+
+func TestHashGenerator_Generate(t *testing.T) {
+	g := NewHashGenerator()
+	full := "http://www.yandex.ru/verylongpath"
+
+	key := g.Generate(full, KeyLength, 0)
+	assert.Len(t, key, KeyLength)
+	assert.Equal(t, key, g.Generate(full, KeyLength, 0))
+	assert.NotEqual(t, key, g.Generate("http://www.yandex.ru/otherpath", KeyLength, 0))
+	assert.NotEqual(t, key, g.Generate(full, KeyLength, 1))
+	assert.True(t, strings.HasPrefix(g.Generate(full, KeyLength+2, 0), key))
+}
+
+func TestWordGenerator_Generate(t *testing.T) {
+	g := NewWordGenerator()
+	key := g.Generate("", 7, 0)
+	require.Len(t, key, 7)
+	for i, r := range key {
+		if i%2 == 0 {
+			assert.Contains(t, consonants, string(r))
+			continue
+		}
+		assert.Contains(t, vowels, string(r))
+	}
+
+	// повторные попытки удлиняют ключ на слог каждые две попытки
+	assert.Len(t, g.Generate("", 5, 1), 5)
+	assert.Len(t, g.Generate("", 5, 2), 7)
+	assert.Len(t, g.Generate("", 5, MaxAttempts-1), 13)
+	assert.Len(t, g.Generate("", 5, MaxAttempts+2), 7)
+}
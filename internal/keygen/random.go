@@ -0,0 +1,14 @@
+package keygen
+
+// RandomGenerator генерирует случайные ключи base62 через crypto/rand.
+type RandomGenerator struct{}
+
+// Конструктор генератора случайных ключей.
+func NewRandomGenerator() *RandomGenerator {
+	return &RandomGenerator{}
+}
+
+// Случайный ключ длины n, полная ссылка и номер попытки не учитываются.
+func (g *RandomGenerator) Generate(full string, n uint, attempt int) string {
+	return GetRandkey(n)
+}
@@ -0,0 +1,116 @@
+package keygen
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"math/rand"
+	"sync"
+)
+
+// Множитель перестановки номеров, взаимно прост с 62 и любой его степенью.
+const sequentialMultiplier = 2147483647
+
+// Длина, начиная с которой перестановка не применяется: 62^11 не помещается в uint64.
+const sequentialMaxPermutedLength = 10
+
+// Сколько номеров счетчика резервируется в storage за раз.
+const SequentialBlockSize = 1000
+
+// SequentialGenerator выдает ключи по возрастающему счетчику в стиле hashids: номер переставляется
+// внутри пространства ключей нужной длины и кодируется алфавитом base62, перемешанным солью.
+// Соседние номера дают непохожие ключи, а разные номера одной длины никогда не совпадают.
+// Счетчик стартует с нуля, поэтому первые ключи имеют заданную длину. Чтобы после перезапуска ключи
+// не повторялись, номера резервируются блоками в постоянном счетчике storage (SetReserver): он только растет,
+// и удаленные ссылки не возвращают номера в оборот.
+type SequentialGenerator struct {
+	mu       sync.Mutex
+	counter  uint64
+	limit    uint64
+	reserve  func(size uint64) (uint64, error)
+	alphabet string
+	offset   uint64
+}
+
+// Конструктор генератора последовательных ключей.
+func NewSequentialGenerator(salt string) *SequentialGenerator {
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	seed := h.Sum64()
+
+	shuffled := []byte(alphabet)
+	rnd := rand.New(rand.NewSource(int64(seed)))
+	rnd.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	return &SequentialGenerator{alphabet: string(shuffled), offset: seed}
+}
+
+// Установка функции резервирования номеров: reserve увеличивает постоянный счетчик на size и возвращает
+// его новое значение, номера (value-size, value] выдает этот генератор.
+func (g *SequentialGenerator) SetReserver(reserve func(size uint64) (uint64, error)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.reserve = reserve
+	g.limit = 0
+}
+
+// Ключ для следующего номера счетчика. Длина ключа не меньше n и растет, когда номер перестает помещаться.
+func (g *SequentialGenerator) Generate(full string, n uint, attempt int) string {
+	return g.encode(g.next(), n)
+}
+
+// next выдает следующий номер, при исчерпании блока резервирует новый. Если резервирование не удалось,
+// номера выдаются дальше из памяти, а редкие совпадения с ними после перезапуска разрешает аллокатор.
+func (g *SequentialGenerator) next() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.counter++
+	if g.reserve == nil || g.counter <= g.limit {
+		return g.counter
+	}
+
+	if limit, err := g.reserve(SequentialBlockSize); err == nil {
+		g.counter = max(g.counter, limit-SequentialBlockSize+1)
+		g.limit = limit
+	}
+
+	return g.counter
+}
+
+// encode переставляет номер x внутри пространства ключей и кодирует его.
+func (g *SequentialGenerator) encode(x uint64, n uint) string {
+	base := uint64(len(g.alphabet))
+	length := n
+	space := uint64(1)
+	for i := uint(0); i < length; i++ {
+		space *= base
+	}
+
+	for length < sequentialMaxPermutedLength && space <= x {
+		length++
+		space *= base
+	}
+
+	if length < sequentialMaxPermutedLength {
+		// (x * multiplier + offset) mod space - биекция на [0, space)
+		hi, lo := bits.Mul64(x, sequentialMultiplier)
+		_, x = bits.Div64(hi%space, lo, space)
+		x = (x + g.offset%space) % space
+	}
+
+	result := make([]byte, length)
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = g.alphabet[x%base]
+		x /= base
+	}
+
+	for x > 0 {
+		result = append([]byte{g.alphabet[x%base]}, result...)
+		x /= base
+	}
+
+	return string(result)
+}
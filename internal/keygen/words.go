@@ -0,0 +1,36 @@
+package keygen
+
+const (
+	consonants = "bdfghjklmnprstvz"
+	vowels     = "aeiou"
+)
+
+// WordGenerator генерирует произносимые ключи из чередующихся согласных и гласных, например "bavoki".
+// Пространство ключей мало: 16^3 * 5^2 = 102400 ключей длины 5, каждый слог (согласная и гласная)
+// увеличивает его в 80 раз. Поэтому на повторных попытках ключ удлиняется на слог, не дожидаясь роста
+// длины в аллокаторе.
+type WordGenerator struct{}
+
+// Конструктор генератора произносимых ключей.
+func NewWordGenerator() *WordGenerator {
+	return &WordGenerator{}
+}
+
+// Произносимый ключ длины не меньше n, начинается с согласной. Каждые две повторные попытки
+// (attempt) добавляют слог.
+func (g *WordGenerator) Generate(full string, n uint, attempt int) string {
+	n += 2 * uint(attempt%MaxAttempts/2)
+	c := []byte(randomString(consonants, (n+1)/2))
+	v := []byte(randomString(vowels, n/2))
+
+	result := make([]byte, 0, n)
+	for i := uint(0); i < n; i++ {
+		if i%2 == 0 {
+			result = append(result, c[i/2])
+			continue
+		}
+		result = append(result, v[i/2])
+	}
+
+	return string(result)
+}
@@ -0,0 +1,82 @@
+// Модуль метрик приложения в формате Prometheus.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Результаты перехода по короткой ссылке.
+const (
+	// Редирект на полный URL.
+	RedirectHit = "hit"
+
+	// Ссылка не найдена.
+	RedirectMiss = "miss"
+
+	// Ссылка удалена, истекла или исчерпала лимит переходов.
+	RedirectGone = "gone"
+)
+
+// Registry - реестр метрик приложения.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests - количество запросов по шаблону роута chi, методу и статусу ответа.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPDuration - время обработки запросов по шаблону роута chi, методу и статусу ответа.
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route pattern, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// Redirects - количество переходов по коротким ссылкам по результату: hit, miss или gone.
+	Redirects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "redirects_total",
+		Help: "Number of short link redirects by result: hit, miss or gone.",
+	}, []string{"result"})
+
+	// StorageDuration - время операций storage по драйверу и методу.
+	StorageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_operation_duration_seconds",
+		Help:    "Storage operation latency by driver and method.",
+		Buckets: []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"driver", "method"})
+
+	// DeleteBatchQueue - количество коротких ключей, ожидающих пакетного удаления.
+	DeleteBatchQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "delete_batch_queue_depth",
+		Help: "Number of short keys waiting for batch deletion.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		Redirects,
+		StorageDuration,
+		DeleteBatchQueue,
+	)
+}
+
+// Хендлер эндпоинта /metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Учет времени операции storage, начатой в start. Используется через defer в начале метода драйвера.
+func ObserveStorage(driver, method string, start time.Time) {
+	StorageDuration.WithLabelValues(driver, method).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/mikesvis/short/internal/apikey"
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/storage"
+)
+
+// Авторизация по API ключу из заголовка apikey.HeaderName с проверкой права scope. Запрос без заголовка
+// передается дальше без изменений, его авторизуют SignIn/Auth по токену. С заголовком ключ должен
+// существовать и иметь право scope, тогда ID пользователя ключа пишется в контекст и SignIn/Auth
+// пропускают запрос без токена. Если storage не хранит API ключи, любой ключ неизвестен.
+func APIKey(s storage.Storage, scope domain.APIKeyScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			plain := r.Header.Get(apikey.HeaderName)
+			if len(plain) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			keyer, isKeyer := s.(storage.StorageAPIKeyer)
+			if !isKeyer {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			key, err := keyer.GetAPIKeyByHash(r.Context(), apikey.Hash(plain))
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			// ключ неизвестен или отозван
+			if len(key.ID) == 0 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !key.Allows(scope) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			ctx := setUserIDToContext(r, key.UserID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
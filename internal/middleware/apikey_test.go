@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	_context "context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mikesvis/short/internal/apikey"
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/drivers/inmemory"
+	"github.com/mikesvis/short/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storage без поддержки API ключей.
+type urlsOnlyStorage struct {
+	storage.Storage
+}
+
+func TestAPIKey(t *testing.T) {
+	s := inmemory.NewInMemory(nil)
+	key, creator, err := apikey.New("DoomGuy", "ci", []domain.APIKeyScope{domain.APIKeyScopeCreate}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, s.StoreAPIKey(_context.Background(), key))
+
+	key, admin, err := apikey.New("Heretic", "admin", nil, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, s.StoreAPIKey(_context.Background(), key))
+
+	type want struct {
+		statusCode int
+		userID     string
+	}
+	tests := []struct {
+		name   string
+		key    string
+		cookie string
+		scope  domain.APIKeyScope
+		next   func(http.Handler) http.Handler
+		want   want
+	}{
+		{name: "Key with scope", key: creator, scope: domain.APIKeyScopeCreate, next: Auth(s), want: want{statusCode: http.StatusOK, userID: "DoomGuy"}},
+		{name: "Key without scopes allows everything", key: admin, scope: domain.APIKeyScopeDelete, next: Auth(s), want: want{statusCode: http.StatusOK, userID: "Heretic"}},
+		{name: "Key takes precedence over cookie", key: admin, cookie: testToken(t, "DoomGuy"), scope: domain.APIKeyScopeRead, next: Auth(s), want: want{statusCode: http.StatusOK, userID: "Heretic"}},
+		{name: "SignIn does not create user for key", key: creator, scope: domain.APIKeyScopeCreate, next: SignIn(s), want: want{statusCode: http.StatusOK, userID: "DoomGuy"}},
+		{name: "Key without scope is forbidden", key: creator, scope: domain.APIKeyScopeDelete, next: Auth(s), want: want{statusCode: http.StatusForbidden}},
+		{name: "Unknown key", key: apikey.Prefix + "unknown", scope: domain.APIKeyScopeCreate, next: SignIn(s), want: want{statusCode: http.StatusUnauthorized}},
+		{name: "No key falls back to token", cookie: testToken(t, "DoomGuy"), scope: domain.APIKeyScopeRead, next: Auth(s), want: want{statusCode: http.StatusOK, userID: "DoomGuy"}},
+		{name: "No key and no token", scope: domain.APIKeyScopeRead, next: Auth(s), want: want{statusCode: http.StatusUnauthorized}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/", nil)
+			if len(tt.key) > 0 {
+				request.Header.Set(apikey.HeaderName, tt.key)
+			}
+			if len(tt.cookie) > 0 {
+				request.AddCookie(CreateAuthCookie(tt.cookie, time.Now().Add(time.Hour)))
+			}
+			w := httptest.NewRecorder()
+			APIKey(s, tt.scope)(tt.next(userIDHandler)).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, tt.want.statusCode, result.StatusCode)
+			if tt.want.statusCode == http.StatusOK {
+				assert.Equal(t, tt.want.userID, w.Body.String())
+				assert.Empty(t, result.Cookies())
+			}
+		})
+	}
+
+	t.Run("Storage without API keys", func(t *testing.T) {
+		request := httptest.NewRequest("POST", "/", nil)
+		request.Header.Set(apikey.HeaderName, admin)
+		w := httptest.NewRecorder()
+		APIKey(urlsOnlyStorage{s}, domain.APIKeyScopeCreate)(SignIn(s)(userIDHandler)).ServeHTTP(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, result.StatusCode)
+	})
+}
@@ -3,66 +3,74 @@ package middleware
 
 import (
 	_context "context"
-	_errors "errors"
 	"net/http"
+	"strings"
 	"time"
 
-	_jwt "github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 	"github.com/mikesvis/short/internal/context"
 	"github.com/mikesvis/short/internal/errors"
 	"github.com/mikesvis/short/internal/jwt"
+	"github.com/mikesvis/short/internal/session"
+	"github.com/mikesvis/short/internal/storage"
 )
 
-// Регистрация по куке jwt.AuthorizationCookieName. В результате успешной регистрации будет создана кука и прописан ID пользователя в контекст.
-func SignIn(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authCookie, err := r.Cookie(jwt.AuthorizationCookieName)
-		if err != nil && !_errors.Is(err, http.ErrNoCookie) {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		// кука есть
-		if err == nil {
-			tokenString := authCookie.Value
-			var userID string
-			userID, err = jwt.GetUserIDFromTokenString(tokenString)
-
-			// все ОК, пишем в контекст userID
-			if err == nil {
-				ctx := setUserIDToContext(r, userID)
-				next.ServeHTTP(w, r.WithContext(ctx))
+// Регистрация по токену доступа из заголовка Authorization: Bearer <jwt> либо из куки jwt.AuthorizationCookieName,
+// заголовок важнее куки. Если токена нет, создается новый пользователь, ему выдается пара токенов, которая
+// возвращается в куках и в заголовках ответа, и прописывается ID пользователя в контекст. Истекший токен
+// доступа обновляется по refresh токену. Невалидный, истекший без refresh токена или отозванный токен
+// не принимается (session.SignIn), куки с таким токеном удаляются, чтобы следующий запрос создал пользователя.
+// Запрос, уже авторизованный API ключом, пропускается без токена.
+func SignIn(s storage.Storage) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authorized(r) {
+				next.ServeHTTP(w, r)
 				return
 			}
 
-			// если пустой userID: StatusUnauthorized
-			if _errors.Is(err, errors.ErrEmptyUserID) {
+			tokenString, bearer, err := TokenFromRequest(r)
+			// заголовок Authorization не в формате Bearer
+			if err != nil {
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
 
-			// с токеном проблем, но не проблема подписи StatusInternalServerError
-			if !_errors.Is(err, _jwt.ErrSignatureInvalid) {
-				w.WriteHeader(http.StatusInternalServerError)
+			userID, issued, err := session.SignIn(r.Context(), s, tokenString, RefreshTokenFromRequest(r, bearer))
+			if err != nil {
+				if !bearer && session.Unauthorized(err) {
+					ClearTokens(w)
+				}
+				w.WriteHeader(authErrorStatus(err))
 				return
 			}
-		}
 
-		// куки нет или проблема подписи - создаем новую
-		userID := uuid.NewString()
-		expirationTime := time.Now().Add(jwt.TokenDuration)
-		tokenString, err := jwt.CreateTokenString(userID, expirationTime)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+			if issued != nil {
+				IssueTokens(w, *issued)
+			}
+
+			ctx := setUserIDToContext(r, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-		http.SetCookie(w, CreateAuthCookie(tokenString, expirationTime))
+// Выдача пары токенов клиенту: токены возвращаются в куках jwt.AuthorizationCookieName
+// и jwt.RefreshCookieName и в заголовках Authorization и jwt.RefreshHeaderName ответа.
+func IssueTokens(w http.ResponseWriter, tokens session.Tokens) {
+	// кука с токеном доступа живет столько же, сколько refresh токен: по истекшему токену из нее и refresh куке
+	// SignIn/Auth выдают новую пару, и клиент с одними куками не теряет пользователя через AccessTokenDuration
+	http.SetCookie(w, CreateAuthCookie(tokens.Access, tokens.RefreshExpiresAt))
+	http.SetCookie(w, createRefreshCookie(tokens.Refresh, tokens.RefreshExpiresAt))
+	w.Header().Set(jwt.AuthorizationHeaderName, jwt.BearerScheme+" "+tokens.Access)
+	w.Header().Set(jwt.RefreshHeaderName, tokens.Refresh)
+}
 
-		ctx := setUserIDToContext(r, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// Удаление кук с токенами у клиента.
+func ClearTokens(w http.ResponseWriter) {
+	for _, c := range []*http.Cookie{CreateAuthCookie("", time.Unix(0, 0)), createRefreshCookie("", time.Unix(0, 0))} {
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
 }
 
 // Построение авторизационной куки.
@@ -75,40 +83,127 @@ func CreateAuthCookie(tokenString string, exp time.Time) *http.Cookie {
 	}
 }
 
-// Авторизация по куке jwt.AuthorizationCookieName
-func Auth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authCookie, err := r.Cookie(jwt.AuthorizationCookieName)
-		// проблема с получением куки или ее нет
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+// Построение куки с refresh токеном, она недоступна из js.
+func createRefreshCookie(tokenString string, exp time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     jwt.RefreshCookieName,
+		Value:    tokenString,
+		Expires:  exp,
+		Path:     "/",
+		HttpOnly: true,
+	}
+}
 
-		tokenString := authCookie.Value
-		userID, err := jwt.GetUserIDFromTokenString(tokenString)
+// Авторизация по токену доступа из заголовка Authorization: Bearer <jwt> либо из куки jwt.AuthorizationCookieName,
+// заголовок важнее куки. Истекший токен доступа обновляется по refresh токену, новая пара возвращается в куках
+// и в заголовках ответа. Запрос, уже авторизованный API ключом, пропускается без токена.
+func Auth(s storage.Storage) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if authorized(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		// проблема с расшифровкой или валидностью JWT
-		if err != nil && _errors.Is(err, errors.ErrInvalidToken) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+			tokenString, bearer, err := TokenFromRequest(r)
+			// заголовок не в формате Bearer
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
 
-		// UserID есть но он пустой
-		if err != nil && _errors.Is(err, errors.ErrEmptyUserID) {
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+			userID, issued, err := session.Authenticate(r.Context(), s, tokenString, RefreshTokenFromRequest(r, bearer))
+			if err != nil {
+				w.WriteHeader(authErrorStatus(err))
+				return
+			}
+
+			if issued != nil {
+				IssueTokens(w, *issued)
+			}
+
+			ctx := setUserIDToContext(r, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Необязательная идентификация по токену доступа из заголовка Authorization: Bearer <jwt> либо из куки
+// jwt.AuthorizationCookieName. С валидным неотозванным токеном ID пользователя пишется в контекст, без токена
+// или с невалидным токеном запрос передается дальше без ID пользователя.
+func Identify(s storage.Storage) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, _, err := TokenFromRequest(r)
+			if err != nil || len(tokenString) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, _, err := session.Authenticate(r.Context(), s, tokenString, "")
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := setUserIDToContext(r, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-		// какая-то другая проблема с токеном
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+// Токен авторизации запроса: из заголовка Authorization со схемой Bearer, если заголовок есть,
+// иначе из куки jwt.AuthorizationCookieName, и признак того, что токен взят из заголовка. Если заголовок есть,
+// но не в формате Bearer <jwt>, возвращается ErrInvalidToken. Если токена нет, возвращается пустая строка.
+func TokenFromRequest(r *http.Request) (string, bool, error) {
+	if header := r.Header.Get(jwt.AuthorizationHeaderName); len(header) > 0 {
+		scheme, tokenString, found := strings.Cut(header, " ")
+		tokenString = strings.TrimSpace(tokenString)
+		if !found || !strings.EqualFold(scheme, jwt.BearerScheme) || len(tokenString) == 0 {
+			return "", true, errors.ErrInvalidToken
 		}
 
-		ctx := setUserIDToContext(r, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+		return tokenString, true, nil
+	}
+
+	authCookie, err := r.Cookie(jwt.AuthorizationCookieName)
+	if err != nil {
+		return "", false, nil
+	}
+
+	return authCookie.Value, false, nil
+}
+
+// Refresh токен запроса: из заголовка jwt.RefreshHeaderName, иначе из куки jwt.RefreshCookieName.
+// Если токен доступа передан в заголовке (bearer), кука не используется: она может принадлежать
+// другому пользователю браузера. Если токена нет, возвращается пустая строка.
+func RefreshTokenFromRequest(r *http.Request, bearer bool) string {
+	if header := strings.TrimSpace(r.Header.Get(jwt.RefreshHeaderName)); len(header) > 0 || bearer {
+		return header
+	}
+
+	refreshCookie, err := r.Cookie(jwt.RefreshCookieName)
+	if err != nil {
+		return ""
+	}
+
+	return refreshCookie.Value
+}
+
+// Статус ответа для ошибки авторизации: проблема с токеном клиента - StatusUnauthorized, остальное -
+// StatusInternalServerError.
+func authErrorStatus(err error) int {
+	if session.Unauthorized(err) {
+		return http.StatusUnauthorized
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Проверка, что ID пользователя уже записан в контекст запроса предыдущей мидлварью (APIKey).
+func authorized(r *http.Request) bool {
+	_, exists := r.Context().Value(context.UserIDContextKey).(string)
+	return exists
 }
 
 func setUserIDToContext(r *http.Request, userID string) _context.Context {
@@ -0,0 +1,281 @@
+package middleware
+
+import (
+	_context "context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/mikesvis/short/internal/context"
+	"github.com/mikesvis/short/internal/drivers/inmemory"
+	"github.com/mikesvis/short/internal/jwt"
+	"github.com/mikesvis/short/internal/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Ключи подписи токенов задаются при старте сервиса, в тестах - общим секретом.
+func TestMain(m *testing.M) {
+	if err := jwt.SetKeys(jwt.KeySetFromSecret("iddqd")); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// Хендлер, отдающий в теле ID пользователя из контекста.
+var userIDHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.Context().Value(context.UserIDContextKey).(string)))
+})
+
+func testToken(t *testing.T, userID string) string {
+	tokenString, err := jwt.CreateTokenString(userID, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	return tokenString
+}
+
+// Куки ответа по имени.
+func responseCookies(result *http.Response) map[string]string {
+	cookies := make(map[string]string)
+	for _, c := range result.Cookies() {
+		cookies[c.Name] = c.Value
+	}
+
+	return cookies
+}
+
+func TestSignIn(t *testing.T) {
+	s := inmemory.NewInMemory(nil)
+	doomGuy := testToken(t, "DoomGuy")
+	heretic := testToken(t, "Heretic")
+	forged, err := _jwt.NewWithClaims(_jwt.SigningMethodHS256, &jwt.Claims{UserID: "Imp"}).SignedString([]byte("mySecretPass"))
+	require.NoError(t, err)
+	unknownKey := _jwt.NewWithClaims(_jwt.SigningMethodHS256, &jwt.Claims{UserID: "Imp"})
+	unknownKey.Header["kid"] = "idkfa"
+	unknownKid, err := unknownKey.SignedString([]byte("mySecretPass"))
+	require.NoError(t, err)
+	expired, err := jwt.CreateTokenString("Cacodemon", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	cacodemon, err := session.Issue("Cacodemon")
+	require.NoError(t, err)
+	revoked, err := session.Issue("Revenant")
+	require.NoError(t, err)
+	require.NoError(t, session.Revoke(_context.Background(), s, revoked.Access))
+
+	type want struct {
+		statusCode int
+		userID     string
+		newToken   bool
+		cleared    bool
+	}
+	tests := []struct {
+		name    string
+		cookie  string
+		header  string
+		refresh string
+		want    want
+	}{
+		{name: "No token creates user", want: want{statusCode: http.StatusOK, newToken: true}},
+		{name: "Cookie", cookie: doomGuy, want: want{statusCode: http.StatusOK, userID: "DoomGuy"}},
+		{name: "Bearer header", header: "Bearer " + heretic, want: want{statusCode: http.StatusOK, userID: "Heretic"}},
+		{name: "Bearer scheme is case insensitive", header: "bearer " + heretic, want: want{statusCode: http.StatusOK, userID: "Heretic"}},
+		{name: "Header takes precedence over cookie", cookie: doomGuy, header: "Bearer " + heretic, want: want{statusCode: http.StatusOK, userID: "Heretic"}},
+		{name: "Forged cookie is rejected", cookie: forged, want: want{statusCode: http.StatusUnauthorized, cleared: true}},
+		{name: "Cookie with unknown key id is rejected", cookie: unknownKid, want: want{statusCode: http.StatusUnauthorized, cleared: true}},
+		{name: "Forged bearer is rejected", header: "Bearer " + forged, want: want{statusCode: http.StatusUnauthorized}},
+		{name: "Forged bearer is not replaced by cookie", cookie: doomGuy, header: "Bearer " + forged, want: want{statusCode: http.StatusUnauthorized}},
+		{name: "Other scheme is rejected", header: "Basic " + heretic, want: want{statusCode: http.StatusUnauthorized}},
+		{name: "Empty bearer is rejected", header: "Bearer ", want: want{statusCode: http.StatusUnauthorized}},
+		{name: "Expired cookie is refreshed", cookie: expired, refresh: cacodemon.Refresh, want: want{statusCode: http.StatusOK, userID: "Cacodemon", newToken: true}},
+		{name: "Expired cookie without refresh is rejected", cookie: expired, want: want{statusCode: http.StatusUnauthorized, cleared: true}},
+		{name: "Revoked cookie is rejected", cookie: revoked.Access, want: want{statusCode: http.StatusUnauthorized, cleared: true}},
+		{name: "Reused refresh token within grace period is refreshed", cookie: expired, refresh: cacodemon.Refresh, want: want{statusCode: http.StatusOK, userID: "Cacodemon", newToken: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/", nil)
+			if len(tt.cookie) > 0 {
+				request.AddCookie(&http.Cookie{Name: jwt.AuthorizationCookieName, Value: tt.cookie})
+			}
+			if len(tt.refresh) > 0 {
+				request.AddCookie(&http.Cookie{Name: jwt.RefreshCookieName, Value: tt.refresh})
+			}
+			if len(tt.header) > 0 {
+				request.Header.Set(jwt.AuthorizationHeaderName, tt.header)
+			}
+			w := httptest.NewRecorder()
+			SignIn(s)(userIDHandler).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			require.Equal(t, tt.want.statusCode, result.StatusCode)
+			if tt.want.statusCode != http.StatusOK {
+				// отклоненные куки удаляются, чтобы следующий запрос создал пользователя
+				if tt.want.cleared {
+					assert.Equal(t, map[string]string{jwt.AuthorizationCookieName: "", jwt.RefreshCookieName: ""}, responseCookies(result))
+				} else {
+					assert.Empty(t, result.Cookies())
+				}
+				return
+			}
+
+			userID := w.Body.String()
+			header := result.Header.Get(jwt.AuthorizationHeaderName)
+			if !tt.want.newToken {
+				assert.Equal(t, tt.want.userID, userID)
+				assert.Empty(t, header)
+				assert.Empty(t, result.Cookies())
+				return
+			}
+
+			if len(tt.want.userID) > 0 {
+				assert.Equal(t, tt.want.userID, userID)
+			}
+
+			// новая пара токенов приходит и в куках, и в заголовках
+			cookies := responseCookies(result)
+			require.Len(t, cookies, 2)
+			assert.Equal(t, "Bearer "+cookies[jwt.AuthorizationCookieName], header)
+			assert.Equal(t, cookies[jwt.RefreshCookieName], result.Header.Get(jwt.RefreshHeaderName))
+			tokenUserID, err := jwt.GetUserIDFromTokenString(strings.TrimPrefix(header, "Bearer "))
+			require.NoError(t, err)
+			assert.Equal(t, userID, tokenUserID)
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := inmemory.NewInMemory(nil)
+	doomGuy := testToken(t, "DoomGuy")
+	heretic := testToken(t, "Heretic")
+	expired, err := jwt.CreateTokenString("Heretic", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	baron, err := session.Issue("Baron")
+	require.NoError(t, err)
+	revoked, err := session.Issue("Revenant")
+	require.NoError(t, err)
+	require.NoError(t, session.Revoke(_context.Background(), s, revoked.Access))
+	cyberdemon, err := session.Issue("Cyberdemon")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		cookie        string
+		header        string
+		refreshCookie string
+		refreshHeader string
+		statusCode    int
+		userID        string
+		rotated       bool
+	}{
+		{name: "No token", statusCode: http.StatusUnauthorized},
+		{name: "Cookie", cookie: doomGuy, statusCode: http.StatusOK, userID: "DoomGuy"},
+		{name: "Bearer header", header: "Bearer " + heretic, statusCode: http.StatusOK, userID: "Heretic"},
+		{name: "Header takes precedence over cookie", cookie: doomGuy, header: "Bearer " + heretic, statusCode: http.StatusOK, userID: "Heretic"},
+		{name: "Malformed bearer", header: "Bearer idkfa", statusCode: http.StatusUnauthorized},
+		{name: "Expired bearer", header: "Bearer " + expired, statusCode: http.StatusUnauthorized},
+		{name: "Other scheme", cookie: doomGuy, header: "Basic " + heretic, statusCode: http.StatusUnauthorized},
+		{name: "Revoked cookie", cookie: revoked.Access, statusCode: http.StatusUnauthorized},
+		{name: "Revoked bearer", header: "Bearer " + revoked.Access, statusCode: http.StatusUnauthorized},
+		{name: "Refresh token is not an access token", header: "Bearer " + baron.Refresh, statusCode: http.StatusUnauthorized},
+		{name: "Expired bearer ignores refresh cookie", header: "Bearer " + expired, refreshCookie: baron.Refresh, statusCode: http.StatusUnauthorized},
+		{name: "Expired bearer is refreshed by header", header: "Bearer " + expired, refreshHeader: baron.Refresh, statusCode: http.StatusOK, userID: "Baron", rotated: true},
+		{name: "Refresh cookie without access cookie", refreshCookie: revoked.Refresh, statusCode: http.StatusOK, userID: "Revenant", rotated: true},
+		{name: "Expired cookie is refreshed by refresh cookie", cookie: expired, refreshCookie: cyberdemon.Refresh, statusCode: http.StatusOK, userID: "Cyberdemon", rotated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/", nil)
+			if len(tt.cookie) > 0 {
+				request.AddCookie(&http.Cookie{Name: jwt.AuthorizationCookieName, Value: tt.cookie})
+			}
+			if len(tt.refreshCookie) > 0 {
+				request.AddCookie(&http.Cookie{Name: jwt.RefreshCookieName, Value: tt.refreshCookie})
+			}
+			if len(tt.header) > 0 {
+				request.Header.Set(jwt.AuthorizationHeaderName, tt.header)
+			}
+			if len(tt.refreshHeader) > 0 {
+				request.Header.Set(jwt.RefreshHeaderName, tt.refreshHeader)
+			}
+			w := httptest.NewRecorder()
+			Auth(s)(userIDHandler).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+			if tt.statusCode == http.StatusOK {
+				assert.Equal(t, tt.userID, w.Body.String())
+				assert.Equal(t, tt.rotated, len(result.Header.Get(jwt.RefreshHeaderName)) > 0)
+			}
+		})
+	}
+}
+
+func TestIssueTokens(t *testing.T) {
+	tokens, err := session.Issue("DoomGuy")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	IssueTokens(w, tokens)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 2)
+
+	// обе куки живут до истечения refresh токена: клиент с одними куками обновляет по ним истекший токен доступа
+	for _, c := range cookies {
+		assert.Equal(t, tokens.RefreshExpiresAt.Unix(), c.Expires.Unix(), c.Name)
+	}
+	assert.Equal(t, tokens.Access, cookies[0].Value)
+	assert.True(t, cookies[1].HttpOnly)
+}
+
+func TestIdentify(t *testing.T) {
+	s := inmemory.NewInMemory(nil)
+	revoked, err := session.Issue("Revenant")
+	require.NoError(t, err)
+	require.NoError(t, session.Revoke(_context.Background(), s, revoked.Access))
+
+	// Хендлер, отдающий в теле ID пользователя из контекста, если он есть.
+	optionalUserIDHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := r.Context().Value(context.UserIDContextKey).(string)
+		w.Write([]byte(userID))
+	})
+
+	tests := []struct {
+		name   string
+		cookie string
+		header string
+		userID string
+	}{
+		{name: "No token", userID: ""},
+		{name: "Cookie", cookie: testToken(t, "DoomGuy"), userID: "DoomGuy"},
+		{name: "Bearer header", header: "Bearer " + testToken(t, "Heretic"), userID: "Heretic"},
+		{name: "Invalid token is ignored", cookie: "invalid", userID: ""},
+		{name: "Other scheme is ignored", header: "Basic DoomGuy", userID: ""},
+		{name: "Revoked token is ignored", cookie: revoked.Access, userID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/", nil)
+			if len(tt.cookie) > 0 {
+				request.AddCookie(&http.Cookie{Name: jwt.AuthorizationCookieName, Value: tt.cookie})
+			}
+			if len(tt.header) > 0 {
+				request.Header.Set(jwt.AuthorizationHeaderName, tt.header)
+			}
+			w := httptest.NewRecorder()
+			Identify(s)(optionalUserIDHandler).ServeHTTP(w, request)
+			result := w.Result()
+			defer result.Body.Close()
+
+			assert.Equal(t, http.StatusOK, result.StatusCode)
+			assert.Equal(t, tt.userID, w.Body.String())
+			assert.Empty(t, result.Cookies())
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mikesvis/short/internal/metrics"
+)
+
+// Шаблон роута для запросов, не попавших ни в один роут.
+const unmatchedRoute = "unmatched"
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// Запись статуса ответа
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	w.ResponseWriter.WriteHeader(statusCode)
+	w.status = statusCode
+}
+
+// Метрики запросов: количество и время обработки по шаблону роута chi, методу и статусу ответа.
+// Шаблон роута вместо пути запроса не дает коротким ключам раздувать количество серий.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := unmatchedRoute
+		if routeCtx := chi.RouteContext(r.Context()); routeCtx != nil && len(routeCtx.RoutePattern()) > 0 {
+			route = routeCtx.RoutePattern()
+		}
+
+		status := strconv.Itoa(sw.status)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
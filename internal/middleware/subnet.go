@@ -0,0 +1,28 @@
+// Модуль ограничения доступа по доверенной подсети.
+package middleware
+
+import (
+	"net"
+	"net/http"
+)
+
+// Доступ только для клиентов из доверенной подсети trustedSubnet в CIDR нотации. IP клиента берется
+// из заголовка X-Real-IP. Если подсеть не задана или IP не входит в нее, отдается 403.
+func TrustedSubnet(trustedSubnet string) func(next http.Handler) http.Handler {
+	_, subnet, err := net.ParseCIDR(trustedSubnet)
+	if err != nil {
+		subnet = nil
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := net.ParseIP(r.Header.Get("X-Real-IP"))
+			if subnet == nil || ip == nil || !subnet.Contains(ip) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
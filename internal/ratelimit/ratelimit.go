@@ -0,0 +1,93 @@
+// Модуль ограничения количества неудачных попыток.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Количество ключей, после которого при очередной неудачной попытке удаляются устаревшие записи.
+const pruneThreshold = 1024
+
+type attempts struct {
+	count int
+	start time.Time
+}
+
+// Limiter считает неудачные попытки по ключу в фиксированном окне. После max неудачных попыток
+// ключ блокируется до конца окна. Доступ защищен мьютексом.
+type Limiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[string]attempts
+	now      func() time.Time
+}
+
+// Конструктор ограничителя. max - количество неудачных попыток в окне window.
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:      max,
+		window:   window,
+		attempts: make(map[string]attempts),
+		now:      time.Now,
+	}
+}
+
+// Проверка, можно ли выполнить попытку по ключу. Если нельзя, возвращается время до снятия блокировки.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, exists := l.attempts[key]
+	if !exists {
+		return true, 0
+	}
+
+	elapsed := l.now().Sub(a.start)
+	if elapsed >= l.window {
+		delete(l.attempts, key)
+		return true, 0
+	}
+
+	if a.count < l.max {
+		return true, 0
+	}
+
+	return false, l.window - elapsed
+}
+
+// Учет неудачной попытки по ключу.
+func (l *Limiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if len(l.attempts) >= pruneThreshold {
+		l.prune(now)
+	}
+
+	a, exists := l.attempts[key]
+	if !exists || now.Sub(a.start) >= l.window {
+		a = attempts{start: now}
+	}
+	a.count++
+	l.attempts[key] = a
+}
+
+// Сброс неудачных попыток по ключу после успешной попытки.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
+
+// prune удаляет записи с истекшим окном, вызывается под блокировкой.
+func (l *Limiter) prune(now time.Time) {
+	for key, a := range l.attempts {
+		if now.Sub(a.start) >= l.window {
+			delete(l.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(3, time.Minute)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		name       string
+		action     func()
+		key        string
+		allow      bool
+		retryAfter time.Duration
+	}{
+		{
+			name:   "Unknown key is allowed",
+			action: func() {},
+			key:    "idkfa",
+			allow:  true,
+		},
+		{
+			name:   "Allowed before limit",
+			action: func() { l.Fail("idkfa"); l.Fail("idkfa") },
+			key:    "idkfa",
+			allow:  true,
+		},
+		{
+			name:       "Blocked after limit",
+			action:     func() { l.Fail("idkfa"); now = now.Add(20 * time.Second) },
+			key:        "idkfa",
+			allow:      false,
+			retryAfter: 40 * time.Second,
+		},
+		{
+			name:   "Other key is not affected",
+			action: func() {},
+			key:    "iddqd",
+			allow:  true,
+		},
+		{
+			name:   "Allowed after window",
+			action: func() { now = now.Add(40 * time.Second) },
+			key:    "idkfa",
+			allow:  true,
+		},
+		{
+			name:   "Allowed after reset",
+			action: func() { l.Fail("iddqd"); l.Fail("iddqd"); l.Fail("iddqd"); l.Reset("iddqd") },
+			key:    "iddqd",
+			allow:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.action()
+			allow, retryAfter := l.Allow(tt.key)
+			assert.Equal(t, tt.allow, allow)
+			assert.Equal(t, tt.retryAfter, retryAfter)
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	_context "context"
+	"encoding/json"
+	_errors "errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mikesvis/short/internal/account"
+	"github.com/mikesvis/short/internal/api"
+	"github.com/mikesvis/short/internal/context"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/mikesvis/short/internal/middleware"
+	"github.com/mikesvis/short/internal/session"
+	"github.com/mikesvis/short/internal/storage"
+)
+
+// Обработка /api/auth/register POST
+// Регистрация аккаунта с логином и паролем. Анонимный пользователь из токена запроса становится
+// пользователем аккаунта вместе со своими ссылками, иначе создается новый пользователь.
+// Пара токенов аккаунта возвращается в куках и заголовках Authorization и X-Refresh-Token
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	accounter, isAccounter := h.storage.(storage.StorageAccounter)
+	if !isAccounter {
+		http.Error(w, fmt.Sprintf(`Accounts are not supported for storage of type %s`, reflect.TypeOf(h.storage).String()), http.StatusInternalServerError)
+
+		return
+	}
+
+	var request api.AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.anonymousUserID(ctx, accounter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(userID) == 0 {
+		userID = uuid.NewString()
+	}
+
+	a, err := account.New(userID, request.Login, request.Password, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = accounter.StoreAccount(ctx, a)
+	if _errors.Is(err, errors.ErrLoginTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// аккаунт на этого пользователя зарегистрирован параллельным запросом
+	if _errors.Is(err, errors.ErrConflict) {
+		http.Error(w, `account for this user is already registered`, http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := session.Issue(a.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	middleware.IssueTokens(w, tokens)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(api.AuthResponse{UserID: a.ID, Login: a.Login})
+}
+
+// Обработка /api/auth/login POST
+// Проверка ограничения неудачных попыток входа для логина с IP клиента
+// Проверка логина и пароля
+// Перенос в аккаунт ссылок анонимного пользователя из токена запроса
+// Пара токенов аккаунта возвращается в куках и заголовках Authorization и X-Refresh-Token
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	accounter, isAccounter := h.storage.(storage.StorageAccounter)
+	if !isAccounter {
+		http.Error(w, fmt.Sprintf(`Accounts are not supported for storage of type %s`, reflect.TypeOf(h.storage).String()), http.StatusInternalServerError)
+
+		return
+	}
+
+	var request api.AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	login := account.NormalizeLogin(request.Login)
+	// попытки учитываются тем же ограничителем, что и пароли ссылок, в отдельном пространстве ключей;
+	// ключ включает IP клиента, чтобы подбор с одного адреса не блокировал вход владельцу аккаунта
+	attemptsKey := "login:" + clientIP(r) + ":" + login
+	if allow, retryAfter := h.passwords.Allow(attemptsKey); !allow {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		http.Error(w, "too many wrong login attempts", http.StatusTooManyRequests)
+
+		return
+	}
+
+	a, err := accounter.GetAccountByLogin(ctx, login)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// неизвестный логин не отличается от неверного пароля ни ответом, ни временем проверки
+	if !account.CheckPassword(a, request.Password) {
+		h.passwords.Fail(attemptsKey)
+		http.Error(w, "wrong login or password", http.StatusUnauthorized)
+
+		return
+	}
+	h.passwords.Reset(attemptsKey)
+
+	merged := 0
+	anonymousID, err := h.anonymousUserID(ctx, accounter)
+	if err == nil && len(anonymousID) > 0 && anonymousID != a.ID {
+		merged, err = accounter.MergeUserURLs(ctx, anonymousID, a.ID)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := session.Issue(a.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	middleware.IssueTokens(w, tokens)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(api.AuthResponse{UserID: a.ID, Login: a.Login, Merged: merged})
+}
+
+// Обработка /api/auth/refresh POST
+// Refresh токен берется из заголовка X-Refresh-Token или из куки
+// Refresh токен отзывается, повторное его использование - 401
+// Новая пара токенов возвращается в теле, куках и заголовках Authorization и X-Refresh-Token
+func (h *Handler) RefreshTokens(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	refresh := middleware.RefreshTokenFromRequest(r, false)
+	if len(refresh) == 0 {
+		http.Error(w, errors.ErrTokenMissing.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := session.Refresh(ctx, h.storage, refresh)
+	if err != nil {
+		http.Error(w, err.Error(), tokenErrorStatus(err, http.StatusUnauthorized))
+		return
+	}
+	middleware.IssueTokens(w, tokens)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(api.TokensResponse{
+		UserID:           tokens.UserID,
+		AccessToken:      tokens.Access,
+		AccessExpiresAt:  tokens.AccessExpiresAt,
+		RefreshToken:     tokens.Refresh,
+		RefreshExpiresAt: tokens.RefreshExpiresAt,
+	})
+}
+
+// Обработка /api/auth/logout POST
+// Отзыв токена доступа и refresh токена запроса до их истечения
+// Куки с токенами удаляются
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	access, bearer, err := middleware.TokenFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	refresh := middleware.RefreshTokenFromRequest(r, bearer)
+	if len(access) == 0 && len(refresh) == 0 {
+		http.Error(w, errors.ErrTokenMissing.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	for _, tokenString := range []string{access, refresh} {
+		if len(tokenString) == 0 {
+			continue
+		}
+
+		if err = session.Revoke(ctx, h.storage, tokenString); err != nil {
+			http.Error(w, err.Error(), tokenErrorStatus(err, http.StatusUnauthorized))
+			return
+		}
+	}
+
+	middleware.ClearTokens(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Обработка /api/internal/tokens/revoke POST
+// Доступ проверяется middleware.TrustedSubnet
+// Отзыв переданного токена любого пользователя до его истечения
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	var request api.RevokeTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(request.Token) == 0 {
+		http.Error(w, errors.ErrTokenMissing.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := session.Revoke(ctx, h.storage, request.Token); err != nil {
+		http.Error(w, err.Error(), tokenErrorStatus(err, http.StatusBadRequest))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Статус ответа для ошибки работы с токеном: проблема с токеном клиента - clientStatus,
+// остальное (в том числе storage без отзыва токенов) - StatusInternalServerError.
+func tokenErrorStatus(err error, clientStatus int) int {
+	if session.Unauthorized(err) {
+		return clientStatus
+	}
+
+	return http.StatusInternalServerError
+}
+
+// ID анонимного пользователя из контекста запроса. Если пользователя в контексте нет
+// или у него уже есть аккаунт, возвращается пустая строка.
+func (h *Handler) anonymousUserID(ctx _context.Context, accounter storage.StorageAccounter) (string, error) {
+	userID, _ := ctx.Value(context.UserIDContextKey).(string)
+	if len(userID) == 0 {
+		return "", nil
+	}
+
+	a, err := accounter.GetAccount(ctx, userID)
+	if err != nil || len(a.ID) > 0 {
+		return "", err
+	}
+
+	return userID, nil
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/mikesvis/short/internal/context"
 	"github.com/mikesvis/short/internal/drivers/inmemory"
+	"github.com/mikesvis/short/internal/keygen"
 	"github.com/mikesvis/short/internal/logger"
 )
 
@@ -22,7 +23,7 @@ func ExampleHandler_GetFullURL() {
 	// формируем запрос
 	request := httptest.NewRequest("GET", "http://example.com/short", nil)
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.GetFullURL)
 
 	// отправляем запрос и получаем результат
@@ -43,7 +44,7 @@ func ExampleHandler_CreateShortURLText() {
 	// формируем запрос
 	request := httptest.NewRequest("POST", "/", strings.NewReader("http://www.yandex.ru/verylongpath")).WithContext(ctx)
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.CreateShortURLText)
 
 	// отправляем запрос и получаем результат
@@ -65,7 +66,7 @@ func ExampleHandler_CreateShortURLJSON() {
 	// формируем запрос
 	request := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"http://www.yandex.ru/verylongpath"}`)).WithContext(ctx)
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.CreateShortURLText)
 
 	// отправляем запрос и получаем результат
@@ -87,7 +88,7 @@ func ExampleHandler_CreateShortURLBatch() {
 	// формируем запрос
 	request := httptest.NewRequest("POST", "/api/shorten/batch", strings.NewReader(`[{"correlation_id":"1","original_url":"http://www.yandex.ru/verylongpath"}]`)).WithContext(ctx)
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.CreateShortURLBatch)
 
 	// отправляем запрос и получаем результат
@@ -108,7 +109,7 @@ func ExampleHandler_GetUserURLs() {
 	// формируем запрос
 	request := httptest.NewRequest("POST", "/api/user/urls", strings.NewReader(``)).WithContext(_context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy"))
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.GetUserURLs)
 
 	// отправляем запрос и получаем результат
@@ -0,0 +1,326 @@
+// Модуль gRPC сервера приложения.
+package server
+
+import (
+	_context "context"
+	_errors "errors"
+	"net"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/mikesvis/short/internal/alias"
+	"github.com/mikesvis/short/internal/analytics"
+	"github.com/mikesvis/short/internal/api"
+	"github.com/mikesvis/short/internal/context"
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/mikesvis/short/internal/interceptor"
+	pb "github.com/mikesvis/short/internal/proto"
+	"github.com/mikesvis/short/internal/storage"
+	"github.com/mikesvis/short/pkg/urlformat"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer - реализация gRPC сервиса Shortener поверх хендлера приложения: использует тот же storage,
+// аллокатор коротких ключей, ограничитель неудачных попыток ввода пароля и рекордер переходов.
+type GRPCServer struct {
+	pb.UnimplementedShortenerServer
+	h *Handler
+}
+
+// Конструктор gRPC сервера, в нем регистрируется сервис Shortener и интерсепторы авторизации,
+// opts - дополнительные опции сервера (например логирование и TLS).
+func NewGRPCServer(h *Handler, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(
+		interceptor.APIKey(h.storage, domain.APIKeyScopeCreate, pb.Shortener_Shorten_FullMethodName, pb.Shortener_ShortenBatch_FullMethodName),
+		interceptor.APIKey(h.storage, domain.APIKeyScopeRead, pb.Shortener_GetUserURLs_FullMethodName, pb.Shortener_GetDeletion_FullMethodName),
+		interceptor.APIKey(h.storage, domain.APIKeyScopeDelete, pb.Shortener_DeleteUserURLs_FullMethodName),
+		interceptor.SignIn(h.storage, pb.Shortener_Shorten_FullMethodName, pb.Shortener_ShortenBatch_FullMethodName),
+		interceptor.Auth(
+			h.storage,
+			pb.Shortener_GetUserURLs_FullMethodName,
+			pb.Shortener_DeleteUserURLs_FullMethodName,
+			pb.Shortener_GetDeletion_FullMethodName,
+		),
+		interceptor.TrustedSubnet(h.config.TrustedSubnet, pb.Shortener_GetInternalStats_FullMethodName),
+	))
+
+	s := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(s, &GRPCServer{h: h})
+
+	return s
+}
+
+// Сокращение URL, повторяет POST /api/shorten. Если URL был сокращен ранее, возвращается
+// его короткий URL с флагом conflict.
+func (s *GRPCServer) Shorten(ctx _context.Context, in *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	URL := in.GetUrl()
+	if err := urlformat.ValidateURL(URL); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if len(in.GetAlias()) > 0 {
+		if err := alias.Validate(in.GetAlias()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	expiresAt, err := expiration(in.GetTtl(), timeFromProto(in.GetExpiresAt()), time.Now())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err = validateMaxClicks(int(in.GetMaxClicks())); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	passwordHash, err := hashPassword(in.GetPassword())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err = validateRedirectType(int(in.GetRedirectType())); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	item, err := s.h.store(ctx, domain.URL{
+		UserID:       ctx.Value(context.UserIDContextKey).(string),
+		Full:         urlformat.SanitizeURL(URL),
+		ExpiresAt:    expiresAt,
+		MaxClicks:    int(in.GetMaxClicks()),
+		PasswordHash: passwordHash,
+		RedirectType: int(in.GetRedirectType()),
+	}, in.GetAlias())
+	if _errors.Is(err, errors.ErrAliasTaken) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	// запрос уже проверен, остальные ошибки - ошибки storage
+	if err != nil && !_errors.Is(err, errors.ErrConflict) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.ShortenResponse{
+		Result:   urlformat.FormatURL(string(s.h.config.BaseURL), item.Short),
+		Conflict: _errors.Is(err, errors.ErrConflict),
+	}, nil
+}
+
+// Пакетное сокращение URL, повторяет POST /api/shorten/batch.
+func (s *GRPCServer) ShortenBatch(ctx _context.Context, in *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	request := make(api.BatchRequest, len(in.GetItems()))
+	for i, v := range in.GetItems() {
+		request[i].CorrelationID = v.GetCorrelationId()
+		request[i].OriginalURL = v.GetOriginalUrl()
+		request[i].Alias = v.GetAlias()
+		request[i].TTL = v.GetTtl()
+		request[i].ExpiresAt = timeFromProto(v.GetExpiresAt())
+		request[i].MaxClicks = int(v.GetMaxClicks())
+		request[i].Password = v.GetPassword()
+		request[i].RedirectType = int(v.GetRedirectType())
+	}
+
+	stored, err := s.h.storeBatch(ctx, ctx.Value(context.UserIDContextKey).(string), request)
+	if _errors.Is(err, errors.ErrAliasTaken) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	if isRequestError(err) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &pb.ShortenBatchResponse{Items: make([]*pb.BatchResult, 0, len(stored))}
+	for k, v := range stored {
+		response.Items = append(response.Items, &pb.BatchResult{
+			CorrelationId: k,
+			ShortUrl:      urlformat.FormatURL(string(s.h.config.BaseURL), v.Short),
+		})
+	}
+
+	return response, nil
+}
+
+// Получение полного URL по короткому ключу, повторяет GET /{shortKey} и POST /{shortKey} для ссылок с паролем:
+// проверка пароля с ограничением неудачных попыток, учет перехода и запись события перехода.
+func (s *GRPCServer) Resolve(ctx _context.Context, in *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	shortKey := in.GetShortKey()
+	item, err := s.h.available(ctx, shortKey)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	if item.Protected() {
+		if allow, retryAfter := s.h.passwords.Allow(shortKey); !allow {
+			return nil, status.Errorf(codes.ResourceExhausted, "too many wrong password attempts, retry after %s", retryAfter.Round(time.Second))
+		}
+
+		if err = bcrypt.CompareHashAndPassword([]byte(item.PasswordHash), []byte(in.GetPassword())); err != nil {
+			s.h.passwords.Fail(shortKey)
+			return nil, status.Error(codes.PermissionDenied, "wrong password")
+		}
+		s.h.passwords.Reset(shortKey)
+	}
+
+	if err = s.h.countClick(ctx, item); err != nil {
+		return nil, statusFromError(err)
+	}
+
+	s.h.record(analytics.Click{
+		Short:     item.Short,
+		Time:      time.Now(),
+		UserAgent: interceptor.MetadataValue(ctx, "user-agent"),
+		IP:        clientIPFromMetadata(ctx),
+	})
+
+	return &pb.ResolveResponse{
+		OriginalUrl:  item.Full,
+		RedirectType: int32(s.h.redirectType(item)),
+	}, nil
+}
+
+// Получение URL пользователя, повторяет GET /api/user/urls.
+func (s *GRPCServer) GetUserURLs(ctx _context.Context, in *pb.GetUserURLsRequest) (*pb.GetUserURLsResponse, error) {
+	items, err := s.h.storage.GetUserURLs(ctx, ctx.Value(context.UserIDContextKey).(string))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &pb.GetUserURLsResponse{Items: make([]*pb.UserURL, 0, len(items))}
+	for _, v := range items {
+		response.Items = append(response.Items, &pb.UserURL{
+			ShortUrl:     urlformat.FormatURL(string(s.h.config.BaseURL), v.Short),
+			OriginalUrl:  v.Full,
+			RedirectType: int32(s.h.redirectType(v)),
+		})
+	}
+
+	return response, nil
+}
+
+// Удаление URL пользователя, повторяет DELETE /api/user/urls.
+func (s *GRPCServer) DeleteUserURLs(ctx _context.Context, in *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	if _, isDeleter := s.h.storage.(storage.StorageDeleter); !isDeleter {
+		return nil, status.Errorf(codes.Unimplemented, `Batch delete is not supported for storage of type %s`, reflect.TypeOf(s.h.storage).String())
+	}
+
+	// пачки нет
+	if len(in.GetShortKeys()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "short keys are empty")
+	}
+
+	jobID, err := s.h.deleteURLs(ctx, ctx.Value(context.UserIDContextKey).(string), in.GetShortKeys())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &pb.DeleteUserURLsResponse{Id: jobID}, nil
+}
+
+// Статус задания на удаление URL пользователя, повторяет GET /api/user/deletions/{id}.
+// Результаты упорядочены по короткому ключу.
+func (s *GRPCServer) GetDeletion(ctx _context.Context, in *pb.GetDeletionRequest) (*pb.GetDeletionResponse, error) {
+	job, exists := s.h.deletion(in.GetId(), ctx.Value(context.UserIDContextKey).(string))
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "deletion %s is not found", in.GetId())
+	}
+
+	response := &pb.GetDeletionResponse{Id: job.ID, Status: string(job.Status)}
+	for short, result := range job.Results {
+		response.Results = append(response.Results, &pb.DeletionResult{ShortKey: short, Status: string(result)})
+	}
+	sort.Slice(response.Results, func(i, j int) bool {
+		return response.Results[i].GetShortKey() < response.Results[j].GetShortKey()
+	})
+
+	return response, nil
+}
+
+// Прозвон хранилки, повторяет GET /ping.
+func (s *GRPCServer) Ping(ctx _context.Context, in *pb.PingRequest) (*pb.PingResponse, error) {
+	pinger, isPinger := s.h.storage.(storage.StoragePinger)
+	if !isPinger {
+		return nil, status.Errorf(codes.Unimplemented, `Ping is not supported for storage of type %s`, reflect.TypeOf(s.h.storage).String())
+	}
+
+	if err := pinger.Ping(ctx); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.PingResponse{}, nil
+}
+
+// Внутренняя статистика сервиса, повторяет GET /api/internal/stats.
+// Доступ проверяется interceptor.TrustedSubnet.
+func (s *GRPCServer) GetInternalStats(ctx _context.Context, in *pb.GetInternalStatsRequest) (*pb.GetInternalStatsResponse, error) {
+	counter, isCounter := s.h.storage.(storage.StorageCounter)
+	if !isCounter {
+		return nil, status.Errorf(codes.Unimplemented, `Internal stats are not supported for storage of type %s`, reflect.TypeOf(s.h.storage).String())
+	}
+
+	urls, err := counter.CountURLs(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	users, err := counter.CountUsers(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.GetInternalStatsResponse{Urls: int32(urls), Users: int32(users)}, nil
+}
+
+// gRPC статус для ошибки поиска ссылки и учета перехода.
+func statusFromError(err error) error {
+	switch {
+	case _errors.Is(err, errors.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case _errors.Is(err, errors.ErrGone), _errors.Is(err, errors.ErrClickLimitReached):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case _errors.Is(err, errors.ErrNotSupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case _errors.Is(err, errors.ErrDeleteQueueFull), _errors.Is(err, errors.ErrDeleteQueueStopped):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Время из protobuf, nil - время не передано.
+func timeFromProto(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+
+	t := ts.AsTime()
+	return &t
+}
+
+// IP адрес клиента из метаданных interceptor.RealIPMetadataKey, если он передан прокси, иначе адрес соединения.
+func clientIPFromMetadata(ctx _context.Context) string {
+	if ip := interceptor.MetadataValue(ctx, interceptor.RealIPMetadataKey); len(ip) > 0 {
+		return ip
+	}
+
+	p, exists := peer.FromContext(ctx)
+	if !exists {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
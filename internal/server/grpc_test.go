@@ -0,0 +1,335 @@
+package server
+
+import (
+	_context "context"
+	_errors "errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mikesvis/short/internal/apikey"
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/drivers/inmemory"
+	"github.com/mikesvis/short/internal/interceptor"
+	"github.com/mikesvis/short/internal/jwt"
+	"github.com/mikesvis/short/internal/keygen"
+	"github.com/mikesvis/short/internal/logger"
+	pb "github.com/mikesvis/short/internal/proto"
+	"github.com/mikesvis/short/internal/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testGRPCClient(t *testing.T, h *Handler) pb.ShortenerClient {
+	listener := bufconn.Listen(1024 * 1024)
+	s := NewGRPCServer(h)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx _context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewShortenerClient(conn)
+}
+
+func TestGRPCServer_Shorten(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	client := testGRPCClient(t, NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, nil))
+	ctx := _context.Background()
+
+	// без токена создается новый пользователь, токен приходит в заголовке ответа
+	var header metadata.MD
+	created, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://www.yandex.ru/grpc", Alias: "grpc"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/grpc", created.GetResult())
+	assert.False(t, created.GetConflict())
+	require.Len(t, header.Get(interceptor.AuthorizationMetadataKey), 1)
+	require.Len(t, header.Get(interceptor.RefreshMetadataKey), 1)
+
+	authCtx := metadata.AppendToOutgoingContext(ctx, interceptor.AuthorizationMetadataKey, header.Get(interceptor.AuthorizationMetadataKey)[0])
+
+	conflict, err := client.Shorten(authCtx, &pb.ShortenRequest{Url: "http://www.yandex.ru/grpc"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/grpc", conflict.GetResult())
+	assert.True(t, conflict.GetConflict())
+
+	_, err = client.Shorten(authCtx, &pb.ShortenRequest{Url: "http://www.yandex.ru/other", Alias: "grpc"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.Shorten(authCtx, &pb.ShortenRequest{Url: "not an url"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Shorten(authCtx, &pb.ShortenRequest{Url: "http://www.yandex.ru/past", ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour))})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	batch, err := client.ShortenBatch(authCtx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://www.yandex.ru/batch", Alias: "grpcbatch"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, batch.GetItems(), 1)
+	assert.Equal(t, "http://localhost:8080/grpcbatch", batch.GetItems()[0].GetShortUrl())
+
+	urls, err := client.GetUserURLs(authCtx, &pb.GetUserURLsRequest{})
+	require.NoError(t, err)
+	assert.Len(t, urls.GetItems(), 2)
+
+	_, err = client.GetUserURLs(ctx, &pb.GetUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.DeleteUserURLs(authCtx, &pb.DeleteUserURLsRequest{ShortKeys: []string{"grpcbatch"}})
+	require.NoError(t, err)
+
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{ShortKey: "grpcbatch"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// отозванный токен не принимается
+	require.NoError(t, session.Revoke(ctx, s, header.Get(interceptor.AuthorizationMetadataKey)[0]))
+	_, err = client.GetUserURLs(authCtx, &pb.GetUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGRPCServer_SignIn(t *testing.T) {
+	l, _ := logger.NewLogger()
+	client := testGRPCClient(t, NewHandler(testConfig(), inmemory.NewInMemory(l), keygen.NewRandomGenerator(), nil, nil))
+	expired, err := jwt.CreateTokenString("Cacodemon", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	baron, err := session.Issue("Baron")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		access  string
+		refresh string
+		code    codes.Code
+		issued  bool
+	}{
+		{name: "No token creates user", code: codes.OK, issued: true},
+		{name: "Valid token", access: baron.Access, code: codes.OK},
+		{name: "Expired token is refreshed", access: expired, refresh: baron.Refresh, code: codes.OK, issued: true},
+		{name: "Malformed token", access: "idkfa", code: codes.Unauthenticated},
+		{name: "Expired token without refresh", access: expired, code: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := _context.Background()
+			if len(tt.access) > 0 {
+				ctx = metadata.AppendToOutgoingContext(ctx, interceptor.AuthorizationMetadataKey, tt.access)
+			}
+			if len(tt.refresh) > 0 {
+				ctx = metadata.AppendToOutgoingContext(ctx, interceptor.RefreshMetadataKey, tt.refresh)
+			}
+
+			var header metadata.MD
+			_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://www.yandex.ru/signin"}, grpc.Header(&header))
+			require.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.issued, len(header.Get(interceptor.AuthorizationMetadataKey)) > 0)
+		})
+	}
+}
+
+func TestGRPCServer_Resolve(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	passwordHash, _ := hashPassword("iddqd")
+	s.Store(_context.Background(), domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/open", Short: "open", RedirectType: 301})
+	s.Store(_context.Background(), domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/once", Short: "once", MaxClicks: 1})
+	s.Store(_context.Background(), domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/secret", Short: "secret", PasswordHash: passwordHash})
+	client := testGRPCClient(t, NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, nil))
+
+	tests := []struct {
+		name         string
+		request      *pb.ResolveRequest
+		code         codes.Code
+		originalURL  string
+		redirectType int32
+	}{
+		{name: "Link with own redirect type", request: &pb.ResolveRequest{ShortKey: "open"}, code: codes.OK, originalURL: "http://www.yandex.ru/open", redirectType: 301},
+		{name: "Link with click limit", request: &pb.ResolveRequest{ShortKey: "once"}, code: codes.OK, originalURL: "http://www.yandex.ru/once", redirectType: 307},
+		{name: "Link with exhausted click limit", request: &pb.ResolveRequest{ShortKey: "once"}, code: codes.FailedPrecondition},
+		{name: "Link with wrong password", request: &pb.ResolveRequest{ShortKey: "secret", Password: "idkfa"}, code: codes.PermissionDenied},
+		{name: "Link with password", request: &pb.ResolveRequest{ShortKey: "secret", Password: "iddqd"}, code: codes.OK, originalURL: "http://www.yandex.ru/secret", redirectType: 307},
+		{name: "Missing link", request: &pb.ResolveRequest{ShortKey: "missing"}, code: codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := client.Resolve(_context.Background(), tt.request)
+			require.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.originalURL, response.GetOriginalUrl())
+			assert.Equal(t, tt.redirectType, response.GetRedirectType())
+		})
+	}
+}
+
+func TestGRPCServer_GetInternalStats(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	s.Store(_context.Background(), domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/1", Short: "one"})
+	c := testConfig()
+	c.TrustedSubnet = "192.168.1.0/24"
+	client := testGRPCClient(t, NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil))
+
+	tests := []struct {
+		name   string
+		realIP string
+		code   codes.Code
+	}{
+		{name: "IP from trusted subnet", realIP: "192.168.1.15", code: codes.OK},
+		{name: "IP outside trusted subnet", realIP: "10.0.0.1", code: codes.PermissionDenied},
+		{name: "No IP", code: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := _context.Background()
+			if len(tt.realIP) > 0 {
+				ctx = metadata.AppendToOutgoingContext(ctx, interceptor.RealIPMetadataKey, tt.realIP)
+			}
+
+			response, err := client.GetInternalStats(ctx, &pb.GetInternalStatsRequest{})
+			require.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				assert.Equal(t, int32(1), response.GetUrls())
+				assert.Equal(t, int32(1), response.GetUsers())
+			}
+		})
+	}
+}
+
+// Хранилка, у которой сохранение ссылок всегда завершается ошибкой.
+type failingStorage struct {
+	*inmemory.InMemory
+}
+
+func (s failingStorage) Store(ctx _context.Context, u domain.URL) (domain.URL, error) {
+	return domain.URL{}, _errors.New("connection refused")
+}
+
+func (s failingStorage) StoreBatch(ctx _context.Context, pack map[string]domain.URL) (map[string]domain.URL, error) {
+	return nil, _errors.New("connection refused")
+}
+
+func TestGRPCServer_ShortenErrors(t *testing.T) {
+	l, _ := logger.NewLogger()
+	client := testGRPCClient(t, NewHandler(testConfig(), failingStorage{inmemory.NewInMemory(l)}, keygen.NewRandomGenerator(), nil, nil))
+	ctx := _context.Background()
+
+	_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "http://www.yandex.ru/grpc", Ttl: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "http://www.yandex.ru/grpc"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://www.yandex.ru/batch", Alias: "debug"},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://www.yandex.ru/batch"},
+	}})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestGRPCServer_APIKey(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	key, creator, err := apikey.New("DoomGuy", "ci", []domain.APIKeyScope{domain.APIKeyScopeCreate}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, s.StoreAPIKey(_context.Background(), key))
+	key, admin, err := apikey.New("DoomGuy", "admin", nil, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, s.StoreAPIKey(_context.Background(), key))
+	client := testGRPCClient(t, NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, nil))
+
+	withKey := func(plain string) _context.Context {
+		return metadata.AppendToOutgoingContext(_context.Background(), interceptor.APIKeyMetadataKey, plain)
+	}
+
+	// по API ключу ссылка создается от имени владельца ключа, токены не выдаются
+	var header metadata.MD
+	_, err = client.Shorten(withKey(creator), &pb.ShortenRequest{Url: "http://www.yandex.ru/apikey", Alias: "apikey"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get(interceptor.AuthorizationMetadataKey))
+
+	_, err = client.ShortenBatch(withKey(creator), &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "1", OriginalUrl: "http://www.yandex.ru/apikeybatch", Alias: "apikeybatch"},
+	}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{
+			name: "Key without read scope",
+			call: func() error {
+				_, err := client.GetUserURLs(withKey(creator), &pb.GetUserURLsRequest{})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "Key without delete scope",
+			call: func() error {
+				_, err := client.DeleteUserURLs(withKey(creator), &pb.DeleteUserURLsRequest{ShortKeys: []string{"apikey"}})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "Key without read scope for deletion",
+			call: func() error {
+				_, err := client.GetDeletion(withKey(creator), &pb.GetDeletionRequest{Id: "missing"})
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "Unknown key",
+			call: func() error {
+				_, err := client.Shorten(withKey("sk_idkfa"), &pb.ShortenRequest{Url: "http://www.yandex.ru/unknown"})
+				return err
+			},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "Key with all scopes reads links",
+			call: func() error {
+				urls, err := client.GetUserURLs(withKey(admin), &pb.GetUserURLsRequest{})
+				if err == nil {
+					assert.Len(t, urls.GetItems(), 2)
+				}
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "Key with all scopes deletes links",
+			call: func() error {
+				_, err := client.DeleteUserURLs(withKey(admin), &pb.DeleteUserURLsRequest{ShortKeys: []string{"apikey"}})
+				return err
+			},
+			code: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, status.Code(tt.call()))
+		})
+	}
+}
@@ -7,66 +7,232 @@ import (
 	_errors "errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/mikesvis/short/internal/alias"
+	"github.com/mikesvis/short/internal/analytics"
 	"github.com/mikesvis/short/internal/api"
+	"github.com/mikesvis/short/internal/apikey"
 	"github.com/mikesvis/short/internal/config"
 	"github.com/mikesvis/short/internal/context"
+	"github.com/mikesvis/short/internal/deleter"
 	"github.com/mikesvis/short/internal/domain"
 	"github.com/mikesvis/short/internal/errors"
 	"github.com/mikesvis/short/internal/keygen"
+	"github.com/mikesvis/short/internal/metrics"
+	"github.com/mikesvis/short/internal/ratelimit"
 	"github.com/mikesvis/short/internal/storage"
 	"github.com/mikesvis/short/pkg/urlformat"
 )
 
-// Хендлер приложения, включает в себя *config.Config и storage.Storage.
+const (
+	// Количество дней дневной статистики переходов по-умолчанию.
+	statsDefaultDays = 30
+
+	// Максимальное количество дней дневной статистики переходов.
+	statsMaxDays = 365
+)
+
+// Хендлер приложения, включает в себя *config.Config, storage.Storage, аллокатор коротких ключей,
+// ограничитель неудачных попыток ввода пароля ссылок, рекордер переходов и очередь удаления.
 type Handler struct {
-	config  *config.Config
-	storage storage.Storage
+	config    *config.Config
+	storage   storage.Storage
+	keys      *keygen.Allocator
+	passwords *ratelimit.Limiter
+	recorder  *analytics.Recorder
+	deletions *deleter.Deleter
 }
 
-// Конструктор хендлера
-func NewHandler(config *config.Config, storage storage.Storage) *Handler {
-	return &Handler{config, storage}
+// Конструктор хендлера, generator - стратегия генерации коротких ключей, recorder - рекордер переходов
+// (nil - переходы не записываются), deletions - фоновая очередь удаления (nil - ссылки удаляются синхронно).
+func NewHandler(config *config.Config, storage storage.Storage, generator keygen.KeyGenerator, recorder *analytics.Recorder, deletions *deleter.Deleter) *Handler {
+	return &Handler{
+		config,
+		storage,
+		keygen.NewAllocator(generator, keygen.KeyLength),
+		ratelimit.New(passwordMaxAttempts, passwordAttemptsWindow),
+		recorder,
+		deletions,
+	}
 }
 
 // Обработка Get
 // Получение короткого URL из запроса
 // Поиск в условной "базе" полного URL по сокращенному
+// Вывод формы пароля для ссылок с паролем
+// Учет перехода для ссылок с лимитом переходов
+// Запись события перехода
 func (h *Handler) GetFullURL(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := _context.WithCancel(r.Context())
 	defer cancel()
 
 	shortKey := strings.TrimLeft(r.RequestURI, "/")
-	item, err := h.storage.GetByShort(ctx, shortKey)
+	item, ok := h.findAvailable(ctx, w, shortKey)
+	if !ok {
+		return
+	}
+
+	if item.Protected() {
+		renderPasswordForm(w, shortKey, http.StatusOK, "")
+
+		return
+	}
+
+	if !h.click(ctx, w, item) {
+		return
+	}
+
+	h.recordClick(r, item)
+	w.Header().Set("Location", item.Full)
+	w.WriteHeader(h.redirectType(item))
+}
+
+// Запись события перехода по ссылке.
+func (h *Handler) recordClick(r *http.Request, item domain.URL) {
+	h.record(analytics.Click{
+		Short:     item.Short,
+		Time:      time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	})
+}
+
+// Учет перехода в метриках и запись события перехода в рекордер.
+func (h *Handler) record(click analytics.Click) {
+	metrics.Redirects.WithLabelValues(metrics.RedirectHit).Inc()
+	h.recorder.Record(click)
+}
+
+// IP адрес клиента из заголовка X-Real-IP, если он передан прокси, иначе адрес соединения.
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); len(ip) > 0 {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// HTTP статус редиректа ссылки: собственный статус ссылки либо статус по-умолчанию из конфига.
+func (h *Handler) redirectType(item domain.URL) int {
+	if item.RedirectType != 0 {
+		return item.RedirectType
+	}
+
+	if h.config.RedirectType != 0 {
+		return h.config.RedirectType
+	}
+
+	return http.StatusTemporaryRedirect
+}
+
+// Поиск доступной ссылки по короткому ключу. Если ссылки нет, она удалена, истекла или
+// исчерпала лимит переходов, в ответ пишется ошибка и возвращается false.
+func (h *Handler) findAvailable(ctx _context.Context, w http.ResponseWriter, shortKey string) (domain.URL, bool) {
+	item, err := h.available(ctx, shortKey)
+	if _errors.Is(err, errors.ErrGone) {
+		w.WriteHeader(http.StatusGone)
+
+		return item, false
+	}
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 
-		return
+		return item, false
+	}
+
+	return item, true
+}
+
+// Поиск доступной ссылки по короткому ключу. Если ссылки нет, возвращается ErrNotFound,
+// если она удалена, истекла или исчерпала лимит переходов - ErrGone.
+func (h *Handler) available(ctx _context.Context, shortKey string) (domain.URL, error) {
+	item, err := h.storage.GetByShort(ctx, shortKey)
+	if err != nil {
+		return item, err
 	}
 
 	if (item == domain.URL{}) {
-		err := fmt.Errorf("full url is not found for %s", shortKey)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		metrics.Redirects.WithLabelValues(metrics.RedirectMiss).Inc()
 
-		return
+		return item, fmt.Errorf("%w for %s", errors.ErrNotFound, shortKey)
+	}
+
+	if item.Deleted || item.Expired(time.Now()) || item.ClicksExhausted() {
+		metrics.Redirects.WithLabelValues(metrics.RedirectGone).Inc()
+
+		return item, errors.ErrGone
 	}
 
-	if item.Deleted {
+	return item, nil
+}
+
+// Учет перехода по ссылке с лимитом переходов. Если лимит исчерпан или учет не удался,
+// в ответ пишется ошибка и возвращается false.
+func (h *Handler) click(ctx _context.Context, w http.ResponseWriter, item domain.URL) bool {
+	err := h.countClick(ctx, item)
+	if _errors.Is(err, errors.ErrClickLimitReached) {
 		w.WriteHeader(http.StatusGone)
 
-		return
+		return false
 	}
 
-	w.Header().Set("Location", item.Full)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	if _errors.Is(err, errors.ErrNotSupported) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return false
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return false
+	}
+
+	return true
+}
+
+// Учет перехода по ссылке с лимитом переходов. Если лимит исчерпан, возвращается ErrClickLimitReached,
+// если storage не поддерживает лимит переходов - ErrNotSupported.
+func (h *Handler) countClick(ctx _context.Context, item domain.URL) error {
+	if item.MaxClicks == 0 {
+		return nil
+	}
+
+	clicker, isClicker := h.storage.(storage.StorageClicker)
+	if !isClicker {
+		return fmt.Errorf(`click limit is %w for storage of type %s`, errors.ErrNotSupported, reflect.TypeOf(h.storage).String())
+	}
+
+	_, err := clicker.Click(ctx, item.Short)
+	if _errors.Is(err, errors.ErrClickLimitReached) {
+		metrics.Redirects.WithLabelValues(metrics.RedirectGone).Inc()
+	}
+
+	return err
 }
 
 // Обработка POST
 // Проверка на пустое тело запроса
 // Проверка на валидность URL
+// Проверка алиаса из параметра alias, если он передан
+// Проверка срока жизни из параметров ttl (секунды) или expires_at (RFC3339), если они переданы
+// Проверка лимита переходов из параметра max_clicks, если он передан
+// Хеширование пароля из заголовка X-Link-Password, если он передан
+// Проверка статуса редиректа из параметра redirect_type, если он передан
 // Запись сокращенного Url в условную "базу" если нет такого ключа
 func (h *Handler) CreateShortURLText(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := _context.WithCancel(r.Context())
@@ -86,15 +252,60 @@ func (h *Handler) CreateShortURLText(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	URL := urlformat.SanitizeURL(string(body))
-	item := domain.URL{
-		UserID: ctx.Value(context.UserIDContextKey).(string),
-		Full:   URL,
-		Short:  h.storage.GetRandkey(keygen.KeyLength),
+	aliasKey := r.URL.Query().Get("alias")
+	if len(aliasKey) > 0 {
+		if err = alias.Validate(aliasKey); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	expiresAt, err := expirationFromQuery(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	maxClicks, err := maxClicksFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	passwordHash, err := hashPassword(r.Header.Get(PasswordHeader))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
 	}
+
+	redirectType, err := redirectTypeFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	URL := urlformat.SanitizeURL(string(body))
+	item, err := h.store(ctx, domain.URL{
+		UserID:       ctx.Value(context.UserIDContextKey).(string),
+		Full:         URL,
+		ExpiresAt:    expiresAt,
+		MaxClicks:    maxClicks,
+		PasswordHash: passwordHash,
+		RedirectType: redirectType,
+	}, aliasKey)
 	status := http.StatusConflict
 
-	item, err = h.storage.Store(ctx, item)
+	if _errors.Is(err, errors.ErrAliasTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return
+	}
+
 	if err != nil && !_errors.Is(err, errors.ErrConflict) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 
@@ -110,6 +321,128 @@ func (h *Handler) CreateShortURLText(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(urlformat.FormatURL(string(h.config.BaseURL), item.Short)))
 }
 
+// Сохранение ссылки с подбором свободного короткого ключа. Если передан алиас, он используется
+// как короткий ключ без повторных попыток, занятый алиас возвращает ErrAliasTaken.
+func (h *Handler) store(ctx _context.Context, item domain.URL, aliasKey string) (domain.URL, error) {
+	if len(aliasKey) > 0 {
+		item.Short = aliasKey
+		stored, err := h.storage.Store(ctx, item)
+		if _errors.Is(err, errors.ErrShortKeyConflict) {
+			return stored, fmt.Errorf("alias %s: %w", aliasKey, errors.ErrAliasTaken)
+		}
+
+		return stored, err
+	}
+
+	var stored domain.URL
+	err := h.keys.Allocate(func(next func(full string) string) error {
+		item.Short = next(item.Full)
+
+		var err error
+		stored, err = h.storage.Store(ctx, item)
+		return err
+	})
+
+	return stored, err
+}
+
+// Время истечения ссылки по ttl в секундах или абсолютному времени at. Нулевое время - ссылка бессрочная.
+// Оба параметра сразу, отрицательный ttl и время в прошлом считаются ошибкой.
+func expiration(ttl int64, at *time.Time, now time.Time) (time.Time, error) {
+	if ttl != 0 && at != nil {
+		return time.Time{}, _errors.New("ttl and expires_at can not be used together")
+	}
+
+	if ttl < 0 {
+		return time.Time{}, fmt.Errorf("ttl %d is negative", ttl)
+	}
+
+	if ttl > 0 {
+		return now.Add(time.Duration(ttl) * time.Second), nil
+	}
+
+	if at == nil {
+		return time.Time{}, nil
+	}
+
+	if !at.After(now) {
+		return time.Time{}, fmt.Errorf("expires_at %s is in the past", at.Format(time.RFC3339))
+	}
+
+	return *at, nil
+}
+
+// Время истечения ссылки из параметров запроса ttl и expires_at.
+func expirationFromQuery(r *http.Request, now time.Time) (time.Time, error) {
+	var ttl int64
+	if v := r.URL.Query().Get("ttl"); len(v) > 0 {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("ttl %s is not a number", v)
+		}
+		ttl = parsed
+	}
+
+	var at *time.Time
+	if v := r.URL.Query().Get("expires_at"); len(v) > 0 {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("expires_at %s is not in RFC3339 format", v)
+		}
+		at = &parsed
+	}
+
+	return expiration(ttl, at, now)
+}
+
+// Проверка лимита переходов, нулевой лимит - без ограничения.
+func validateMaxClicks(maxClicks int) error {
+	if maxClicks < 0 {
+		return fmt.Errorf("max_clicks %d is negative", maxClicks)
+	}
+
+	return nil
+}
+
+// Лимит переходов из параметра запроса max_clicks.
+func maxClicksFromQuery(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("max_clicks")
+	if len(v) == 0 {
+		return 0, nil
+	}
+
+	maxClicks, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("max_clicks %s is not a number", v)
+	}
+
+	return maxClicks, validateMaxClicks(maxClicks)
+}
+
+// Проверка статуса редиректа, нулевой статус - статус по-умолчанию из конфига.
+func validateRedirectType(redirectType int) error {
+	if redirectType != 0 && !domain.ValidRedirectType(redirectType) {
+		return fmt.Errorf("redirect_type %d is not supported, use one of 301, 302, 307 or 308", redirectType)
+	}
+
+	return nil
+}
+
+// Статус редиректа из параметра запроса redirect_type.
+func redirectTypeFromQuery(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("redirect_type")
+	if len(v) == 0 {
+		return 0, nil
+	}
+
+	redirectType, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("redirect_type %s is not a number", v)
+	}
+
+	return redirectType, validateRedirectType(redirectType)
+}
+
 // Обработка всего остального
 func (h *Handler) Fail(w http.ResponseWriter, r *http.Request) {
 	err := _errors.New("bad protocol")
@@ -120,6 +453,11 @@ func (h *Handler) Fail(w http.ResponseWriter, r *http.Request) {
 // Проверка на битый JSON
 // Проверка на пустой URL
 // Проверка на валидность URL
+// Проверка алиаса, если он передан
+// Проверка срока жизни ttl или expires_at, если он передан
+// Проверка лимита переходов max_clicks, если он передан
+// Хеширование пароля, если он передан
+// Проверка статуса редиректа redirect_type, если он передан
 // Запись сокращенного URL в условную "базу" если нет такого ключа
 func (h *Handler) CreateShortURLJSON(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := _context.WithCancel(r.Context())
@@ -139,15 +477,57 @@ func (h *Handler) CreateShortURLJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	URL = urlformat.SanitizeURL(URL)
-	item := domain.URL{
-		UserID: ctx.Value(context.UserIDContextKey).(string),
-		Full:   URL,
-		Short:  h.storage.GetRandkey(keygen.KeyLength),
+	if len(request.Alias) > 0 {
+		if err = alias.Validate(request.Alias); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	expiresAt, err := expiration(request.TTL, request.ExpiresAt, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err = validateMaxClicks(request.MaxClicks); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
 	}
+
+	passwordHash, err := hashPassword(request.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err = validateRedirectType(request.RedirectType); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	URL = urlformat.SanitizeURL(URL)
+	item, err := h.store(ctx, domain.URL{
+		UserID:       ctx.Value(context.UserIDContextKey).(string),
+		Full:         URL,
+		ExpiresAt:    expiresAt,
+		MaxClicks:    request.MaxClicks,
+		PasswordHash: passwordHash,
+		RedirectType: request.RedirectType,
+	}, request.Alias)
 	status := http.StatusConflict
 
-	item, err = h.storage.Store(ctx, item)
+	if _errors.Is(err, errors.ErrAliasTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return
+	}
+
 	if err != nil && !_errors.Is(err, errors.ErrConflict) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 
@@ -193,6 +573,10 @@ func (h *Handler) Ping(w http.ResponseWriter, r *http.Request) {
 // Генерация коротких урлов пачкой
 // Проверка на пустой URL
 // Проверка на валидность URL
+// Проверка алиасов, переданных для отдельных URL
+// Проверка сроков жизни и лимитов переходов, переданных для отдельных URL
+// Хеширование паролей, переданных для отдельных URL
+// Проверка статусов редиректа, переданных для отдельных URL
 // Запись сокращенного URL в условную "базу" если нет такого ключа
 func (h *Handler) CreateShortURLBatch(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := _context.WithCancel(r.Context())
@@ -204,18 +588,12 @@ func (h *Handler) CreateShortURLBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// генерим потенциальные domain.URL на сохранение с новым Short
-	pack := make(map[string]domain.URL)
-	for _, v := range request {
-		pack[string(v.CorrelationID)] = domain.URL{
-			UserID: ctx.Value(context.UserIDContextKey).(string),
-			Full:   string(v.OriginalURL),
-			Short:  h.storage.GetRandkey(keygen.KeyLength),
-		}
+	stored, err := h.storeBatch(ctx, ctx.Value(context.UserIDContextKey).(string), request)
+	if _errors.Is(err, errors.ErrAliasTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	}
 
-	// domain.URL.Short в процессе сохранения поменяем на старый если такой domain.URL.Full уже есть
-	stored, err := h.storage.StoreBatch(ctx, pack)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -240,6 +618,124 @@ func (h *Handler) CreateShortURLBatch(w http.ResponseWriter, r *http.Request) {
 	jsonEncoder.Encode(response)
 }
 
+// Пакетное сохранение ссылок пользователя userID с подбором свободных коротких ключей.
+// Проверяются сроки жизни, лимиты переходов, статусы редиректа и алиасы, хешируются пароли,
+// ошибки проверки возвращаются как requestError. Занятый алиас возвращает ErrAliasTaken.
+func (h *Handler) storeBatch(ctx _context.Context, userID string, request api.BatchRequest) (map[string]domain.URL, error) {
+	// ключ корреляции = время истечения, хеш пароля
+	now := time.Now()
+	expirations := make(map[string]time.Time, len(request))
+	passwordHashes := make(map[string]string, len(request))
+	for _, v := range request {
+		expiresAt, err := expiration(v.TTL, v.ExpiresAt, now)
+		if err != nil {
+			return nil, requestError{err}
+		}
+		expirations[v.CorrelationID] = expiresAt
+
+		if err = validateMaxClicks(v.MaxClicks); err != nil {
+			return nil, requestError{err}
+		}
+
+		passwordHashes[v.CorrelationID], err = hashPassword(v.Password)
+		if err != nil {
+			return nil, requestError{err}
+		}
+
+		if err = validateRedirectType(v.RedirectType); err != nil {
+			return nil, requestError{err}
+		}
+	}
+
+	// алиас = полный URL
+	aliases := make(map[string]string)
+	for _, v := range request {
+		if len(v.Alias) == 0 {
+			continue
+		}
+
+		if err := alias.Validate(v.Alias); err != nil {
+			return nil, requestError{err}
+		}
+
+		if _, exists := aliases[v.Alias]; exists {
+			return nil, requestError{fmt.Errorf("alias %s is used more than once", v.Alias)}
+		}
+		aliases[v.Alias] = string(v.OriginalURL)
+	}
+
+	// domain.URL.Short в процессе сохранения поменяем на старый если такой domain.URL.Full уже есть
+	var stored map[string]domain.URL
+	err := h.keys.Allocate(func(next func(full string) string) error {
+		// генерим потенциальные domain.URL на сохранение с новым Short, при коллизии - заново
+		pack := make(map[string]domain.URL, len(request))
+		for _, v := range request {
+			short := v.Alias
+			if len(short) == 0 {
+				short = next(string(v.OriginalURL))
+			}
+
+			pack[string(v.CorrelationID)] = domain.URL{
+				UserID:       userID,
+				Full:         string(v.OriginalURL),
+				Short:        short,
+				ExpiresAt:    expirations[v.CorrelationID],
+				MaxClicks:    v.MaxClicks,
+				PasswordHash: passwordHashes[v.CorrelationID],
+				RedirectType: v.RedirectType,
+			}
+		}
+
+		var err error
+		stored, err = h.storage.StoreBatch(ctx, pack)
+		if _errors.Is(err, errors.ErrShortKeyConflict) && len(aliases) > 0 {
+			// коллизия могла случиться на алиасе - тогда повторять бессмысленно
+			if takenErr := h.checkAliases(ctx, aliases); takenErr != nil {
+				return takenErr
+			}
+		}
+
+		return err
+	})
+
+	return stored, err
+}
+
+// Ошибка проверки запроса клиента, в отличие от ошибок storage.
+type requestError struct {
+	err error
+}
+
+func (e requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e requestError) Unwrap() error {
+	return e.err
+}
+
+// Проверка, что ошибка err - ошибка проверки запроса клиента.
+func isRequestError(err error) bool {
+	var e requestError
+	return _errors.As(err, &e)
+}
+
+// Проверка, что алиасы не заняты другими ссылками. aliases - мапа алиас = полный URL.
+func (h *Handler) checkAliases(ctx _context.Context, aliases map[string]string) error {
+	for a, full := range aliases {
+		item, err := h.storage.GetByShort(ctx, a)
+		if err != nil {
+			return err
+		}
+
+		if (item != domain.URL{}) && item.Full != full {
+			return fmt.Errorf("alias %s: %w", a, errors.ErrAliasTaken)
+		}
+	}
+
+	return nil
+}
+
 // Обработка /api/user/urls GET
 // Получение URL пользователя
 func (h *Handler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
@@ -263,11 +759,77 @@ func (h *Handler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 	for _, v := range items {
 		// не понимаю что мы тут сократили, по моему с UserResponseItem было лучше (но исправил по замечанию ревью)
 		response = append(response, struct {
-			ShortURL    string `json:"short_url"`
-			OriginalURL string `json:"original_url"`
+			ShortURL     string `json:"short_url"`
+			OriginalURL  string `json:"original_url"`
+			RedirectType int    `json:"redirect_type"`
 		}{
-			ShortURL:    urlformat.FormatURL(string(h.config.BaseURL), v.Short),
-			OriginalURL: v.Full,
+			ShortURL:     urlformat.FormatURL(string(h.config.BaseURL), v.Short),
+			OriginalURL:  v.Full,
+			RedirectType: h.redirectType(v),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(response)
+}
+
+// Обработка /api/user/urls/{short}/stats GET
+// Проверка, что ссылка принадлежит пользователю
+// Статистика переходов за все время и по дням за последние days дней (по-умолчанию statsDefaultDays)
+func (h *Handler) GetUserURLStats(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	if h.recorder == nil {
+		http.Error(w, `Click statistics are not enabled`, http.StatusInternalServerError)
+
+		return
+	}
+
+	days := statsDefaultDays
+	if v := r.URL.Query().Get("days"); len(v) > 0 {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 || parsed > statsMaxDays {
+			http.Error(w, fmt.Sprintf("days must be a number from 1 to %d", statsMaxDays), http.StatusBadRequest)
+
+			return
+		}
+		days = parsed
+	}
+
+	short := chi.URLParam(r, "short")
+	item, err := h.storage.GetByShort(ctx, short)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	// чужие ссылки не отличаются от несуществующих
+	if (item == domain.URL{}) || item.UserID != ctx.Value(context.UserIDContextKey).(string) {
+		http.Error(w, fmt.Sprintf("url %s is not found", short), http.StatusNotFound)
+
+		return
+	}
+
+	stats, err := h.recorder.Stats(ctx, short, days)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	response := api.StatsResponse{Total: stats.Total, Unique: stats.Unique}
+	for _, v := range stats.Daily {
+		response.Daily = append(response.Daily, struct {
+			Date   string `json:"date"`
+			Clicks int    `json:"clicks"`
+		}{
+			Date:   v.Date,
+			Clicks: v.Clicks,
 		})
 	}
 
@@ -280,6 +842,7 @@ func (h *Handler) GetUserURLs(w http.ResponseWriter, r *http.Request) {
 
 // Обработка /api/user/urls DELETE
 // Удаление URL пользователя
+// Постановка в очередь на удаление, в ответе ID задания для /api/user/deletions/{id}
 func (h *Handler) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := _context.WithCancel(r.Context())
 	defer cancel()
@@ -303,7 +866,239 @@ func (h *Handler) DeleteUserURLs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.storage.(storage.StorageDeleter).DeleteBatch(ctx, ctx.Value(context.UserIDContextKey).(string), []string(request))
+	jobID, err := h.deleteURLs(ctx, ctx.Value(context.UserIDContextKey).(string), []string(request))
+	if _errors.Is(err, errors.ErrDeleteQueueFull) || _errors.Is(err, errors.ErrDeleteQueueStopped) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	// без очереди ссылки уже удалены, задания нет
+	if jobID == "" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/user/deletions/%s", jobID))
 	w.WriteHeader(http.StatusAccepted)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(api.BatchDeleteResponse{ID: jobID})
+}
+
+// Удаление ключей пользователя: постановка в фоновую очередь с возвратом ID задания,
+// либо синхронное удаление, если очереди нет. Наличие StorageDeleter проверяется вызывающим.
+func (h *Handler) deleteURLs(ctx _context.Context, userID string, shorts []string) (string, error) {
+	if h.deletions != nil {
+		return h.deletions.Enqueue(ctx, userID, shorts)
+	}
+
+	_, err := h.storage.(storage.StorageDeleter).DeleteBatch(ctx, userID, shorts)
+	return "", err
+}
+
+// Обработка /api/user/deletions/{id} GET
+// Статус задания на удаление URL пользователя и результат удаления по каждому ключу
+func (h *Handler) GetDeletion(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	id := chi.URLParam(r, "id")
+	job, exists := h.deletion(id, ctx.Value(context.UserIDContextKey).(string))
+	// чужие задания не отличаются от несуществующих
+	if !exists {
+		http.Error(w, fmt.Sprintf("deletion %s is not found", id), http.StatusNotFound)
+
+		return
+	}
+
+	response := api.DeletionResponse{ID: job.ID, Status: string(job.Status)}
+	if len(job.Results) > 0 {
+		response.Results = make(map[string]string, len(job.Results))
+		for short, status := range job.Results {
+			response.Results[short] = string(status)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(response)
+}
+
+// Задание на удаление id пользователя userID. Без очереди заданий нет.
+func (h *Handler) deletion(id string, userID string) (deleter.Job, bool) {
+	if h.deletions == nil {
+		return deleter.Job{}, false
+	}
+
+	return h.deletions.Job(id, userID)
+}
+
+// Обработка /api/user/keys POST
+// Выпуск API ключа пользователя с названием и правами, ключ показывается в ответе один раз
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	keyer, isKeyer := h.storage.(storage.StorageAPIKeyer)
+	if !isKeyer {
+		http.Error(w, fmt.Sprintf(`API keys are not supported for storage of type %s`, reflect.TypeOf(h.storage).String()), http.StatusInternalServerError)
+
+		return
+	}
+
+	var request api.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scopes := make([]domain.APIKeyScope, 0, len(request.Scopes))
+	for _, v := range request.Scopes {
+		scopes = append(scopes, domain.APIKeyScope(v))
+	}
+
+	key, plain, err := apikey.New(ctx.Value(context.UserIDContextKey).(string), request.Name, scopes, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = keyer.StoreAPIKey(ctx, key); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := apiKeyResponse(key)
+	response.Key = plain
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(response)
+}
+
+// Обработка /api/user/keys GET
+// Получение API ключей пользователя без самих ключей
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	keyer, isKeyer := h.storage.(storage.StorageAPIKeyer)
+	if !isKeyer {
+		http.Error(w, fmt.Sprintf(`API keys are not supported for storage of type %s`, reflect.TypeOf(h.storage).String()), http.StatusInternalServerError)
+
+		return
+	}
+
+	keys, err := keyer.GetUserAPIKeys(ctx, ctx.Value(context.UserIDContextKey).(string))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response := make([]api.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, apiKeyResponse(key))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(response)
+}
+
+// Обработка /api/user/keys/{id} DELETE
+// Отзыв API ключа пользователя, запросы с ним перестают авторизовываться сразу
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	keyer, isKeyer := h.storage.(storage.StorageAPIKeyer)
+	if !isKeyer {
+		http.Error(w, fmt.Sprintf(`API keys are not supported for storage of type %s`, reflect.TypeOf(h.storage).String()), http.StatusInternalServerError)
+
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	revoked, err := keyer.RevokeAPIKey(ctx, ctx.Value(context.UserIDContextKey).(string), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// чужие ключи не отличаются от несуществующих
+	if !revoked {
+		http.Error(w, fmt.Sprintf("key %s is not found", id), http.StatusNotFound)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Описание API ключа для ответа, без самого ключа.
+func apiKeyResponse(key domain.APIKey) api.APIKeyResponse {
+	response := api.APIKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Scopes:    make([]string, 0, len(key.Scopes)),
+		CreatedAt: key.CreatedAt,
+	}
+
+	for _, scope := range key.Scopes {
+		response.Scopes = append(response.Scopes, string(scope))
+	}
+
+	return response
+}
+
+// Обработка /api/internal/stats GET
+// Доступ проверяется middleware.TrustedSubnet
+// Количество сокращенных URL и пользователей в сервисе
+func (h *Handler) GetInternalStats(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	counter, isCounter := h.storage.(storage.StorageCounter)
+	if !isCounter {
+		http.Error(w, fmt.Sprintf(`Internal stats are not supported for storage of type %s`, reflect.TypeOf(h.storage).String()), http.StatusInternalServerError)
+
+		return
+	}
+
+	urls, err := counter.CountURLs(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	users, err := counter.CountUsers(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	jsonEncoder := json.NewEncoder(w)
+	jsonEncoder.Encode(api.InternalStatsResponse{URLs: urls, Users: users})
 }
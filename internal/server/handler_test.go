@@ -2,22 +2,38 @@ package server
 
 import (
 	_context "context"
+	"encoding/json"
 	goerrors "errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
+	"github.com/mikesvis/short/internal/analytics"
+	"github.com/mikesvis/short/internal/api"
+	"github.com/mikesvis/short/internal/apikey"
 	"github.com/mikesvis/short/internal/config"
 	"github.com/mikesvis/short/internal/context"
+	"github.com/mikesvis/short/internal/deleter"
 	"github.com/mikesvis/short/internal/domain"
 	"github.com/mikesvis/short/internal/drivers/inmemory"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/mikesvis/short/internal/jwt"
+	"github.com/mikesvis/short/internal/keygen"
 	"github.com/mikesvis/short/internal/logger"
+	"github.com/mikesvis/short/internal/metrics"
+	"github.com/mikesvis/short/internal/middleware"
+	"github.com/mikesvis/short/internal/session"
 	"github.com/mikesvis/short/internal/storage"
+	mock_keygen "github.com/mikesvis/short/mocks/keygen"
 	mock_storage "github.com/mikesvis/short/mocks/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -54,6 +70,12 @@ func TestGetFullURL(t *testing.T) {
 			Short:   "short3",
 			Deleted: true,
 		}, nil),
+		mockedStorage.EXPECT().GetByShort(ctx, "short4").Return(domain.URL{
+			UserID:    "DoomGuy",
+			Full:      "http://www.yandex.ru/verylongpath",
+			Short:     "short4",
+			ExpiresAt: time.Now().Add(-time.Minute),
+		}, nil),
 	)
 
 	type want struct {
@@ -102,13 +124,23 @@ func TestGetFullURL(t *testing.T) {
 				methhod: "GET",
 				target:  "/short3",
 			},
+		}, {
+			name: "Item has expired",
+			want: want{
+				statusCode:  http.StatusGone,
+				newLocation: "",
+			},
+			request: request{
+				methhod: "GET",
+				target:  "/short4",
+			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(tt.request.methhod, tt.request.target, nil)
 			w := httptest.NewRecorder()
-			handler := NewHandler(c, mockedStorage)
+			handler := NewHandler(c, mockedStorage, keygen.NewRandomGenerator(), nil, nil)
 			handle := http.HandlerFunc(handler.GetFullURL)
 			handle(w, request)
 			result := w.Result()
@@ -143,7 +175,7 @@ func BenchmarkGetFullURL(b *testing.B) {
 
 	request := httptest.NewRequest("GET", "/short", nil)
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.GetFullURL)
 
 	b.ResetTimer()
@@ -167,8 +199,9 @@ func TestCreateShortURLText(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	mockedStorage := mock_storage.NewMockStorageDeleter(ctrl)
+	mockedGenerator := mock_keygen.NewMockKeyGenerator(ctrl)
 
-	mockedStorage.EXPECT().GetRandkey(uint(5)).Return("jHQri").Times(1)
+	mockedGenerator.EXPECT().Generate(gomock.Any(), uint(5), gomock.Any()).Return("jHQri").Times(1)
 	mockedStorage.EXPECT().Store(ctxMock, gomock.Any()).Return(domain.URL{
 		UserID: "DoomGuy",
 		Full:   "http://www.yandex.ru/verylongpath",
@@ -253,7 +286,7 @@ func TestCreateShortURLText(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(tt.request.method, tt.request.target, strings.NewReader(tt.request.body)).WithContext(ctxReq)
 			w := httptest.NewRecorder()
-			handler := NewHandler(c, mockedStorage)
+			handler := NewHandler(c, mockedStorage, mockedGenerator, nil, nil)
 			handle := http.HandlerFunc(handler.CreateShortURLText)
 			handle(w, request)
 			result := w.Result()
@@ -296,7 +329,7 @@ func BenchmarkCreateShortURLText(b *testing.B) {
 
 	request := httptest.NewRequest("POST", "/", strings.NewReader("http://www.yandex.ru/verylongpath")).WithContext(ctx)
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.CreateShortURLText)
 
 	b.ResetTimer()
@@ -309,7 +342,7 @@ func TestFail(t *testing.T) {
 	c := testConfig()
 	l, _ := logger.NewLogger()
 	s := inmemory.NewInMemory(l)
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 
 	type request struct {
 		method string
@@ -416,7 +449,7 @@ func TestCreateShortURLJSON(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(tt.request.method, tt.request.target, strings.NewReader(tt.request.body)).WithContext(ctx)
 			w := httptest.NewRecorder()
-			handler := NewHandler(c, s)
+			handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 			handle := http.HandlerFunc(handler.CreateShortURLJSON)
 			handle(w, request)
 			result := w.Result()
@@ -459,7 +492,7 @@ func BenchmarkCreateShortURLJSON(b *testing.B) {
 
 	request := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"http://www.yandex.ru/verylongpath"}`)).WithContext(ctx)
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.CreateShortURLText)
 
 	b.ResetTimer()
@@ -483,8 +516,9 @@ func TestCreateShortURLBatch(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	mockedStorage := mock_storage.NewMockStorageDeleter(ctrl)
+	mockedGenerator := mock_keygen.NewMockKeyGenerator(ctrl)
 
-	mockedStorage.EXPECT().GetRandkey(uint(5)).Return("short1")
+	mockedGenerator.EXPECT().Generate(gomock.Any(), uint(5), gomock.Any()).Return("short1")
 	mockedStorage.EXPECT().StoreBatch(ctxMock, map[string]domain.URL{
 		"1": {
 			UserID:  "DoomGuy",
@@ -538,7 +572,7 @@ func TestCreateShortURLBatch(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(tt.request.method, tt.request.target, strings.NewReader(tt.request.body)).WithContext(ctxReq)
 			w := httptest.NewRecorder()
-			handler := NewHandler(c, mockedStorage)
+			handler := NewHandler(c, mockedStorage, mockedGenerator, nil, nil)
 			handle := http.HandlerFunc(handler.CreateShortURLBatch)
 			handle(w, request)
 			result := w.Result()
@@ -583,7 +617,7 @@ func BenchmarkCreateShortURLBatch(b *testing.B) {
 
 	request := httptest.NewRequest("POST", "/api/shorten/batch", strings.NewReader(`[{"correlation_id":"1","original_url":"http://www.yandex.ru/verylongpath"}]`)).WithContext(ctx)
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.CreateShortURLBatch)
 
 	b.ResetTimer()
@@ -638,7 +672,7 @@ func TestGetUserURLs(t *testing.T) {
 				contentType: "application/json",
 				statusCode:  http.StatusOK,
 				wantError:   false,
-				body:        `[{"original_url":"http://www.yandex.ru/verylongpath","short_url":"` + string(c.BaseURL) + `/short"}]`,
+				body:        `[{"original_url":"http://www.yandex.ru/verylongpath","redirect_type":307,"short_url":"` + string(c.BaseURL) + `/short"}]`,
 			},
 			request: request{
 				method: "POST",
@@ -667,7 +701,7 @@ func TestGetUserURLs(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(tt.request.method, tt.request.target, strings.NewReader(tt.request.body)).WithContext(tt.request.ctx)
 			w := httptest.NewRecorder()
-			handler := NewHandler(c, mockedStorage)
+			handler := NewHandler(c, mockedStorage, keygen.NewRandomGenerator(), nil, nil)
 			handle := http.HandlerFunc(handler.GetUserURLs)
 			handle(w, request)
 			result := w.Result()
@@ -706,7 +740,7 @@ func BenchmarkGetUserURLs(b *testing.B) {
 
 	request := httptest.NewRequest("POST", "/api/user/urls", strings.NewReader(``)).WithContext(_context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy"))
 	w := httptest.NewRecorder()
-	handler := NewHandler(c, s)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	handle := http.HandlerFunc(handler.GetUserURLs)
 
 	b.ResetTimer()
@@ -763,18 +797,18 @@ func TestHandler_Ping(t *testing.T) {
 			},
 		},
 		{
-			name: "Ping file db fail",
+			name: "File db fail",
 			args: args{
 				config: &config.Config{
 					ServerAddress:   "127.0.0.1",
 					BaseURL:         "http://short.go",
-					FileStoragePath: "dummyfile.bin",
+					FileStoragePath: os.TempDir() + "/nodir/dummyfile.bin",
 					DatabaseDSN:     "",
 				},
 			},
 			want: want{
-				wantError:  false,
-				statusCode: http.StatusInternalServerError,
+				wantError:  true,
+				statusCode: http.StatusNotFound,
 			},
 		},
 		{
@@ -801,7 +835,7 @@ func TestHandler_Ping(t *testing.T) {
 			if tt.want.wantError {
 				require.Error(t, err)
 			}
-			handler := NewHandler(tt.args.config, s)
+			handler := NewHandler(tt.args.config, s, keygen.NewRandomGenerator(), nil, nil)
 			handle := http.HandlerFunc(handler.Ping)
 			handle(w, request)
 			result := w.Result()
@@ -827,7 +861,7 @@ func TestHander_DeleteUserURLs(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	mockedStorage := mock_storage.NewMockStorageDeleter(ctrl)
-	mockedStorage.EXPECT().DeleteBatch(ctxMock, "DoomGuy", []string{"short1", "short2"}).Return()
+	mockedStorage.EXPECT().DeleteBatch(ctxMock, "DoomGuy", []string{"short1", "short2"}).Return(map[string]domain.DeleteStatus{"short1": domain.DeleteStatusDeleted, "short2": domain.DeleteStatusNotFound}, nil)
 
 	type want struct {
 		statusCode int
@@ -872,7 +906,7 @@ func TestHander_DeleteUserURLs(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			request := httptest.NewRequest(tt.request.method, tt.request.target, strings.NewReader(tt.request.body)).WithContext(ctxReq)
 			w := httptest.NewRecorder()
-			handler := NewHandler(c, tt.arg)
+			handler := NewHandler(c, tt.arg, keygen.NewRandomGenerator(), nil, nil)
 			handle := http.HandlerFunc(handler.DeleteUserURLs)
 			handle(w, request)
 			result := w.Result()
@@ -882,3 +916,1217 @@ func TestHander_DeleteUserURLs(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateShortURLText_ShortKeyCollision(t *testing.T) {
+	c := testConfig()
+	ctxReq := _context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockedStorage := mock_storage.NewMockStorageDeleter(ctrl)
+	mockedGenerator := mock_keygen.NewMockKeyGenerator(ctrl)
+
+	gomock.InOrder(
+		mockedGenerator.EXPECT().Generate(gomock.Any(), uint(5), gomock.Any()).Return("taken"),
+		mockedStorage.EXPECT().Store(gomock.Any(), gomock.Any()).Return(domain.URL{}, errors.ErrShortKeyConflict),
+		mockedGenerator.EXPECT().Generate(gomock.Any(), uint(5), gomock.Any()).Return("jHQri"),
+		mockedStorage.EXPECT().Store(gomock.Any(), domain.URL{
+			UserID: "DoomGuy",
+			Full:   "http://www.yandex.ru/verylongpath",
+			Short:  "jHQri",
+		}).Return(domain.URL{
+			UserID: "DoomGuy",
+			Full:   "http://www.yandex.ru/verylongpath",
+			Short:  "jHQri",
+		}, nil),
+	)
+
+	request := httptest.NewRequest("POST", "/", strings.NewReader("http://www.yandex.ru/verylongpath")).WithContext(ctxReq)
+	w := httptest.NewRecorder()
+	handler := NewHandler(c, mockedStorage, mockedGenerator, nil, nil)
+	handle := http.HandlerFunc(handler.CreateShortURLText)
+	handle(w, request)
+	result := w.Result()
+
+	response, err := io.ReadAll(result.Body)
+	require.NoError(t, err)
+	err = result.Body.Close()
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusCreated, result.StatusCode)
+	assert.Equal(t, "http://localhost:8080/jHQri", string(response))
+}
+
+func TestCreateShortURL_Alias(t *testing.T) {
+	c := testConfig()
+	ctxReq := _context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy")
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	s.Store(ctxReq, domain.URL{
+		UserID: "Heretic",
+		Full:   "http://www.yandex.ru/taken",
+		Short:  "taken-alias",
+	})
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
+
+	type want struct {
+		statusCode int
+		body       string
+	}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+		want    want
+	}{
+		{
+			name:    "Text with alias (201)",
+			handler: handler.CreateShortURLText,
+			target:  "/?alias=spring-sale",
+			body:    "http://www.yandex.ru/spring",
+			want:    want{statusCode: http.StatusCreated, body: "http://localhost:8080/spring-sale"},
+		},
+		{
+			name:    "Text with taken alias (409)",
+			handler: handler.CreateShortURLText,
+			target:  "/?alias=taken-alias",
+			body:    "http://www.yandex.ru/other",
+			want:    want{statusCode: http.StatusConflict, body: "alias taken-alias: alias is already taken"},
+		},
+		{
+			name:    "Text with reserved alias (400)",
+			handler: handler.CreateShortURLText,
+			target:  "/?alias=api",
+			body:    "http://www.yandex.ru/other",
+			want:    want{statusCode: http.StatusBadRequest, body: "alias api is reserved"},
+		},
+		{
+			name:    "JSON with alias (201)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/summer","alias":"summer_sale"}`,
+			want:    want{statusCode: http.StatusCreated, body: `{"result":"http://localhost:8080/summer_sale"}`},
+		},
+		{
+			name:    "JSON with bad alias (400)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/summer2","alias":"summer sale"}`,
+			want:    want{statusCode: http.StatusBadRequest, body: "alias may contain only"},
+		},
+		{
+			name:    "JSON with taken alias (409)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/summer3","alias":"spring-sale"}`,
+			want:    want{statusCode: http.StatusConflict, body: "alias is already taken"},
+		},
+		{
+			name:    "Batch with alias (201)",
+			handler: handler.CreateShortURLBatch,
+			target:  "/api/shorten/batch",
+			body:    `[{"correlation_id":"1","original_url":"http://www.yandex.ru/autumn","alias":"autumn-sale"}]`,
+			want:    want{statusCode: http.StatusCreated, body: `[{"correlation_id":"1","short_url":"http://localhost:8080/autumn-sale"}]`},
+		},
+		{
+			name:    "Batch with taken alias (409)",
+			handler: handler.CreateShortURLBatch,
+			target:  "/api/shorten/batch",
+			body:    `[{"correlation_id":"1","original_url":"http://www.yandex.ru/winter"},{"correlation_id":"2","original_url":"http://www.yandex.ru/winter2","alias":"taken-alias"}]`,
+			want:    want{statusCode: http.StatusConflict, body: "alias taken-alias: alias is already taken"},
+		},
+		{
+			name:    "Batch with duplicated alias (400)",
+			handler: handler.CreateShortURLBatch,
+			target:  "/api/shorten/batch",
+			body:    `[{"correlation_id":"1","original_url":"http://www.yandex.ru/a","alias":"twice"},{"correlation_id":"2","original_url":"http://www.yandex.ru/b","alias":"twice"}]`,
+			want:    want{statusCode: http.StatusBadRequest, body: "alias twice is used more than once"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body)).WithContext(ctxReq)
+			w := httptest.NewRecorder()
+			tt.handler(w, request)
+			result := w.Result()
+
+			response, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Contains(t, string(response), tt.want.body)
+		})
+	}
+}
+
+func TestCreateShortURL_Expiration(t *testing.T) {
+	c := testConfig()
+	ctxReq := _context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy")
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	type want struct {
+		statusCode int
+		body       string
+		expires    bool
+	}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+		full    string
+		want    want
+	}{
+		{
+			name:    "Text with ttl (201)",
+			handler: handler.CreateShortURLText,
+			target:  "/?ttl=60",
+			body:    "http://www.yandex.ru/ttl",
+			full:    "http://www.yandex.ru/ttl",
+			want:    want{statusCode: http.StatusCreated, expires: true},
+		},
+		{
+			name:    "Text with expires_at (201)",
+			handler: handler.CreateShortURLText,
+			target:  "/?expires_at=" + future,
+			body:    "http://www.yandex.ru/expires",
+			full:    "http://www.yandex.ru/expires",
+			want:    want{statusCode: http.StatusCreated, expires: true},
+		},
+		{
+			name:    "Text with bad ttl (400)",
+			handler: handler.CreateShortURLText,
+			target:  "/?ttl=minute",
+			body:    "http://www.yandex.ru/bad",
+			want:    want{statusCode: http.StatusBadRequest, body: "ttl minute is not a number"},
+		},
+		{
+			name:    "JSON with ttl (201)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/json-ttl","ttl":60}`,
+			full:    "http://www.yandex.ru/json-ttl",
+			want:    want{statusCode: http.StatusCreated, expires: true},
+		},
+		{
+			name:    "JSON with negative ttl (400)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/json-bad","ttl":-1}`,
+			want:    want{statusCode: http.StatusBadRequest, body: "ttl -1 is negative"},
+		},
+		{
+			name:    "JSON with expires_at in the past (400)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/json-bad","expires_at":"` + past + `"}`,
+			want:    want{statusCode: http.StatusBadRequest, body: "is in the past"},
+		},
+		{
+			name:    "JSON with both ttl and expires_at (400)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/json-bad","ttl":60,"expires_at":"` + future + `"}`,
+			want:    want{statusCode: http.StatusBadRequest, body: "ttl and expires_at can not be used together"},
+		},
+		{
+			name:    "Batch with ttl (201)",
+			handler: handler.CreateShortURLBatch,
+			target:  "/api/shorten/batch",
+			body:    `[{"correlation_id":"1","original_url":"http://www.yandex.ru/batch-ttl","ttl":60}]`,
+			full:    "http://www.yandex.ru/batch-ttl",
+			want:    want{statusCode: http.StatusCreated, expires: true},
+		},
+		{
+			name:    "Batch with negative ttl (400)",
+			handler: handler.CreateShortURLBatch,
+			target:  "/api/shorten/batch",
+			body:    `[{"correlation_id":"1","original_url":"http://www.yandex.ru/batch-bad","ttl":-5}]`,
+			want:    want{statusCode: http.StatusBadRequest, body: "ttl -5 is negative"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body)).WithContext(ctxReq)
+			w := httptest.NewRecorder()
+			tt.handler(w, request)
+			result := w.Result()
+
+			response, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Contains(t, string(response), tt.want.body)
+
+			if !tt.want.expires {
+				return
+			}
+
+			item, err := s.GetByFull(ctxReq, tt.full)
+			require.NoError(t, err)
+			assert.True(t, item.ExpiresAt.After(time.Now()))
+		})
+	}
+}
+
+func TestGetFullURL_MaxClicks(t *testing.T) {
+	c := testConfig()
+	ctxReq := _context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy")
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
+
+	request := httptest.NewRequest("POST", "/?max_clicks=2&alias=invite", strings.NewReader("http://www.yandex.ru/invite")).WithContext(ctxReq)
+	w := httptest.NewRecorder()
+	handler.CreateShortURLText(w, request)
+	result := w.Result()
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+
+	tests := []struct {
+		name       string
+		statusCode int
+	}{
+		{
+			name:       "First click (307)",
+			statusCode: http.StatusTemporaryRedirect,
+		},
+		{
+			name:       "Second click (307)",
+			statusCode: http.StatusTemporaryRedirect,
+		},
+		{
+			name:       "Limit reached (410)",
+			statusCode: http.StatusGone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/invite", nil)
+			w := httptest.NewRecorder()
+			handler.GetFullURL(w, request)
+			result := w.Result()
+			result.Body.Close()
+
+			assert.Equal(t, tt.statusCode, result.StatusCode)
+		})
+	}
+}
+
+func TestCreateShortURL_MaxClicks(t *testing.T) {
+	c := testConfig()
+	ctxReq := _context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy")
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
+
+	type want struct {
+		statusCode int
+		body       string
+		maxClicks  int
+	}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+		full    string
+		want    want
+	}{
+		{
+			name:    "Text with max_clicks (201)",
+			handler: handler.CreateShortURLText,
+			target:  "/?max_clicks=1",
+			body:    "http://www.yandex.ru/once",
+			full:    "http://www.yandex.ru/once",
+			want:    want{statusCode: http.StatusCreated, maxClicks: 1},
+		},
+		{
+			name:    "Text with bad max_clicks (400)",
+			handler: handler.CreateShortURLText,
+			target:  "/?max_clicks=once",
+			body:    "http://www.yandex.ru/bad",
+			want:    want{statusCode: http.StatusBadRequest, body: "max_clicks once is not a number"},
+		},
+		{
+			name:    "JSON with max_clicks (201)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/json-once","max_clicks":3}`,
+			full:    "http://www.yandex.ru/json-once",
+			want:    want{statusCode: http.StatusCreated, maxClicks: 3},
+		},
+		{
+			name:    "JSON with negative max_clicks (400)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/json-bad","max_clicks":-3}`,
+			want:    want{statusCode: http.StatusBadRequest, body: "max_clicks -3 is negative"},
+		},
+		{
+			name:    "Batch with max_clicks (201)",
+			handler: handler.CreateShortURLBatch,
+			target:  "/api/shorten/batch",
+			body:    `[{"correlation_id":"1","original_url":"http://www.yandex.ru/batch-once","max_clicks":2}]`,
+			full:    "http://www.yandex.ru/batch-once",
+			want:    want{statusCode: http.StatusCreated, maxClicks: 2},
+		},
+		{
+			name:    "Batch with negative max_clicks (400)",
+			handler: handler.CreateShortURLBatch,
+			target:  "/api/shorten/batch",
+			body:    `[{"correlation_id":"1","original_url":"http://www.yandex.ru/batch-bad","max_clicks":-1}]`,
+			want:    want{statusCode: http.StatusBadRequest, body: "max_clicks -1 is negative"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body)).WithContext(ctxReq)
+			w := httptest.NewRecorder()
+			tt.handler(w, request)
+			result := w.Result()
+
+			response, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Contains(t, string(response), tt.want.body)
+
+			if tt.want.maxClicks == 0 {
+				return
+			}
+
+			item, err := s.GetByFull(ctxReq, tt.full)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.maxClicks, item.MaxClicks)
+		})
+	}
+}
+
+func TestUnlockFullURL(t *testing.T) {
+	c := testConfig()
+	ctxReq := _context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy")
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
+
+	request := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url":"http://www.yandex.ru/secret","alias":"secret","password":"idkfa"}`)).WithContext(ctxReq)
+	w := httptest.NewRecorder()
+	handler.CreateShortURLJSON(w, request)
+	result := w.Result()
+	result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+
+	item, err := s.GetByShort(ctxReq, "secret")
+	require.NoError(t, err)
+	require.True(t, item.Protected())
+	assert.NotEqual(t, "idkfa", item.PasswordHash)
+
+	type want struct {
+		statusCode  int
+		newLocation string
+		body        string
+	}
+	tests := []struct {
+		name     string
+		method   string
+		password string
+		want     want
+	}{
+		{
+			name:   "Password form instead of redirect (200)",
+			method: "GET",
+			want:   want{statusCode: http.StatusOK, body: `<form method="post" action="/secret">`},
+		},
+		{
+			name:     "Wrong password (401)",
+			method:   "POST",
+			password: "iddqd",
+			want:     want{statusCode: http.StatusUnauthorized, body: "Wrong password"},
+		},
+		{
+			name:     "Correct password (303)",
+			method:   "POST",
+			password: "idkfa",
+			want:     want{statusCode: http.StatusSeeOther, newLocation: "http://www.yandex.ru/secret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/secret", strings.NewReader("password="+tt.password))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			router := NewRouter(handler)
+			router.ServeHTTP(w, request)
+			result := w.Result()
+
+			response, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Contains(t, string(response), tt.want.body)
+			if len(tt.want.newLocation) > 0 {
+				assert.Equal(t, tt.want.newLocation, result.Header.Get("Location"))
+			}
+		})
+	}
+}
+
+func TestUnlockFullURL_RateLimit(t *testing.T) {
+	c := testConfig()
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	passwordHash, err := hashPassword("idkfa")
+	require.NoError(t, err)
+	s.Store(ctx, domain.URL{Full: "http://www.yandex.ru/secret", Short: "secret", PasswordHash: passwordHash})
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
+
+	unlock := func(password string) *http.Response {
+		request := httptest.NewRequest("POST", "/secret", strings.NewReader("password="+password))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.UnlockFullURL(w, request)
+		result := w.Result()
+		result.Body.Close()
+
+		return result
+	}
+
+	for i := 0; i < passwordMaxAttempts; i++ {
+		assert.Equal(t, http.StatusUnauthorized, unlock("iddqd").StatusCode)
+	}
+
+	// после исчерпания попыток не принимается даже верный пароль
+	result := unlock("idkfa")
+	assert.Equal(t, http.StatusTooManyRequests, result.StatusCode)
+	assert.NotEmpty(t, result.Header.Get("Retry-After"))
+}
+
+func Test_hashPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantHash bool
+		wantErr  bool
+	}{
+		{
+			name:     "Empty password",
+			password: "",
+		},
+		{
+			name:     "Password is hashed",
+			password: "idkfa",
+			wantHash: true,
+		},
+		{
+			name:     "Too long password",
+			password: strings.Repeat("a", passwordMaxLength+1),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := hashPassword(tt.password)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantHash, len(hash) > 0)
+		})
+	}
+}
+
+func TestGetFullURL_RedirectType(t *testing.T) {
+	c := testConfig()
+	c.RedirectType = http.StatusPermanentRedirect
+	ctxReq := _context.WithValue(_context.Background(), context.UserIDContextKey, "DoomGuy")
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
+
+	type want struct {
+		createStatus   int
+		body           string
+		redirectStatus int
+	}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+		alias   string
+		want    want
+	}{
+		{
+			name:    "Default redirect type from config (308)",
+			handler: handler.CreateShortURLText,
+			target:  "/?alias=default",
+			body:    "http://www.yandex.ru/default",
+			alias:   "default",
+			want:    want{createStatus: http.StatusCreated, redirectStatus: http.StatusPermanentRedirect},
+		},
+		{
+			name:    "Text with redirect_type (302)",
+			handler: handler.CreateShortURLText,
+			target:  "/?alias=legacy&redirect_type=302",
+			body:    "http://www.yandex.ru/legacy",
+			alias:   "legacy",
+			want:    want{createStatus: http.StatusCreated, redirectStatus: http.StatusFound},
+		},
+		{
+			name:    "JSON with redirect_type (301)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/seo","alias":"seo","redirect_type":301}`,
+			alias:   "seo",
+			want:    want{createStatus: http.StatusCreated, redirectStatus: http.StatusMovedPermanently},
+		},
+		{
+			name:    "Batch with redirect_type (307)",
+			handler: handler.CreateShortURLBatch,
+			target:  "/api/shorten/batch",
+			body:    `[{"correlation_id":"1","original_url":"http://www.yandex.ru/temp","alias":"temp","redirect_type":307}]`,
+			alias:   "temp",
+			want:    want{createStatus: http.StatusCreated, redirectStatus: http.StatusTemporaryRedirect},
+		},
+		{
+			name:    "Text with unsupported redirect_type (400)",
+			handler: handler.CreateShortURLText,
+			target:  "/?redirect_type=303",
+			body:    "http://www.yandex.ru/bad",
+			want:    want{createStatus: http.StatusBadRequest, body: "redirect_type 303 is not supported"},
+		},
+		{
+			name:    "JSON with unsupported redirect_type (400)",
+			handler: handler.CreateShortURLJSON,
+			target:  "/api/shorten",
+			body:    `{"url":"http://www.yandex.ru/bad","redirect_type":200}`,
+			want:    want{createStatus: http.StatusBadRequest, body: "redirect_type 200 is not supported"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body)).WithContext(ctxReq)
+			w := httptest.NewRecorder()
+			tt.handler(w, request)
+			result := w.Result()
+
+			response, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.createStatus, result.StatusCode)
+			assert.Contains(t, string(response), tt.want.body)
+
+			if len(tt.alias) == 0 {
+				return
+			}
+
+			request = httptest.NewRequest("GET", "/"+tt.alias, nil)
+			w = httptest.NewRecorder()
+			handler.GetFullURL(w, request)
+			result = w.Result()
+			result.Body.Close()
+
+			assert.Equal(t, tt.want.redirectStatus, result.StatusCode)
+		})
+	}
+}
+
+func TestGetUserURLStats(t *testing.T) {
+	c := testConfig()
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/stats", Short: "stats"})
+	s.Store(ctx, domain.URL{UserID: "Heretic", Full: "http://www.yandex.ru/other", Short: "other"})
+	recorder := analytics.NewRecorder(analytics.NewMemoryStore(), 100, false, l)
+	handler := NewHandler(c, s, keygen.NewRandomGenerator(), recorder, nil)
+
+	for _, userAgent := range []string{"Doom", "Doom", "Quake"} {
+		request := httptest.NewRequest("GET", "/stats", nil)
+		request.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		handler.GetFullURL(w, request)
+		w.Result().Body.Close()
+	}
+
+	recorderCtx, stopRecorder := _context.WithCancel(ctx)
+	stopRecorder()
+	recorder.Run(recorderCtx)
+
+	type want struct {
+		statusCode int
+		body       string
+	}
+	tests := []struct {
+		name   string
+		short  string
+		target string
+		want   want
+	}{
+		{
+			name:   "Stats of own link (200)",
+			short:  "stats",
+			target: "/api/user/urls/stats/stats?days=1",
+			want: want{
+				statusCode: http.StatusOK,
+				body:       `{"total":3,"unique":2,"daily":[{"date":"` + time.Now().UTC().Format(analytics.DateLayout) + `","clicks":3}]}`,
+			},
+		},
+		{
+			name:   "Stats of other user link (404)",
+			short:  "other",
+			target: "/api/user/urls/other/stats",
+			want:   want{statusCode: http.StatusNotFound, body: "url other is not found"},
+		},
+		{
+			name:   "Bad days (400)",
+			short:  "stats",
+			target: "/api/user/urls/stats/stats?days=0",
+			want:   want{statusCode: http.StatusBadRequest, body: "days must be a number from 1 to 365"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("short", tt.short)
+			ctxReq := _context.WithValue(_context.WithValue(ctx, chi.RouteCtxKey, routeCtx), context.UserIDContextKey, "DoomGuy")
+
+			request := httptest.NewRequest("GET", tt.target, nil).WithContext(ctxReq)
+			w := httptest.NewRecorder()
+			handler.GetUserURLStats(w, request)
+			result := w.Result()
+
+			response, err := io.ReadAll(result.Body)
+			require.NoError(t, err)
+			err = result.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.statusCode, result.StatusCode)
+			assert.Contains(t, string(response), tt.want.body)
+		})
+	}
+}
+
+func TestRouter_Metrics(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	s.Store(_context.Background(), domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/hit", Short: "metricshit"})
+	s.Store(_context.Background(), domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/gone", Short: "metricsgone", Deleted: true})
+	router := NewRouter(NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, nil), middleware.Metrics)
+	router.Handle("/metrics", metrics.Handler())
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		result string
+	}{
+		{name: "Redirect hit", target: "/metricshit", status: http.StatusTemporaryRedirect, result: metrics.RedirectHit},
+		{name: "Redirect miss", target: "/metricsmiss", status: http.StatusBadRequest, result: metrics.RedirectMiss},
+		{name: "Redirect gone", target: "/metricsgone", status: http.StatusGone, result: metrics.RedirectGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirects := testutil.ToFloat64(metrics.Redirects.WithLabelValues(tt.result))
+			requests := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/{shortKey}", http.MethodGet, strconv.Itoa(tt.status)))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			assert.Equal(t, tt.status, w.Code)
+
+			assert.Equal(t, redirects+1, testutil.ToFloat64(metrics.Redirects.WithLabelValues(tt.result)))
+			assert.Equal(t, requests+1, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/{shortKey}", http.MethodGet, strconv.Itoa(tt.status))))
+		})
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `http_request_duration_seconds_bucket{method="GET",route="/{shortKey}",status="307"`)
+	assert.Contains(t, body, `storage_operation_duration_seconds_count{driver="inmemory",method="GetByShort"}`)
+	assert.Contains(t, body, `delete_batch_queue_depth 0`)
+}
+
+func TestGetInternalStats(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	s.Store(_context.Background(), domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/1", Short: "statsone"})
+	s.Store(_context.Background(), domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/2", Short: "statstwo"})
+	s.Store(_context.Background(), domain.URL{UserID: "Cacodemon", Full: "http://www.yandex.ru/3", Short: "statsthree"})
+
+	type want struct {
+		status int
+		body   string
+	}
+	tests := []struct {
+		name          string
+		trustedSubnet string
+		realIP        string
+		want          want
+	}{
+		{
+			name:          "IP from trusted subnet (200)",
+			trustedSubnet: "192.168.1.0/24",
+			realIP:        "192.168.1.15",
+			want:          want{status: http.StatusOK, body: `{"urls":3,"users":2}`},
+		},
+		{
+			name:          "IP outside trusted subnet (403)",
+			trustedSubnet: "192.168.1.0/24",
+			realIP:        "10.0.0.1",
+			want:          want{status: http.StatusForbidden},
+		},
+		{
+			name:          "No X-Real-IP (403)",
+			trustedSubnet: "192.168.1.0/24",
+			want:          want{status: http.StatusForbidden},
+		},
+		{
+			name:   "Trusted subnet is not set (403)",
+			realIP: "192.168.1.15",
+			want:   want{status: http.StatusForbidden},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.TrustedSubnet = tt.trustedSubnet
+			router := NewRouter(NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			if len(tt.realIP) > 0 {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.want.status, w.Code)
+			if len(tt.want.body) > 0 {
+				assert.JSONEq(t, tt.want.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetDeletion(t *testing.T) {
+	ctx := _context.Background()
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/mine", Short: "mine"})
+	s.Store(ctx, domain.URL{UserID: "Heretic", Full: "http://www.yandex.ru/theirs", Short: "theirs"})
+	s.Store(ctx, domain.URL{UserID: "DoomGuy", Full: "http://www.yandex.ru/gone", Short: "gone", Deleted: true})
+	deletions := deleter.New(s, 10, l)
+	handler := NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, deletions)
+
+	userCtx := _context.WithValue(ctx, context.UserIDContextKey, "DoomGuy")
+	request := httptest.NewRequest("DELETE", "/api/user/urls", strings.NewReader(`["mine","theirs","gone","missing"]`)).WithContext(userCtx)
+	w := httptest.NewRecorder()
+	handler.DeleteUserURLs(w, request)
+	result := w.Result()
+	defer result.Body.Close()
+
+	var created api.BatchDeleteResponse
+	require.Equal(t, http.StatusAccepted, result.StatusCode)
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&created))
+	assert.Equal(t, "/api/user/deletions/"+created.ID, result.Header.Get("Location"))
+
+	getDeletion := func(id, userID string) (int, string) {
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("id", id)
+		ctxReq := _context.WithValue(_context.WithValue(ctx, chi.RouteCtxKey, routeCtx), context.UserIDContextKey, userID)
+
+		request := httptest.NewRequest("GET", "/api/user/deletions/"+id, nil).WithContext(ctxReq)
+		w := httptest.NewRecorder()
+		handler.GetDeletion(w, request)
+		result := w.Result()
+		defer result.Body.Close()
+
+		response, err := io.ReadAll(result.Body)
+		require.NoError(t, err)
+
+		return result.StatusCode, string(response)
+	}
+
+	// до обработки очереди задание ожидает удаления
+	statusCode, response := getDeletion(created.ID, "DoomGuy")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.JSONEq(t, `{"id":"`+created.ID+`","status":"pending"}`, response)
+
+	deletionsCtx, stopDeletions := _context.WithCancel(ctx)
+	stopDeletions()
+	deletions.Run(deletionsCtx)
+
+	type want struct {
+		statusCode int
+		body       string
+	}
+	tests := []struct {
+		name   string
+		id     string
+		userID string
+		want   want
+	}{
+		{
+			name:   "Own finished deletion (200)",
+			id:     created.ID,
+			userID: "DoomGuy",
+			want: want{
+				statusCode: http.StatusOK,
+				body:       `{"id":"` + created.ID + `","status":"done","results":{"mine":"deleted","theirs":"not_owned","gone":"already_deleted","missing":"not_found"}}`,
+			},
+		},
+		{
+			name:   "Deletion of other user (404)",
+			id:     created.ID,
+			userID: "Heretic",
+			want:   want{statusCode: http.StatusNotFound},
+		},
+		{
+			name:   "Unknown deletion (404)",
+			id:     "unknown",
+			userID: "DoomGuy",
+			want:   want{statusCode: http.StatusNotFound},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusCode, response := getDeletion(tt.id, tt.userID)
+			assert.Equal(t, tt.want.statusCode, statusCode)
+			if tt.want.statusCode == http.StatusOK {
+				assert.JSONEq(t, tt.want.body, response)
+			}
+		})
+	}
+
+	item, err := s.GetByShort(ctx, "mine")
+	require.NoError(t, err)
+	assert.True(t, item.Deleted)
+}
+
+func TestAPIKeys(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	router := NewRouter(NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, nil))
+
+	tokenString, err := jwt.CreateTokenString("DoomGuy", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	serve := func(method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			request.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+	owner := map[string]string{jwt.AuthorizationHeaderName: jwt.BearerScheme + " " + tokenString}
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{name: "Empty name (400)", body: `{"name":""}`, statusCode: http.StatusBadRequest},
+		{name: "Unknown scope (400)", body: `{"name":"ci","scopes":["admin"]}`, statusCode: http.StatusBadRequest},
+		{name: "Bad JSON (400)", body: `{"name":`, statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve("POST", "/api/user/keys", tt.body, owner)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+
+	// без токена ключи не выпускаются
+	w := serve("POST", "/api/user/keys", `{"name":"ci"}`, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serve("GET", "/api/user/keys", "", owner)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = serve("POST", "/api/user/keys", `{"name":"ci","scopes":["create","read"]}`, owner)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created api.APIKeyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, "ci", created.Name)
+	assert.Equal(t, []string{"create", "read"}, created.Scopes)
+	require.NotEmpty(t, created.Key)
+	ci := map[string]string{apikey.HeaderName: created.Key}
+
+	// ключ работает от имени владельца в рамках своих прав
+	w = serve("POST", "/api/shorten", `{"url":"http://www.yandex.ru/release-notes"}`, ci)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+
+	urls, err := s.GetUserURLs(_context.Background(), "DoomGuy")
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+
+	w = serve("GET", "/api/user/urls", "", ci)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve("DELETE", "/api/user/urls", `["`+urls[0].Short+`"]`, ci)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// ключом ключи не выпускаются
+	w = serve("POST", "/api/user/keys", `{"name":"other"}`, ci)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// в списке ключей сам ключ не отдается
+	w = serve("GET", "/api/user/keys", "", owner)
+	require.Equal(t, http.StatusOK, w.Code)
+	var keys []api.APIKeyResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&keys))
+	require.Len(t, keys, 1)
+	assert.Equal(t, created.ID, keys[0].ID)
+	assert.Empty(t, keys[0].Key)
+
+	otherToken, err := jwt.CreateTokenString("Heretic", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	w = serve("DELETE", "/api/user/keys/"+created.ID, "", map[string]string{jwt.AuthorizationHeaderName: jwt.BearerScheme + " " + otherToken})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serve("DELETE", "/api/user/keys/"+created.ID, "", owner)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// отозванный ключ больше не принимается
+	w = serve("GET", "/api/user/urls", "", ci)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	router := NewRouter(NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, nil))
+
+	type response struct {
+		statusCode int
+		body       api.AuthResponse
+		token      string
+	}
+	serve := func(target, body, token string) response {
+		request := httptest.NewRequest("POST", target, strings.NewReader(body))
+		if len(token) > 0 {
+			request.Header.Set(jwt.AuthorizationHeaderName, jwt.BearerScheme+" "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+
+		result := response{statusCode: w.Code}
+		json.NewDecoder(w.Body).Decode(&result.body)
+		result.token = strings.TrimPrefix(w.Header().Get(jwt.AuthorizationHeaderName), jwt.BearerScheme+" ")
+		return result
+	}
+	anonymousToken := func(userID string) string {
+		tokenString, err := jwt.CreateTokenString(userID, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		return tokenString
+	}
+
+	ctx := _context.Background()
+	s.Store(ctx, domain.URL{UserID: "Laptop", Full: "http://www.yandex.ru/laptop", Short: "laptop"})
+	s.Store(ctx, domain.URL{UserID: "Phone", Full: "http://www.yandex.ru/phone", Short: "phone"})
+
+	// регистрация с анонимного устройства: анонимный пользователь становится аккаунтом
+	registered := serve("/api/auth/register", `{"login":"DoomGuy","password":"iddqd-idkfa"}`, anonymousToken("Laptop"))
+	require.Equal(t, http.StatusCreated, registered.statusCode)
+	assert.Equal(t, api.AuthResponse{UserID: "Laptop", Login: "doomguy"}, registered.body)
+	userID, err := jwt.GetUserIDFromTokenString(registered.token)
+	require.NoError(t, err)
+	assert.Equal(t, "Laptop", userID)
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		statusCode int
+	}{
+		{name: "Login is taken (409)", target: "/api/auth/register", body: `{"login":"doomguy","password":"iddqd-idkfa"}`, statusCode: http.StatusConflict},
+		{name: "Short password (400)", target: "/api/auth/register", body: `{"login":"heretic","password":"iddqd"}`, statusCode: http.StatusBadRequest},
+		{name: "Bad JSON (400)", target: "/api/auth/register", body: `{"login":`, statusCode: http.StatusBadRequest},
+		{name: "Wrong password (401)", target: "/api/auth/login", body: `{"login":"doomguy","password":"idclip-idspispopd"}`, statusCode: http.StatusUnauthorized},
+		{name: "Unknown login (401)", target: "/api/auth/login", body: `{"login":"heretic","password":"iddqd-idkfa"}`, statusCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.statusCode, serve(tt.target, tt.body, "").statusCode)
+		})
+	}
+
+	// вход с другого анонимного устройства: его ссылки переносятся в аккаунт
+	loggedIn := serve("/api/auth/login", `{"login":"DOOMGUY","password":"iddqd-idkfa"}`, anonymousToken("Phone"))
+	require.Equal(t, http.StatusOK, loggedIn.statusCode)
+	assert.Equal(t, api.AuthResponse{UserID: "Laptop", Login: "doomguy", Merged: 1}, loggedIn.body)
+
+	urls, err := s.GetUserURLs(ctx, "Laptop")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	// повторный вход с токеном аккаунта ничего не переносит
+	loggedIn = serve("/api/auth/login", `{"login":"doomguy","password":"iddqd-idkfa"}`, loggedIn.token)
+	require.Equal(t, http.StatusOK, loggedIn.statusCode)
+	assert.Equal(t, 0, loggedIn.body.Merged)
+
+	// регистрация из-под аккаунта создает нового пользователя, ссылки аккаунта не переносятся
+	other := serve("/api/auth/register", `{"login":"heretic","password":"iddqd-idkfa"}`, loggedIn.token)
+	require.Equal(t, http.StatusCreated, other.statusCode)
+	assert.NotEqual(t, "Laptop", other.body.UserID)
+
+	// без токена создается новый пользователь
+	other = serve("/api/auth/register", `{"login":"imp","password":"iddqd-idkfa"}`, "")
+	require.Equal(t, http.StatusCreated, other.statusCode)
+	assert.NotEmpty(t, other.body.UserID)
+}
+
+func TestLogin_RateLimit(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	router := NewRouter(NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, nil))
+
+	login := func(ip string, password string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"login":"doomguy","password":"`+password+`"}`))
+		request.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/auth/register", strings.NewReader(`{"login":"doomguy","password":"iddqd-idkfa"}`)))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	for i := 0; i < passwordMaxAttempts; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login("10.0.0.1", "wrong-password").Code)
+	}
+
+	// после исчерпания попыток отказ даже с верным паролем
+	w = login("10.0.0.1", "iddqd-idkfa")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// подбор с чужого IP не блокирует вход владельцу аккаунта
+	assert.Equal(t, http.StatusOK, login("10.0.0.2", "iddqd-idkfa").Code)
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	router := NewRouter(NewHandler(testConfig(), s, keygen.NewRandomGenerator(), nil, nil))
+
+	serve := func(method, target string, headers map[string]string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		for k, v := range headers {
+			request.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request)
+		return w
+	}
+	bearer := func(tokens session.Tokens) map[string]string {
+		return map[string]string{
+			jwt.AuthorizationHeaderName: jwt.BearerScheme + " " + tokens.Access,
+			jwt.RefreshHeaderName:       tokens.Refresh,
+		}
+	}
+
+	tokens, err := session.Issue("DoomGuy")
+	require.NoError(t, err)
+
+	// обмен refresh токена на новую пару
+	w := serve("POST", "/api/auth/refresh", map[string]string{jwt.RefreshHeaderName: tokens.Refresh})
+	require.Equal(t, http.StatusOK, w.Code)
+	var refreshed api.TokensResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&refreshed))
+	assert.Equal(t, "DoomGuy", refreshed.UserID)
+	assert.Equal(t, refreshed.RefreshToken, w.Header().Get(jwt.RefreshHeaderName))
+	assert.Len(t, w.Result().Cookies(), 2)
+
+	// повторное использование refresh токена параллельным запросом возвращает ту же пару
+	w = serve("POST", "/api/auth/refresh", map[string]string{jwt.RefreshHeaderName: tokens.Refresh})
+	require.Equal(t, http.StatusOK, w.Code)
+	var reused api.TokensResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&reused))
+	assert.Equal(t, refreshed, reused)
+
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/auth/refresh", map[string]string{jwt.RefreshHeaderName: refreshed.AccessToken}).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/auth/refresh", nil).Code)
+
+	tokens = session.Tokens{Access: refreshed.AccessToken, Refresh: refreshed.RefreshToken}
+	require.Equal(t, http.StatusNoContent, serve("GET", "/api/user/urls", bearer(tokens)).Code)
+
+	// выход отзывает оба токена
+	w = serve("POST", "/api/auth/logout", bearer(tokens))
+	require.Equal(t, http.StatusNoContent, w.Code)
+	for _, c := range w.Result().Cookies() {
+		assert.Empty(t, c.Value)
+		assert.Negative(t, c.MaxAge)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("GET", "/api/user/urls", bearer(tokens)).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/auth/refresh", map[string]string{jwt.RefreshHeaderName: tokens.Refresh}).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/shorten", bearer(tokens)).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/auth/logout", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/auth/logout", map[string]string{jwt.AuthorizationHeaderName: "Bearer idkfa"}).Code)
+}
+
+func TestRevokeToken(t *testing.T) {
+	l, _ := logger.NewLogger()
+	s := inmemory.NewInMemory(l)
+	c := testConfig()
+	c.TrustedSubnet = "192.168.1.0/24"
+	router := NewRouter(NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil))
+
+	tokens, err := session.Issue("DoomGuy")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		realIP string
+		body   string
+		status int
+	}{
+		{name: "IP outside trusted subnet (403)", realIP: "10.0.0.1", body: `{"token":"` + tokens.Access + `"}`, status: http.StatusForbidden},
+		{name: "Bad JSON (400)", realIP: "192.168.1.15", body: `{"token":`, status: http.StatusBadRequest},
+		{name: "Empty token (400)", realIP: "192.168.1.15", body: `{}`, status: http.StatusBadRequest},
+		{name: "Invalid token (400)", realIP: "192.168.1.15", body: `{"token":"idkfa"}`, status: http.StatusBadRequest},
+		{name: "Token is revoked (204)", realIP: "192.168.1.15", body: `{"token":"` + tokens.Access + `"}`, status: http.StatusNoContent},
+		{name: "Token is revoked again (204)", realIP: "192.168.1.15", body: `{"token":"` + tokens.Access + `"}`, status: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/internal/tokens/revoke", strings.NewReader(tt.body))
+			r.Header.Set("X-Real-IP", tt.realIP)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	r.Header.Set(jwt.AuthorizationHeaderName, jwt.BearerScheme+" "+tokens.Access)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
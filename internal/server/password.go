@@ -0,0 +1,113 @@
+package server
+
+import (
+	_context "context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Заголовок с паролем ссылки для текстового эндпоинта создания ссылки.
+const PasswordHeader = "X-Link-Password"
+
+const (
+	// Количество неудачных попыток ввода пароля ссылки в окне passwordAttemptsWindow.
+	passwordMaxAttempts = 5
+
+	// Окно учета неудачных попыток ввода пароля ссылки.
+	passwordAttemptsWindow = 15 * time.Minute
+
+	// Максимальная длина пароля, которую обрабатывает bcrypt.
+	passwordMaxLength = 72
+)
+
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Password required</title>
+</head>
+<body>
+<form method="post" action="/{{.ShortKey}}">
+{{if .Error}}<p>{{.Error}}</p>
+{{end}}<label>Password <input type="password" name="password" autofocus></label>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// Обработка POST /{shortKey}
+// Проверка ограничения неудачных попыток для ссылки
+// Проверка пароля из поля формы password
+// Учет перехода, запись события перехода и редирект на полный URL
+func (h *Handler) UnlockFullURL(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := _context.WithCancel(r.Context())
+	defer cancel()
+
+	shortKey := strings.TrimLeft(r.RequestURI, "/")
+	if allow, retryAfter := h.passwords.Allow(shortKey); !allow {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		http.Error(w, "too many wrong password attempts", http.StatusTooManyRequests)
+
+		return
+	}
+
+	item, ok := h.findAvailable(ctx, w, shortKey)
+	if !ok {
+		return
+	}
+
+	if item.Protected() {
+		err := bcrypt.CompareHashAndPassword([]byte(item.PasswordHash), []byte(r.PostFormValue("password")))
+		if err != nil {
+			h.passwords.Fail(shortKey)
+			renderPasswordForm(w, shortKey, http.StatusUnauthorized, "Wrong password")
+
+			return
+		}
+		h.passwords.Reset(shortKey)
+	}
+
+	if !h.click(ctx, w, item) {
+		return
+	}
+
+	h.recordClick(r, item)
+	w.Header().Set("Location", item.Full)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// Вывод HTML формы ввода пароля для ссылки shortKey.
+func renderPasswordForm(w http.ResponseWriter, shortKey string, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	passwordForm.Execute(w, struct {
+		ShortKey string
+		Error    string
+	}{shortKey, message})
+}
+
+// bcrypt хеш пароля ссылки, пустой пароль - ссылка без пароля.
+func hashPassword(password string) (string, error) {
+	if len(password) == 0 {
+		return "", nil
+	}
+
+	if len(password) > passwordMaxLength {
+		return "", fmt.Errorf("password is longer than %d bytes", passwordMaxLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/mikesvis/short/internal/domain"
 	"github.com/mikesvis/short/internal/middleware"
 )
 
@@ -14,18 +15,42 @@ func NewRouter(h *Handler, middlewares ...func(http.Handler) http.Handler) *chi.
 	r := chi.NewMux()
 	r.Use(middlewares...)
 
+	signIn := middleware.SignIn(h.storage)
+	auth := middleware.Auth(h.storage)
+	identify := middleware.Identify(h.storage)
+	trusted := middleware.TrustedSubnet(h.config.TrustedSubnet)
+
+	// API ключи с нужными правами принимаются везде, где работают SignIn и Auth
+	create := middleware.APIKey(h.storage, domain.APIKeyScopeCreate)
+	read := middleware.APIKey(h.storage, domain.APIKeyScopeRead)
+	remove := middleware.APIKey(h.storage, domain.APIKeyScopeDelete)
+
 	r.Route("/api", func(r chi.Router) {
-		r.With(middleware.SignIn).Post("/shorten/batch", h.CreateShortURLBatch)
-		r.With(middleware.SignIn).Post("/shorten", h.CreateShortURLJSON)
-		r.With(middleware.Auth).Get("/user/urls", h.GetUserURLs)
-		r.With(middleware.Auth).Delete("/user/urls", h.DeleteUserURLs)
+		r.With(create, signIn).Post("/shorten/batch", h.CreateShortURLBatch)
+		r.With(create, signIn).Post("/shorten", h.CreateShortURLJSON)
+		r.With(read, auth).Get("/user/urls", h.GetUserURLs)
+		r.With(read, auth).Get("/user/urls/{short}/stats", h.GetUserURLStats)
+		r.With(remove, auth).Delete("/user/urls", h.DeleteUserURLs)
+		r.With(read, auth).Get("/user/deletions/{id}", h.GetDeletion)
+		// ключами управляет только владелец по токену, API ключом ключи не выпускаются
+		r.With(auth).Post("/user/keys", h.CreateAPIKey)
+		r.With(auth).Get("/user/keys", h.GetAPIKeys)
+		r.With(auth).Delete("/user/keys/{id}", h.RevokeAPIKey)
+		// токен необязателен: по нему ссылки анонимного пользователя переходят в аккаунт
+		r.With(identify).Post("/auth/register", h.Register)
+		r.With(identify).Post("/auth/login", h.Login)
+		r.Post("/auth/refresh", h.RefreshTokens)
+		r.Post("/auth/logout", h.Logout)
+		r.With(trusted).Get("/internal/stats", h.GetInternalStats)
+		r.With(trusted).Post("/internal/tokens/revoke", h.RevokeToken)
 	})
 
 	r.Route("/", func(r chi.Router) {
 		r.Mount("/debug", chiMiddleware.Profiler())
 		r.Get("/ping", h.Ping)
 		r.Get("/{shortKey}", h.GetFullURL)
-		r.With(middleware.SignIn).Post("/", h.CreateShortURLText)
+		r.Post("/{shortKey}", h.UnlockFullURL)
+		r.With(create, signIn).Post("/", h.CreateShortURLText)
 		r.Get("/", h.Fail)
 		r.Patch("/", h.Fail)
 		r.Put("/", h.Fail)
@@ -5,12 +5,14 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/mikesvis/short/internal/config"
 	"github.com/mikesvis/short/internal/jwt"
+	"github.com/mikesvis/short/internal/keygen"
 	"github.com/mikesvis/short/internal/logger"
 	"github.com/mikesvis/short/internal/middleware"
 	"github.com/mikesvis/short/internal/storage"
@@ -18,6 +20,15 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// Ключи подписи токенов задаются при старте сервиса, в тестах - общим секретом.
+func TestMain(m *testing.M) {
+	if err := jwt.SetKeys(jwt.KeySetFromSecret("iddqd")); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
 func testRequest(t *testing.T, ts *httptest.Server, method, path string, requestBody io.Reader, cookies []*http.Cookie) (*http.Response, string) {
 	req, err := http.NewRequest(method, ts.URL+path, requestBody)
 	require.NoError(t, err)
@@ -48,7 +59,7 @@ func testServer() *httptest.Server {
 	}
 	l, _ := logger.NewLogger()
 	s, _ := storage.NewStorage(c, l)
-	h := NewHandler(c, s)
+	h := NewHandler(c, s, keygen.NewRandomGenerator(), nil, nil)
 	return httptest.NewServer(NewRouter(h, middleware.RequestResponseLogger(l)))
 }
 
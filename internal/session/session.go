@@ -0,0 +1,273 @@
+// Модуль сессий пользователей: выдача пары токенов доступа и refresh, их ротация и отзыв.
+package session
+
+import (
+	_context "context"
+	_errors "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	_jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/mikesvis/short/internal/jwt"
+	"github.com/mikesvis/short/internal/storage"
+)
+
+// Окно, в течение которого повторное использование только что обмененного refresh токена возвращает
+// ту же новую пару: параллельные запросы клиента с одним refresh токеном не получают 401.
+const RefreshGracePeriod = 30 * time.Second
+
+// Пара токенов пользователя.
+type Tokens struct {
+	// ID пользователя.
+	UserID string
+
+	// Короткоживущий токен доступа.
+	Access string
+
+	// Время истечения токена доступа.
+	AccessExpiresAt time.Time
+
+	// Refresh токен, на него выдается новая пара токенов.
+	Refresh string
+
+	// Время истечения refresh токена.
+	RefreshExpiresAt time.Time
+}
+
+// Выдача новой пары токенов пользователю userID.
+func Issue(userID string) (Tokens, error) {
+	now := time.Now()
+	t := Tokens{
+		UserID:           userID,
+		AccessExpiresAt:  now.Add(jwt.AccessTokenDuration),
+		RefreshExpiresAt: now.Add(jwt.TokenDuration),
+	}
+
+	var err error
+	if t.Access, err = jwt.CreateToken(userID, jwt.AccessToken, t.AccessExpiresAt); err != nil {
+		return Tokens{}, err
+	}
+
+	if t.Refresh, err = jwt.CreateToken(userID, jwt.RefreshToken, t.RefreshExpiresAt); err != nil {
+		return Tokens{}, err
+	}
+
+	return t, nil
+}
+
+// Аутентификация по токену доступа access. Если токена доступа нет или он истек, а refresh токен есть,
+// выдается новая пара токенов (она возвращается в issued, ее нужно передать клиенту). Отозванный токен
+// возвращает ErrTokenRevoked, отсутствие обоих токенов - ErrTokenMissing.
+func Authenticate(ctx _context.Context, s storage.Storage, access string, refresh string) (userID string, issued *Tokens, err error) {
+	if len(access) == 0 && len(refresh) == 0 {
+		return "", nil, errors.ErrTokenMissing
+	}
+
+	if len(access) > 0 {
+		var claims *jwt.Claims
+		claims, err = jwt.ParseToken(access, jwt.AccessToken)
+		if err == nil {
+			if err = checkRevoked(ctx, s, claims); err != nil {
+				return "", nil, err
+			}
+
+			return claims.UserID, nil, nil
+		}
+
+		if len(refresh) == 0 || !_errors.Is(err, _jwt.ErrTokenExpired) {
+			return "", nil, err
+		}
+	}
+
+	tokens, err := Refresh(ctx, s, refresh)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return tokens.UserID, &tokens, nil
+}
+
+// Регистрация: аутентификация как в Authenticate, а если токенов нет, создается новый пользователь и его пара
+// возвращается в issued. Переданный невалидный, истекший без refresh токена или отозванный токен не принимается,
+// нового пользователя по нему не создается. Общая политика для HTTP и gRPC.
+func SignIn(ctx _context.Context, s storage.Storage, access string, refresh string) (userID string, issued *Tokens, err error) {
+	userID, issued, err = Authenticate(ctx, s, access, refresh)
+	if !_errors.Is(err, errors.ErrTokenMissing) {
+		return userID, issued, err
+	}
+
+	tokens, err := Issue(uuid.NewString())
+	if err != nil {
+		return "", nil, err
+	}
+
+	return tokens.UserID, &tokens, nil
+}
+
+// Ротация по refresh токену: токен отзывается и выдается новая пара. Повторное использование refresh токена
+// в течение RefreshGracePeriod после ротации возвращает ту же пару, позже - ErrTokenRevoked.
+func Refresh(ctx _context.Context, s storage.Storage, refresh string) (Tokens, error) {
+	claims, err := jwt.ParseToken(refresh, jwt.RefreshToken)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	if len(claims.ID) == 0 {
+		return Tokens{}, fmt.Errorf("%w: token has no jti", errors.ErrInvalidToken)
+	}
+
+	r, started := rotations.start(claims.ID, time.Now())
+	if !started {
+		<-r.done
+		return r.tokens, r.err
+	}
+
+	r.tokens, r.err = rotate(ctx, s, claims)
+	rotations.finish(claims.ID, r)
+
+	return r.tokens, r.err
+}
+
+// rotate отзывает refresh токен и выдает новую пару.
+func rotate(ctx _context.Context, s storage.Storage, claims *jwt.Claims) (Tokens, error) {
+	if revoker, isRevoker := s.(storage.StorageRevoker); isRevoker {
+		revoked, err := revoker.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			return Tokens{}, err
+		}
+
+		// refresh токен уже использован или отозван
+		if !revoked {
+			return Tokens{}, errors.ErrTokenRevoked
+		}
+	}
+
+	return Issue(claims.UserID)
+}
+
+// Ротация refresh токена: пока она идет, параллельные запросы ждут done, после - получают ее результат
+// до until.
+type rotation struct {
+	done   chan struct{}
+	until  time.Time
+	tokens Tokens
+	err    error
+}
+
+// Ротации refresh токенов по jti за последние RefreshGracePeriod.
+type rotationCache struct {
+	mu sync.Mutex
+	m  map[string]*rotation
+}
+
+var rotations = rotationCache{m: make(map[string]*rotation)}
+
+// start возвращает ротацию токена jti. Если ее еще нет или окно прошлой истекло, создается новая
+// и started = true: ротацию выполняет вызывающий.
+func (c *rotationCache) start(jti string, now time.Time) (r *rotation, started bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, exists := c.m[jti]; exists && (r.until.IsZero() || now.Before(r.until)) {
+		return r, false
+	}
+
+	// старые ротации удаляются при появлении новых
+	for id, r := range c.m {
+		if !r.until.IsZero() && !now.Before(r.until) {
+			delete(c.m, id)
+		}
+	}
+
+	r = &rotation{done: make(chan struct{})}
+	c.m[jti] = r
+
+	return r, true
+}
+
+// finish завершает ротацию токена jti. Результат хранится RefreshGracePeriod, неудача не хранится:
+// следующий запрос повторит ротацию и получит ошибку от storage.
+func (c *rotationCache) finish(jti string, r *rotation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r.until = time.Now().Add(RefreshGracePeriod)
+	if r.err != nil {
+		delete(c.m, jti)
+	}
+	close(r.done)
+}
+
+// forget убирает ротацию токена jti: отозванный явно токен не возвращает пару даже в окне.
+func (c *rotationCache) forget(jti string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, exists := c.m[jti]; exists && !r.until.IsZero() {
+		delete(c.m, jti)
+	}
+}
+
+// Отзыв токена любого типа до его истечения. Подпись токена проверяется, срок - нет: истекший токен
+// и так недействителен, его отзыв ничего не делает. Если storage не хранит отозванные токены,
+// возвращается ErrNotSupported.
+func Revoke(ctx _context.Context, s storage.Storage, tokenString string) error {
+	revoker, isRevoker := s.(storage.StorageRevoker)
+	if !isRevoker {
+		return errors.ErrNotSupported
+	}
+
+	claims, err := jwt.ParseSignedToken(tokenString)
+	if err != nil {
+		return err
+	}
+
+	if len(claims.ID) == 0 || claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: token has no jti or expiration", errors.ErrInvalidToken)
+	}
+
+	if claims.ExpiresAt.Before(time.Now()) {
+		return nil
+	}
+
+	rotations.forget(claims.ID)
+	_, err = revoker.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	return err
+}
+
+// Проверка, что ошибка err - проблема с токеном клиента (нет токена, он невалиден, истек или отозван),
+// а не внутренняя ошибка.
+func Unauthorized(err error) bool {
+	return _errors.Is(err, errors.ErrTokenMissing) ||
+		_errors.Is(err, errors.ErrTokenRevoked) ||
+		_errors.Is(err, errors.ErrInvalidToken) ||
+		_errors.Is(err, errors.ErrEmptyUserID) ||
+		_errors.Is(err, _jwt.ErrSignatureInvalid) ||
+		_errors.Is(err, _jwt.ErrTokenMalformed) ||
+		_errors.Is(err, _jwt.ErrTokenExpired) ||
+		_errors.Is(err, _jwt.ErrTokenNotValidYet) ||
+		_errors.Is(err, _jwt.ErrTokenUnverifiable)
+}
+
+// checkRevoked возвращает ErrTokenRevoked, если токен отозван. Токены без jti выданы до появления отзыва
+// и отозваны быть не могут.
+func checkRevoked(ctx _context.Context, s storage.Storage, claims *jwt.Claims) error {
+	revoker, isRevoker := s.(storage.StorageRevoker)
+	if !isRevoker || len(claims.ID) == 0 {
+		return nil
+	}
+
+	revoked, err := revoker.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return err
+	}
+
+	if revoked {
+		return errors.ErrTokenRevoked
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package session
+
+import (
+	_context "context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/mikesvis/short/internal/drivers/inmemory"
+	"github.com/mikesvis/short/internal/errors"
+	"github.com/mikesvis/short/internal/jwt"
+	"github.com/mikesvis/short/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Ключи подписи токенов задаются при старте сервиса, в тестах - общим секретом.
+func TestMain(m *testing.M) {
+	if err := jwt.SetKeys(jwt.KeySetFromSecret("iddqd")); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// Хранилка без отзыва токенов.
+type urlsOnlyStorage struct {
+	storage.Storage
+}
+
+func TestIssue(t *testing.T) {
+	tokens, err := Issue("DoomGuy")
+	require.NoError(t, err)
+	assert.Equal(t, "DoomGuy", tokens.UserID)
+	assert.True(t, tokens.AccessExpiresAt.Before(tokens.RefreshExpiresAt))
+
+	access, err := jwt.ParseToken(tokens.Access, jwt.AccessToken)
+	require.NoError(t, err)
+	refresh, err := jwt.ParseToken(tokens.Refresh, jwt.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEmpty(t, access.ID)
+	assert.NotEqual(t, access.ID, refresh.ID)
+
+	// refresh токен не авторизует запросы, токен доступа не обновляет пару
+	_, err = jwt.GetUserIDFromTokenString(tokens.Refresh)
+	assert.ErrorIs(t, err, errors.ErrInvalidToken)
+	_, err = jwt.ParseToken(tokens.Access, jwt.RefreshToken)
+	assert.ErrorIs(t, err, errors.ErrInvalidToken)
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := _context.Background()
+	s := inmemory.NewInMemory(nil)
+
+	tokens, err := Issue("DoomGuy")
+	require.NoError(t, err)
+	expired, err := jwt.CreateToken("DoomGuy", jwt.AccessToken, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	revoked, err := Issue("Imp")
+	require.NoError(t, err)
+	require.NoError(t, Revoke(ctx, s, revoked.Access))
+
+	tests := []struct {
+		name    string
+		access  string
+		refresh string
+		userID  string
+		rotated bool
+		wantErr error
+	}{
+		{name: "Access token", access: tokens.Access, userID: "DoomGuy"},
+		{name: "Valid access token is not rotated", access: tokens.Access, refresh: tokens.Refresh, userID: "DoomGuy"},
+		{name: "Expired access token is rotated", access: expired, refresh: tokens.Refresh, userID: "DoomGuy", rotated: true},
+		{name: "Reused refresh token within grace period", access: expired, refresh: tokens.Refresh, userID: "DoomGuy", rotated: true},
+		{name: "Expired access token without refresh", access: expired, wantErr: _jwt.ErrTokenExpired},
+		{name: "Revoked access token", access: revoked.Access, refresh: revoked.Refresh, wantErr: errors.ErrTokenRevoked},
+		{name: "Refresh token only", refresh: revoked.Refresh, userID: "Imp", rotated: true},
+		{name: "Refresh token as access token", access: tokens.Refresh, wantErr: errors.ErrInvalidToken},
+		{name: "No tokens", wantErr: errors.ErrTokenMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, issued, err := Authenticate(ctx, s, tt.access, tt.refresh)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.True(t, Unauthorized(err))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.userID, userID)
+			if !tt.rotated {
+				assert.Nil(t, issued)
+				return
+			}
+
+			require.NotNil(t, issued)
+			assert.Equal(t, tt.userID, issued.UserID)
+		})
+	}
+
+	// после окна повторное использование refresh токена отклоняется
+	expireRotations()
+	_, _, err = Authenticate(ctx, s, expired, tokens.Refresh)
+	assert.ErrorIs(t, err, errors.ErrTokenRevoked)
+}
+
+func TestSignIn(t *testing.T) {
+	ctx := _context.Background()
+	s := inmemory.NewInMemory(nil)
+
+	tokens, err := Issue("DoomGuy")
+	require.NoError(t, err)
+	expired, err := jwt.CreateToken("DoomGuy", jwt.AccessToken, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+
+	// без токенов создается новый пользователь
+	userID, issued, err := SignIn(ctx, s, "", "")
+	require.NoError(t, err)
+	require.NotNil(t, issued)
+	assert.NotEmpty(t, userID)
+	assert.Equal(t, userID, issued.UserID)
+
+	// валидный токен авторизует его владельца
+	userID, issued, err = SignIn(ctx, s, tokens.Access, "")
+	require.NoError(t, err)
+	assert.Equal(t, "DoomGuy", userID)
+	assert.Nil(t, issued)
+
+	// истекший без refresh токена и невалидный токены отклоняются, новый пользователь не создается
+	_, issued, err = SignIn(ctx, s, expired, "")
+	assert.ErrorIs(t, err, _jwt.ErrTokenExpired)
+	assert.True(t, Unauthorized(err))
+	assert.Nil(t, issued)
+	_, issued, err = SignIn(ctx, s, "forged", "")
+	assert.True(t, Unauthorized(err))
+	assert.Nil(t, issued)
+}
+
+func TestRefresh_Concurrent(t *testing.T) {
+	ctx := _context.Background()
+	s := inmemory.NewInMemory(nil)
+
+	tokens, err := Issue("DoomGuy")
+	require.NoError(t, err)
+
+	// параллельные запросы с одним refresh токеном получают одну и ту же новую пару
+	const n = 10
+	var wg sync.WaitGroup
+	results := make([]Tokens, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = Refresh(ctx, s, tokens.Refresh)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		require.NoError(t, errs[i])
+		assert.Equal(t, results[0], results[i])
+	}
+	assert.NotEqual(t, tokens.Refresh, results[0].Refresh)
+
+	// явно отозванный токен не возвращает пару даже в окне
+	require.NoError(t, Revoke(ctx, s, tokens.Refresh))
+	_, err = Refresh(ctx, s, tokens.Refresh)
+	assert.ErrorIs(t, err, errors.ErrTokenRevoked)
+}
+
+// expireRotations закрывает окно повторного использования всех обмененных refresh токенов.
+func expireRotations() {
+	rotations.mu.Lock()
+	defer rotations.mu.Unlock()
+
+	for _, r := range rotations.m {
+		r.until = time.Now().Add(-time.Second)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	ctx := _context.Background()
+	s := inmemory.NewInMemory(nil)
+
+	tokens, err := Issue("DoomGuy")
+	require.NoError(t, err)
+	require.NoError(t, Revoke(ctx, s, tokens.Access))
+	require.NoError(t, Revoke(ctx, s, tokens.Access))
+
+	_, _, err = Authenticate(ctx, s, tokens.Access, "")
+	assert.ErrorIs(t, err, errors.ErrTokenRevoked)
+
+	// отозванный refresh токен нельзя обменять на новую пару
+	require.NoError(t, Revoke(ctx, s, tokens.Refresh))
+	_, err = Refresh(ctx, s, tokens.Refresh)
+	assert.ErrorIs(t, err, errors.ErrTokenRevoked)
+
+	// истекший токен отзывать не нужно
+	expired, err := jwt.CreateToken("DoomGuy", jwt.AccessToken, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.NoError(t, Revoke(ctx, s, expired))
+
+	forged, err := _jwt.NewWithClaims(_jwt.SigningMethodHS256, &jwt.Claims{UserID: "Imp"}).SignedString([]byte("mySecretPass"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, Revoke(ctx, s, forged), _jwt.ErrSignatureInvalid)
+
+	assert.ErrorIs(t, Revoke(ctx, urlsOnlyStorage{s}, tokens.Access), errors.ErrNotSupported)
+}
@@ -3,11 +3,13 @@ package storage
 
 import (
 	"context"
+	"time"
 
-	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
 
+	"github.com/mikesvis/short/internal/analytics"
 	"github.com/mikesvis/short/internal/config"
 	"github.com/mikesvis/short/internal/domain"
 	"github.com/mikesvis/short/internal/drivers/filedb"
@@ -31,9 +33,6 @@ type Storage interface {
 
 	// Получение ссылок пользователя.
 	GetUserURLs(ctx context.Context, userID string) ([]domain.URL, error)
-
-	// Генерация случайного ключа
-	GetRandkey(n uint) string
 }
 
 // Интерфейс обеспечивающий метод для прозвона хранилки.
@@ -53,8 +52,86 @@ type StorageCloser interface {
 // Интерфейс обеспечивающий метод для удаления URL из хранилки.
 type StorageDeleter interface {
 	Storage
-	// Пакетное удаление URL.
-	DeleteBatch(ctx context.Context, userID string, pack []string)
+	// Пакетное удаление URL. Возвращает результат удаления по каждому ключу пачки.
+	DeleteBatch(ctx context.Context, userID string, pack []string) (map[string]domain.DeleteStatus, error)
+}
+
+// Интерфейс обеспечивающий метод для удаления истекших URL из хранилки.
+type StorageSweeper interface {
+	Storage
+	// Удаление ссылок, истекших раньше before. Возвращает количество удаленных ссылок.
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// Интерфейс обеспечивающий метод для учета переходов по ссылкам с ограничением переходов.
+type StorageClicker interface {
+	Storage
+	// Атомарный учет перехода по короткой ссылке. Если лимит переходов исчерпан, возвращается ErrClickLimitReached.
+	Click(ctx context.Context, shortURL string) (domain.URL, error)
+}
+
+// Интерфейс обеспечивающий методы подсчета ссылок и пользователей для внутренней статистики.
+type StorageCounter interface {
+	Storage
+	// Количество неудаленных ссылок.
+	CountURLs(ctx context.Context) (int, error)
+	// Количество пользователей, у которых есть неудаленные ссылки.
+	CountUsers(ctx context.Context) (int, error)
+}
+
+// Интерфейс обеспечивающий методы хранения API ключей пользователей.
+type StorageAPIKeyer interface {
+	Storage
+	// Сохранение API ключа.
+	StoreAPIKey(ctx context.Context, key domain.APIKey) error
+	// Получение API ключа по хешу. Если ключа нет, возвращается пустой ключ.
+	GetAPIKeyByHash(ctx context.Context, hash string) (domain.APIKey, error)
+	// Получение API ключей пользователя в порядке создания.
+	GetUserAPIKeys(ctx context.Context, userID string) ([]domain.APIKey, error)
+	// Отзыв API ключа id пользователя userID. Возвращает false, если у пользователя такого ключа нет.
+	RevokeAPIKey(ctx context.Context, userID string, id string) (bool, error)
+}
+
+// Интерфейс обеспечивающий методы хранения аккаунтов пользователей.
+type StorageAccounter interface {
+	Storage
+	// Сохранение аккаунта. Если логин занят, возвращается ErrLoginTaken, если у пользователя уже есть аккаунт - ErrConflict.
+	StoreAccount(ctx context.Context, account domain.Account) error
+	// Получение аккаунта по ID пользователя. Если аккаунта нет, возвращается пустой аккаунт.
+	GetAccount(ctx context.Context, userID string) (domain.Account, error)
+	// Получение аккаунта по логину. Если аккаунта нет, возвращается пустой аккаунт.
+	GetAccountByLogin(ctx context.Context, login string) (domain.Account, error)
+	// Перенос ссылок пользователя fromUserID пользователю toUserID. Возвращает количество перенесенных ссылок.
+	MergeUserURLs(ctx context.Context, fromUserID string, toUserID string) (int, error)
+}
+
+// Интерфейс обеспечивающий методы отзыва токенов (denylist по jti).
+type StorageRevoker interface {
+	Storage
+	// Отзыв токена jti. Запись об отзыве хранится до истечения токена expiresAt, после чего удаляется.
+	// Возвращает false, если токен уже был отозван.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) (bool, error)
+	// Проверка, что токен jti отозван.
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// Интерфейс обеспечивающий хранение принятых заданий на удаление до их выполнения.
+type StorageDeleteJobber interface {
+	Storage
+	// Сохранение задания на удаление.
+	StoreDeleteJob(ctx context.Context, job domain.DeleteJob) error
+	// Удаление выполненного или отброшенного задания. Отсутствие задания ошибкой не считается.
+	RemoveDeleteJob(ctx context.Context, id string) error
+	// Невыполненные задания в порядке постановки.
+	GetDeleteJobs(ctx context.Context) ([]domain.DeleteJob, error)
+}
+
+// Интерфейс обеспечивающий постоянный счетчик последовательных коротких ключей.
+type StorageSequencer interface {
+	Storage
+	// Резервирование size номеров: счетчик увеличивается на size и возвращается его новое значение,
+	// номера (value-size, value] принадлежат вызывающему. Счетчик только растет, удаление ссылок его не уменьшает.
+	ReserveSequence(ctx context.Context, size uint64) (uint64, error)
 }
 
 // Интерфейс, объединяющий прозвон, закрытие и пакетное удаление.
@@ -67,13 +144,14 @@ type StoragePingerCloserDeleter interface {
 // Конструктор хранилки. На основании конфига выбирается движок для хранилки.
 func NewStorage(c *config.Config, logger *zap.SugaredLogger) (Storage, error) {
 	if len(string(c.DatabaseDSN)) != 0 {
-		db, err := sqlx.Open("postgres", string(c.DatabaseDSN))
+		db, err := NewPostgresPool(context.Background(), c)
 		if err != nil {
 			return nil, err
 		}
 
 		postgresStorage, err := postgres.NewPostgres(db, logger)
 		if err != nil {
+			db.Close()
 			return nil, err
 		}
 
@@ -81,8 +159,58 @@ func NewStorage(c *config.Config, logger *zap.SugaredLogger) (Storage, error) {
 	}
 
 	if len(string(c.FileStoragePath)) != 0 {
-		return StoragePinger(filedb.NewFileDB(string(c.FileStoragePath), logger)), nil
+		fileStorage, err := filedb.NewFileDB(
+			string(c.FileStoragePath),
+			filedb.SyncPolicy(c.FileSyncPolicy),
+			time.Duration(c.FileSyncInterval),
+			logger,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return StoragePingerCloserDeleter(fileStorage), nil
 	}
 
 	return inmemory.NewInMemory(logger), nil
 }
+
+// Конструктор хранилки событий переходов. Для storage в базе события хранятся в той же базе,
+// для остальных движков - в памяти.
+func NewAnalyticsStore(s Storage) analytics.Store {
+	if postgresStorage, isPostgres := s.(*postgres.Postgres); isPostgres {
+		return analytics.NewPostgresStore(postgresStorage.DB())
+	}
+
+	return analytics.NewMemoryStore()
+}
+
+// Конструктор пула соединений к базе postgres. Размер пула и время жизни соединений берутся из конфига,
+// нулевые значения оставляют настройки pgxpool по-умолчанию. Запросы кешируются как prepared statements
+// на каждом соединении пула.
+func NewPostgresPool(ctx context.Context, c *config.Config) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(c.DatabaseDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+
+	if c.DatabaseMaxConns > 0 {
+		poolConfig.MaxConns = int32(c.DatabaseMaxConns)
+	}
+
+	if c.DatabaseMinConns > 0 {
+		poolConfig.MinConns = int32(c.DatabaseMinConns)
+	}
+
+	if c.DatabaseMaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = time.Duration(c.DatabaseMaxConnLifetime)
+	}
+
+	if c.DatabaseMaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = time.Duration(c.DatabaseMaxConnIdleTime)
+	}
+
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
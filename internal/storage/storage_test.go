@@ -4,7 +4,6 @@ package storage
 import (
 	"testing"
 
-	_ "github.com/lib/pq"
 	"github.com/mikesvis/short/internal/config"
 	"github.com/mikesvis/short/internal/drivers/filedb"
 	"github.com/mikesvis/short/internal/drivers/inmemory"
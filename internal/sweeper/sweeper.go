@@ -0,0 +1,53 @@
+// Модуль фонового удаления истекших ссылок.
+package sweeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/mikesvis/short/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Sweeper периодически удаляет из хранилки ссылки, истекшие раньше чем retention назад.
+type Sweeper struct {
+	storage   storage.StorageSweeper
+	interval  time.Duration
+	retention time.Duration
+	logger    *zap.SugaredLogger
+}
+
+// Конструктор sweeper'а. interval - период запуска, retention - сколько хранить истекшие ссылки.
+func New(storage storage.StorageSweeper, interval, retention time.Duration, logger *zap.SugaredLogger) *Sweeper {
+	return &Sweeper{storage, interval, retention, logger}
+}
+
+// Запуск периодического удаления, работает до отмены контекста.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(ctx, time.Now())
+		}
+	}
+}
+
+// Удаление ссылок, истекших раньше now минус retention. Возвращает количество удаленных ссылок.
+func (s *Sweeper) Sweep(ctx context.Context, now time.Time) int {
+	deleted, err := s.storage.DeleteExpired(ctx, now.Add(-s.retention))
+	if err != nil {
+		s.logger.Errorw(`Error occured while deleting expired urls`, err)
+		return 0
+	}
+
+	if deleted > 0 {
+		s.logger.Infow(`Expired urls deleted`, `count`, deleted)
+	}
+
+	return deleted
+}
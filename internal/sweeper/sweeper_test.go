@@ -0,0 +1,60 @@
+package sweeper
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mikesvis/short/internal/domain"
+	"github.com/mikesvis/short/internal/drivers/inmemory"
+	"github.com/mikesvis/short/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweeper_Sweep(t *testing.T) {
+	ctx := context.Background()
+	l, _ := logger.NewLogger()
+	now := time.Now()
+	s := inmemory.NewInMemory(l)
+	_, err := s.StoreBatch(ctx, map[string]domain.URL{
+		"1": {UserID: "DoomGuy", Full: "http://idkfa.com", Short: "idkfa", ExpiresAt: now.Add(-time.Hour)},
+		"2": {UserID: "DoomGuy", Full: "http://iddqd.com", Short: "iddqd", ExpiresAt: now.Add(-48 * time.Hour)},
+		"3": {UserID: "DoomGuy", Full: "http://idclip.com", Short: "idclp"},
+	})
+	require.NoError(t, err)
+
+	sw := New(s, time.Hour, 24*time.Hour, l)
+
+	tests := []struct {
+		name  string
+		short string
+		want  string
+	}{
+		{
+			name:  "Expired long ago is deleted",
+			short: "iddqd",
+			want:  "",
+		},
+		{
+			name:  "Recently expired is kept",
+			short: "idkfa",
+			want:  "http://idkfa.com",
+		},
+		{
+			name:  "Without expiration is kept",
+			short: "idclp",
+			want:  "http://idclip.com",
+		},
+	}
+
+	assert.Equal(t, 1, sw.Sweep(ctx, now))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, err := s.GetByShort(ctx, tt.short)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, item.Full)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/mikesvis/short/internal/keygen (interfaces: KeyGenerator)
+
+// Package mock_keygen is a generated GoMock package.
+package mock_keygen
+
+import (
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockKeyGenerator is a mock of KeyGenerator interface.
+type MockKeyGenerator struct {
+	ctrl     *gomock.Controller
+	recorder *MockKeyGeneratorMockRecorder
+}
+
+// MockKeyGeneratorMockRecorder is the mock recorder for MockKeyGenerator.
+type MockKeyGeneratorMockRecorder struct {
+	mock *MockKeyGenerator
+}
+
+// NewMockKeyGenerator creates a new mock instance.
+func NewMockKeyGenerator(ctrl *gomock.Controller) *MockKeyGenerator {
+	mock := &MockKeyGenerator{ctrl: ctrl}
+	mock.recorder = &MockKeyGeneratorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockKeyGenerator) EXPECT() *MockKeyGeneratorMockRecorder {
+	return m.recorder
+}
+
+// Generate mocks base method.
+func (m *MockKeyGenerator) Generate(arg0 string, arg1 uint, arg2 int) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Generate", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// Generate indicates an expected call of Generate.
+func (mr *MockKeyGeneratorMockRecorder) Generate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Generate", reflect.TypeOf((*MockKeyGenerator)(nil).Generate), arg0, arg1, arg2)
+}
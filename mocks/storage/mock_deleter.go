@@ -36,9 +36,12 @@ func (m *MockStorageDeleter) EXPECT() *MockStorageDeleterMockRecorder {
 }
 
 // DeleteBatch mocks base method.
-func (m *MockStorageDeleter) DeleteBatch(arg0 context.Context, arg1 string, arg2 []string) {
+func (m *MockStorageDeleter) DeleteBatch(arg0 context.Context, arg1 string, arg2 []string) (map[string]domain.DeleteStatus, error) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "DeleteBatch", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "DeleteBatch", arg0, arg1, arg2)
+	ret0, _ := ret[0].(map[string]domain.DeleteStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // DeleteBatch indicates an expected call of DeleteBatch.
@@ -77,20 +80,6 @@ func (mr *MockStorageDeleterMockRecorder) GetByShort(arg0, arg1 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByShort", reflect.TypeOf((*MockStorageDeleter)(nil).GetByShort), arg0, arg1)
 }
 
-// GetRandkey mocks base method.
-func (m *MockStorageDeleter) GetRandkey(arg0 uint) string {
-	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetRandkey", arg0)
-	ret0, _ := ret[0].(string)
-	return ret0
-}
-
-// GetRandkey indicates an expected call of GetRandkey.
-func (mr *MockStorageDeleterMockRecorder) GetRandkey(arg0 interface{}) *gomock.Call {
-	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRandkey", reflect.TypeOf((*MockStorageDeleter)(nil).GetRandkey), arg0)
-}
-
 // GetUserURLs mocks base method.
 func (m *MockStorageDeleter) GetUserURLs(arg0 context.Context, arg1 string) ([]domain.URL, error) {
 	m.ctrl.T.Helper()